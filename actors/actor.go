@@ -8,10 +8,12 @@ type Actor interface {
 
 func SpawnActor(actor Actor) ActorRef {
 	cell := actorCell{
-		actor:          actor,
-		state:          actorStopped,
-		messages:       make(chan messageEnvelope, 10),
-		systemMessages: make(chan interface{}),
+		actor:      actor,
+		state:      actorStopped,
+		messages:   make(chan messageEnvelope, 10),
+		stopping:   make(chan struct{}),
+		stopped:    make(chan struct{}),
+		terminated: make(chan struct{}),
 	}
 	cell.start()
 	return &LocalActorRef{
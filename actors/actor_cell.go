@@ -1,5 +1,10 @@
 package actors
 
+import (
+	"context"
+	"sync"
+)
+
 type actorCellState int
 
 const (
@@ -8,40 +13,86 @@ const (
 )
 
 type messageEnvelope struct {
+	ctx     context.Context
 	sender  ActorRef
 	message interface{}
 }
 
 type actorCell struct {
-	actor          Actor
-	state          actorCellState
-	messages       chan messageEnvelope
-	systemMessages chan interface{}
+	actor    Actor
+	state    actorCellState
+	messages chan messageEnvelope
+	// stopping is closed by the first Stop, stopped once the loop has
+	// stopped taking messages and terminated once OnStop has returned.
+	stopping   chan struct{}
+	stopped    chan struct{}
+	terminated chan struct{}
+	stopOnce   sync.Once
 }
 
 func (ac *actorCell) start() {
 	go ac.loop()
 }
 
+func (ac *actorCell) stop() {
+	ac.stopOnce.Do(func() {
+		close(ac.stopping)
+	})
+}
+
+func (ac *actorCell) isStopped() bool {
+	select {
+	case <-ac.stopping:
+		return true
+	default:
+		return false
+	}
+}
+
 func (ac *actorCell) loop() {
 	context := actorContextImpl{
 		self: &LocalActorRef{
 			actorCell: ac,
 		},
 	}
+	defer close(ac.terminated)
 	ac.actor.OnStart(&context)
 	defer ac.actor.OnStop(&context)
 
 	for {
+		// A stop takes priority over messages already in the mailbox.
+		select {
+		case <-ac.stopping:
+			ac.drain()
+			return
+		default:
+		}
+
 		select {
 		case message := <-ac.messages:
+			context.ctx = message.ctx
 			context.message = message.message
 			context.sender = message.sender
 			ac.actor.Receive(&context)
+			context.ctx = nil
 			context.message = nil
 
-		case <-ac.systemMessages:
-			break
+		case <-ac.stopping:
+			ac.drain()
+			return
+		}
+	}
+}
+
+// drain sends whatever is left in the mailbox to dead letters.
+func (ac *actorCell) drain() {
+	close(ac.stopped)
+	for {
+		select {
+		case message := <-ac.messages:
+			localDeadLetter(message.message, message.sender)
+		default:
+			return
 		}
 	}
 }
@@ -1,6 +1,11 @@
 package actors
 
+import "context"
+
 type ActorContext interface {
+	// Context is the one the message was sent with, or
+	// context.Background() if there was none.
+	Context() context.Context
 	Message() interface{}
 	Reply(message interface{})
 	Forward(message interface{}, target ActorRef)
@@ -9,6 +14,7 @@ type ActorContext interface {
 }
 
 type actorContextImpl struct {
+	ctx     context.Context
 	self    ActorRef
 	sender  ActorRef
 	message interface{}
@@ -18,15 +24,22 @@ func (a *actorContextImpl) Self() ActorRef {
 	return a.self
 }
 
+func (a *actorContextImpl) Context() context.Context {
+	if a.ctx == nil {
+		return context.Background()
+	}
+	return a.ctx
+}
+
 func (a *actorContextImpl) Reply(message interface{}) {
-	a.Sender().SendFrom(message, a.Self())
+	a.Sender().SendFromContext(a.Context(), message, a.Self())
 }
 
 func (a *actorContextImpl) Forward(
 	message interface{},
 	target ActorRef,
 ) {
-	target.SendFrom(message, a.Sender())
+	target.SendFromContext(a.Context(), message, a.Sender())
 }
 
 func (a *actorContextImpl) Sender() ActorRef {
@@ -1,13 +1,20 @@
 package actors
 
-import "time"
+import (
+	"context"
+	"time"
+)
 
 type ActorRef interface {
 	Send(interface{})
 	SendFrom(interface{}, ActorRef)
+	SendContext(context.Context, interface{})
+	SendFromContext(context.Context, interface{}, ActorRef)
 	Ask(interface{}) interface{}
 	AskWithTimeout(interface{}, time.Duration) interface{}
+	AskContext(context.Context, interface{}) (interface{}, error)
 	Stop()
+	StopAndWait()
 }
 
 type LocalActorRef struct {
@@ -19,10 +26,35 @@ func (lar *LocalActorRef) Send(message interface{}) {
 }
 
 func (lar *LocalActorRef) SendFrom(message interface{}, sender ActorRef) {
-	lar.actorCell.messages <- messageEnvelope{
+	lar.SendFromContext(context.Background(), message, sender)
+}
+
+func (lar *LocalActorRef) SendContext(
+	ctx context.Context,
+	message interface{},
+) {
+	lar.SendFromContext(ctx, message, nil)
+}
+
+func (lar *LocalActorRef) SendFromContext(
+	ctx context.Context,
+	message interface{},
+	sender ActorRef,
+) {
+	envelope := messageEnvelope{
+		ctx:     ctx,
 		sender:  sender,
 		message: message,
 	}
+	if lar.actorCell.isStopped() {
+		localDeadLetter(message, sender)
+		return
+	}
+	select {
+	case lar.actorCell.messages <- envelope:
+	case <-lar.actorCell.stopping:
+		localDeadLetter(message, sender)
+	}
 }
 
 func (lar *LocalActorRef) Ask(message interface{}) interface{} {
@@ -33,25 +65,55 @@ func (lar *LocalActorRef) AskWithTimeout(
 	message interface{},
 	timeout time.Duration,
 ) interface{} {
-	receiver := make(chan interface{})
-	defer close(receiver)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	response, err := lar.AskContext(ctx, message)
+	if err != nil {
+		panic("Ask timed out")
+	}
+	return response
+}
 
+// AskContext gives up once ctx is done. The context is also passed on with
+// the message.
+func (lar *LocalActorRef) AskContext(
+	ctx context.Context,
+	message interface{},
+) (interface{}, error) {
+	receiver := make(chan interface{}, 1)
 	askActor := SpawnActor(&ChannelActor{receiver})
 	defer askActor.Stop()
 
-	lar.SendFrom(message, askActor)
-
-	timer := time.NewTimer(timeout)
-	defer timer.Stop()
+	lar.SendFromContext(ctx, message, askActor)
 
 	select {
 	case response := <-receiver:
-		return response
-	case <-timer.C:
-		panic("Ask timed out")
+		return response, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
 	}
 }
 
+// Stop tells the actor to stop without waiting for it, so it is safe to
+// call from inside another actor. The message being handled is finished, and
+// the rest of the mailbox and later sends go to dead letters.
+// Stopping an actor again does nothing.
 func (lar *LocalActorRef) Stop() {
-	lar.actorCell.systemMessages <- struct{}{}
+	lar.actorCell.stop()
+}
+
+// StopAndWait stops the actor and returns once its OnStop has run. An actor
+// waiting on itself, or on an actor blocked sending to it, never returns,
+// so actors should use Stop.
+func (lar *LocalActorRef) StopAndWait() {
+	lar.actorCell.stop()
+	<-lar.actorCell.terminated
+}
+
+// isStoppedRef reports whether ref is a local actor that has been stopped.
+// Remote refs can't tell, so they are never reported stopped.
+func isStoppedRef(ref ActorRef) bool {
+	local, ok := ref.(*LocalActorRef)
+	return ok && local.actorCell.isStopped()
 }
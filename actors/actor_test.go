@@ -1,8 +1,10 @@
 package actors_test
 
 import (
+	goctx "context"
 	"sync"
 
+	"github.com/golang/mock/gomock"
 	. "github.com/kphelps/actors/actors"
 	"github.com/kphelps/actors/mocks/actors"
 
@@ -41,7 +43,7 @@ var _ = Describe("Actors", func() {
 
 	AfterEach(func() {
 		for _, actor := range actors {
-			actor.Stop()
+			actor.StopAndWait()
 		}
 	})
 
@@ -84,4 +86,109 @@ var _ = Describe("Actors", func() {
 			Expect(func() { ref.AskWithTimeout(5, 0) }).To(Panic())
 		})
 	})
+
+	Context("Stop", func() {
+		It("Runs OnStop", func() {
+			stopped := make(chan struct{})
+			actor := actors_mocks.NewMockActor(mockCtrl)
+			actor.EXPECT().OnStart(gomock.Any())
+			actor.EXPECT().OnStop(gomock.Any()).Do(func(ActorContext) {
+				close(stopped)
+			})
+			SpawnActor(actor).Stop()
+			Eventually(stopped).Should(BeClosed())
+		})
+
+		It("Does not wait for the message being handled", func() {
+			handling := make(chan struct{})
+			release := make(chan struct{})
+			ref := SpawnActor(NewFunctionActor(func(ActorContext) {
+				close(handling)
+				<-release
+			}))
+			defer close(release)
+			ref.Send(1)
+			Eventually(handling).Should(BeClosed())
+			ref.Stop()
+		})
+
+		It("Can be waited on", func() {
+			stopped := make(chan struct{})
+			actor := actors_mocks.NewMockActor(mockCtrl)
+			actor.EXPECT().OnStart(gomock.Any())
+			actor.EXPECT().OnStop(gomock.Any()).Do(func(ActorContext) {
+				close(stopped)
+			})
+			SpawnActor(actor).StopAndWait()
+			Expect(stopped).To(BeClosed())
+		})
+
+		It("Can be called again", func() {
+			ref := startActor(func(ActorContext) {})
+			ref.Stop()
+			ref.Stop()
+		})
+
+		It("Sends later messages to dead letters", func() {
+			deadLetters := make(chan interface{}, 20)
+			SetDeadLetters(SpawnActor(&ChannelActor{deadLetters}))
+			defer SetDeadLetters(nil)
+
+			ref := startActor(func(ActorContext) {})
+			ref.Stop()
+			for i := 0; i < 20; i++ {
+				ref.Send(i)
+			}
+			Eventually(deadLetters).Should(Receive(Equal(DeadLetter{
+				Message: 0,
+				Err:     ErrActorStopped,
+			})))
+			Eventually(deadLetters).Should(HaveLen(19))
+		})
+	})
+
+	Context("Context", func() {
+		type key struct{}
+
+		It("Is passed to the receiver", func() {
+			var value interface{}
+			ref := startActor(func(context ActorContext) {
+				value = context.Context().Value(key{})
+			})
+			ref.SendContext(goctx.WithValue(goctx.Background(), key{}, "v"), nil)
+			Eventually(getCalls).Should(Equal(1))
+			Expect(value).To(Equal("v"))
+		})
+
+		It("Defaults to the background context", func() {
+			var ctx goctx.Context
+			ref := startActor(func(context ActorContext) {
+				ctx = context.Context()
+			})
+			ref.Send(nil)
+			Eventually(getCalls).Should(Equal(1))
+			Expect(ctx).To(Equal(goctx.Background()))
+		})
+
+		It("Follows forwarded messages", func() {
+			values := make(chan interface{}, 1)
+			target := SpawnActor(NewFunctionActor(func(context ActorContext) {
+				values <- context.Context().Value(key{})
+			}))
+			actors = append(actors, target)
+			ref := startActor(func(context ActorContext) {
+				context.Forward(context.Message(), target)
+			})
+			ref.SendContext(goctx.WithValue(goctx.Background(), key{}, "v"), nil)
+			Eventually(values).Should(Receive(Equal("v")))
+		})
+
+		It("Cancels an ask", func() {
+			ref := startActor(func(ActorContext) {})
+			ctx, cancel := goctx.WithCancel(goctx.Background())
+			cancel()
+			_, err := ref.AskContext(ctx, 5)
+			Expect(err).To(Equal(goctx.Canceled))
+		})
+	})
 })
@@ -2,6 +2,7 @@ package actors
 
 import (
 	"fmt"
+	"sort"
 	"time"
 
 	"github.com/gocql/gocql"
@@ -9,24 +10,87 @@ import (
 	"github.com/kphelps/streams/streams"
 )
 
+type AsyncJournalConfig struct {
+	Cassandra       *gocql.Session
+	SequenceTracker SequenceTracker
+	Impl            AsyncJournalImpl
+	ShardCount      int
+	// Defaults to a CassandraOffsetStore over Cassandra and SequenceTracker.
+	OffsetStore OffsetStore
+	// Zero runs a read side for every known actor. Otherwise actors beyond
+	// the limit wait until a caught-up read side is rotated out.
+	MaxActiveReadSides int
+	// How often caught-up read sides are checked for rotation.
+	ReadSideRotationInterval time.Duration
+	// Picks which of the provider's persistence IDs belong to the journal
+	// when discovering actors on start. Defaults to all of them.
+	OwnsPersistenceID func(persistenceID string) bool
+}
+
 type shardedAsyncJournal struct {
-	shardCount   int
-	writeSide    Actor
-	writeSideRef ActorRef
-	readSide     Actor
-	readSideRef  ActorRef
+	config        AsyncJournalConfig
+	writeSide     Actor
+	writeSideRef  ActorRef
+	discovery     streams.RunnableStream
+	readSides     map[string]ActorRef
+	waiting       []string
+	waitingSet    map[string]bool
+	tickScheduled bool
+	done          chan struct{}
+}
+
+// ReadSides lists the actors whose read sides are running and those waiting
+// for a free slot.
+type ReadSides struct {
+	Active  []string
+	Waiting []string
+}
+
+type GetReadSides struct{}
+
+type asyncJournalDiscovered struct {
+	actorID string
 }
 
+type asyncJournalRotate struct{}
+
+type asyncJournalSeed struct{}
+
+type asyncJournalSeeded struct {
+	persistenceIDs []string
+	err            error
+}
+
+// Tag offsets are only roughly ordered by time, so the live discovery
+// stream starts this far before the persistence IDs are listed.
+const asyncJournalDiscoveryOverlap = time.Minute
+
 func NewAsyncJournal(
 	cassandra *gocql.Session,
 	sequenceTracker SequenceTracker,
 	impl AsyncJournalImpl,
 	shardCount int,
 ) Actor {
+	return NewAsyncJournalWithConfig(AsyncJournalConfig{
+		Cassandra:       cassandra,
+		SequenceTracker: sequenceTracker,
+		Impl:            impl,
+		ShardCount:      shardCount,
+	})
+}
+
+func NewAsyncJournalWithConfig(config AsyncJournalConfig) Actor {
+	if config.ReadSideRotationInterval <= 0 {
+		config.ReadSideRotationInterval = 10 * time.Second
+	}
+	if config.OwnsPersistenceID == nil {
+		config.OwnsPersistenceID = func(string) bool { return true }
+	}
 	return &shardedAsyncJournal{
-		shardCount: shardCount,
-		writeSide:  makeWriteSide(impl, shardCount),
-		readSide:   makeReadSide(cassandra, sequenceTracker, impl, shardCount),
+		config:     config,
+		writeSide:  makeWriteSide(config.Impl, config.ShardCount),
+		readSides:  make(map[string]ActorRef),
+		waitingSet: make(map[string]bool),
 	}
 }
 
@@ -46,44 +110,183 @@ func makeWriteSide(
 	)
 }
 
-func makeReadSide(
-	cassandra *gocql.Session,
-	sequenceTracker SequenceTracker,
-	impl AsyncJournalImpl,
-	shardCount int,
-) Actor {
-	return MakeShardedActor(
-		func(actorID string) Actor {
-			return NewReadSideActor(
-				cassandra,
-				NewAsyncJournalReadSide(impl, actorID),
-				sequenceTracker,
-				time.Second,
-			)
-		},
-		shardCount,
-		impl.GetActorIDFromMessage,
-		impl.GetShardFromMessage,
-	)
+func asyncJournalTag(impl AsyncJournalImpl) string {
+	return fmt.Sprintf("async-journal:%s", impl.Name())
 }
 
+// Actors written before a restart are discovered by listing the provider's
+// persistence IDs, so they are projected without waiting for a new command.
+// Actors created later are picked up from the tag the write side puts on
+// each actor's first event.
 func (saj *shardedAsyncJournal) OnStart(context ActorContext) {
 	saj.writeSideRef = SpawnActor(saj.writeSide)
-	saj.readSideRef = SpawnActor(saj.readSide)
+	saj.done = make(chan struct{})
+
+	self := context.Self()
+	source := NewTagEventSource(
+		asyncJournalTag(saj.config.Impl),
+		gocql.UUIDFromTime(time.Now().Add(-asyncJournalDiscoveryOverlap)),
+		0,
+	)
+	sink := streams.NewSink(func(event PersistentEvent) {
+		self.Send(asyncJournalDiscovered{event.ActorID})
+	})
+	saj.discovery = source.AttachSink(sink)
+	saj.discovery.Open()
+	self.Send(asyncJournalSeed{})
+}
+
+// seed lists the persistence IDs outside the actor, which has to stay free
+// for commands meanwhile. The result goes back to the actor as a message;
+// nothing is sent once the journal has stopped.
+func (saj *shardedAsyncJournal) seed(self ActorRef, done <-chan struct{}) {
+	persistenceIDs, err := GetPersistenceProvider().PersistenceIDs()
+	select {
+	case <-done:
+	default:
+		self.Send(asyncJournalSeeded{persistenceIDs, err})
+	}
+}
+
+// Listing is retried until it succeeds.
+func (saj *shardedAsyncJournal) seeded(
+	context ActorContext,
+	message asyncJournalSeeded,
+) {
+	if message.err != nil {
+		ScheduleOnce(time.Second, context.Self(), asyncJournalSeed{})
+		return
+	}
+	for _, persistenceID := range message.persistenceIDs {
+		if saj.config.OwnsPersistenceID(persistenceID) {
+			saj.ensureReadSide(context, persistenceID)
+		}
+	}
 }
 
 func (saj *shardedAsyncJournal) OnStop(ActorContext) {
+	if saj.done != nil {
+		close(saj.done)
+	}
+	if saj.discovery != nil {
+		saj.discovery.Close()
+	}
+	for _, ref := range saj.readSides {
+		ref.Stop()
+	}
 }
 
 func (saj *shardedAsyncJournal) Receive(context ActorContext) {
-	context.Forward(context.Message(), saj.writeSideRef)
-	// TODO: read sides should always be running
-	saj.readSideRef.Send(context.Message())
+	switch message := context.Message().(type) {
+	case asyncJournalSeed:
+		go saj.seed(context.Self(), saj.done)
+	case asyncJournalSeeded:
+		saj.seeded(context, message)
+	case asyncJournalDiscovered:
+		saj.ensureReadSide(context, message.actorID)
+	case asyncJournalRotate:
+		saj.tickScheduled = false
+		saj.requestStatuses(context)
+	case ProjectionStatus:
+		saj.rotate(context, message)
+	case GetReadSides:
+		context.Reply(saj.readSideList())
+	default:
+		context.Forward(message, saj.writeSideRef)
+		actorID := saj.config.Impl.GetActorIDFromMessage(message)
+		saj.ensureReadSide(context, actorID)
+	}
+}
+
+func (saj *shardedAsyncJournal) ensureReadSide(
+	context ActorContext,
+	actorID string,
+) {
+	_, active := saj.readSides[actorID]
+	if active || saj.waitingSet[actorID] {
+		return
+	}
+	limit := saj.config.MaxActiveReadSides
+	if limit > 0 && len(saj.readSides) >= limit {
+		saj.waiting = append(saj.waiting, actorID)
+		saj.waitingSet[actorID] = true
+		saj.scheduleRotation(context)
+		return
+	}
+	saj.readSides[actorID] = SpawnActor(saj.newReadSide(actorID))
+}
+
+func (saj *shardedAsyncJournal) newReadSide(actorID string) Actor {
+	config := readSideProjectionConfig(
+		saj.config.Cassandra,
+		NewAsyncJournalReadSide(saj.config.Impl, actorID),
+		saj.config.SequenceTracker,
+		time.Second,
+	)
+	config.PersistenceID = actorID
+	if saj.config.OffsetStore != nil {
+		config.OffsetStore = saj.config.OffsetStore
+	}
+	return NewProjection(config)
+}
+
+func (saj *shardedAsyncJournal) scheduleRotation(context ActorContext) {
+	if saj.tickScheduled {
+		return
+	}
+	saj.tickScheduled = true
+	ScheduleOnce(
+		saj.config.ReadSideRotationInterval,
+		context.Self(),
+		asyncJournalRotate{},
+	)
+}
+
+func (saj *shardedAsyncJournal) requestStatuses(context ActorContext) {
+	if len(saj.waiting) == 0 {
+		return
+	}
+	for _, ref := range saj.readSides {
+		ref.SendFrom(GetProjectionStatus{}, context.Self())
+	}
+	saj.scheduleRotation(context)
+}
+
+// A caught-up read side gives up its slot to the longest waiting actor. It
+// is discovered again when its actor next writes.
+func (saj *shardedAsyncJournal) rotate(
+	context ActorContext,
+	status ProjectionStatus,
+) {
+	ref, active := saj.readSides[status.PersistenceID]
+	if !active || status.Lag > 0 || len(saj.waiting) == 0 {
+		return
+	}
+	ref.Stop()
+	delete(saj.readSides, status.PersistenceID)
+
+	next := saj.waiting[0]
+	saj.waiting = saj.waiting[1:]
+	delete(saj.waitingSet, next)
+	saj.ensureReadSide(context, next)
+}
+
+func (saj *shardedAsyncJournal) readSideList() ReadSides {
+	active := make([]string, 0, len(saj.readSides))
+	for actorID := range saj.readSides {
+		active = append(active, actorID)
+	}
+	sort.Strings(active)
+	return ReadSides{
+		Active:  active,
+		Waiting: append([]string{}, saj.waiting...),
+	}
 }
 
 type asyncJournalWriteSide struct {
 	AsyncJournalImpl
-	actorID string
+	actorID  string
+	recorded bool
 }
 
 type asyncJournalReadSide struct {
@@ -134,3 +337,18 @@ func NewAsyncJournalWriteSide(
 func (ajws *asyncJournalWriteSide) PersistenceID() string {
 	return ajws.actorID
 }
+
+func (ajws *asyncJournalWriteSide) HandleRecover(event proto.Message) {
+	ajws.recorded = true
+	ajws.AsyncJournalImpl.HandleRecover(event)
+}
+
+// Only an actor's first event is tagged; the tag is there to discover the
+// actor, and tagging every event would put the whole journal in one tag.
+func (ajws *asyncJournalWriteSide) Tags(event proto.Message) []string {
+	if ajws.recorded {
+		return nil
+	}
+	ajws.recorded = true
+	return []string{asyncJournalTag(ajws.AsyncJournalImpl)}
+}
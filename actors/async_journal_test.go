@@ -0,0 +1,153 @@
+package actors_test
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	. "github.com/kphelps/actors/actors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type testJournalCommand struct {
+	actorID string
+	value   string
+}
+
+type testJournalImpl struct {
+	name      string
+	projected chan string
+}
+
+func (tji *testJournalImpl) Name() string {
+	return tji.name
+}
+
+func (tji *testJournalImpl) GetActorIDFromMessage(message interface{}) string {
+	return message.(testJournalCommand).actorID
+}
+
+func (tji *testJournalImpl) GetShardFromMessage(message interface{}) int {
+	return 0
+}
+
+func (tji *testJournalImpl) Receive(context PersistentContext) {
+	command := context.Message().(testJournalCommand)
+	context.Persist(&wrappers.StringValue{Value: command.value})
+}
+
+func (tji *testJournalImpl) HandleEvent(event proto.Message) {
+}
+
+func (tji *testJournalImpl) HandleRecover(event proto.Message) {
+}
+
+func (tji *testJournalImpl) ReadEvent(event PersistentEvent) (BatchableQuery, error) {
+	tji.projected <- fmt.Sprintf("%s-%d", event.ActorID, event.SequenceID)
+	return nil, nil
+}
+
+var _ = Describe("AsyncJournal", func() {
+	var impl *testJournalImpl
+	var config AsyncJournalConfig
+	var ref ActorRef
+
+	actorID := func(name string) string {
+		return impl.name + "-" + name
+	}
+
+	persist := func(name string, sequenceID uint64) {
+		err := GetPersistenceProvider().PersistEvent(
+			actorID(name),
+			sequenceID,
+			&wrappers.StringValue{Value: "event"},
+		)
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	readSides := func() ReadSides {
+		return ref.Ask(GetReadSides{}).(ReadSides)
+	}
+
+	BeforeEach(func() {
+		name := fmt.Sprintf("journal-%d", time.Now().UnixNano())
+		impl = &testJournalImpl{
+			name:      name,
+			projected: make(chan string, 100),
+		}
+		config = AsyncJournalConfig{
+			Impl:        impl,
+			ShardCount:  1,
+			OffsetStore: NewInMemoryOffsetStore(),
+			OwnsPersistenceID: func(persistenceID string) bool {
+				return strings.HasPrefix(persistenceID, name+"-")
+			},
+		}
+		ConfigurePollingBackoff(PollingBackoff{
+			MinInterval: time.Millisecond,
+			MaxInterval: 10 * time.Millisecond,
+		})
+	})
+
+	AfterEach(func() {
+		ref.StopAndWait()
+		ConfigurePollingBackoff(PollingBackoff{
+			MinInterval: 50 * time.Millisecond,
+			MaxInterval: 5 * time.Second,
+			Multiplier:  2,
+		})
+	})
+
+	It("Projects commands through a read side", func() {
+		ref = SpawnActor(NewAsyncJournalWithConfig(config))
+		ref.Send(testJournalCommand{actorID("a"), "value"})
+		Eventually(impl.projected).Should(Receive(Equal(actorID("a") + "-0")))
+		Expect(readSides().Active).To(Equal([]string{actorID("a")}))
+	})
+
+	It("Starts read sides for actors written before it started", func() {
+		persist("a", 0)
+		persist("a", 1)
+		ref = SpawnActor(NewAsyncJournalWithConfig(config))
+		Eventually(impl.projected).Should(Receive(Equal(actorID("a") + "-0")))
+		Eventually(impl.projected).Should(Receive(Equal(actorID("a") + "-1")))
+	})
+
+	It("Only tags the first event of each actor", func() {
+		ref = SpawnActor(NewAsyncJournalWithConfig(config))
+		ref.Send(testJournalCommand{actorID("a"), "first"})
+		ref.Send(testJournalCommand{actorID("a"), "second"})
+		Eventually(impl.projected).Should(Receive(Equal(actorID("a") + "-1")))
+
+		events, err := GetPersistenceProvider().EventsByTag(
+			"async-journal:"+impl.name,
+			gocql.UUID{},
+			10,
+		)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(events).To(HaveLen(1))
+		Expect(events[0].SequenceID).To(Equal(uint64(0)))
+	})
+
+	It("Rotates caught-up read sides when over the limit", func() {
+		config.MaxActiveReadSides = 1
+		config.ReadSideRotationInterval = 10 * time.Millisecond
+		persist("a", 0)
+		persist("b", 0)
+		ref = SpawnActor(NewAsyncJournalWithConfig(config))
+
+		projected := make([]string, 0)
+		for i := 0; i < 2; i++ {
+			var event string
+			Eventually(impl.projected).Should(Receive(&event))
+			projected = append(projected, event)
+		}
+		Expect(projected).To(ConsistOf(actorID("a")+"-0", actorID("b")+"-0"))
+		Expect(len(readSides().Active)).To(BeNumerically("<=", 1))
+	})
+})
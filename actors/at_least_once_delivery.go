@@ -0,0 +1,187 @@
+package actors
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+type AtLeastOnceDeliveryConfig struct {
+	// Unconfirmed messages are sent again once they have gone this long
+	// without a confirmation.
+	RedeliverInterval time.Duration
+	// Deliver fails while this many messages are unconfirmed.
+	MaxUnconfirmed int
+}
+
+var ErrTooManyUnconfirmed = errors.New("too many unconfirmed deliveries")
+
+// AtLeastOnceDelivery is embedded in a PersistentActor to keep sending
+// messages until their delivery is confirmed. Deliver and ConfirmDelivery
+// are meant to be called while handling persisted events, both live and on
+// recovery, so that the unconfirmed deliveries are rebuilt by replaying
+// the journal. Messages are only sent once recovery has completed.
+//
+// Actors that delete events, or recover from a later FromSequenceID, lose
+// the deliveries those events would have rebuilt, and delivery IDs start
+// again at 1. They must keep a DeliverySnapshot along with whatever else
+// replaces the skipped events, and restore it with SetDeliverySnapshot
+// before the remaining events are replayed.
+type AtLeastOnceDelivery struct {
+	config         AtLeastOnceDeliveryConfig
+	self           ActorRef
+	nextDeliveryID uint64
+	unconfirmed    map[uint64]*unconfirmedDelivery
+}
+
+type unconfirmedDelivery struct {
+	destination ActorRef
+	message     interface{}
+	sentAt      time.Time
+}
+
+// AtLeastOnceDeliverySnapshot is the delivery state rebuilt by replaying
+// the journal up to some event.
+type AtLeastOnceDeliverySnapshot struct {
+	NextDeliveryID uint64
+	Unconfirmed    []UnconfirmedDelivery
+}
+
+type UnconfirmedDelivery struct {
+	DeliveryID  uint64
+	Destination ActorRef
+	Message     interface{}
+}
+
+type atLeastOnceRedeliver struct{}
+
+type atLeastOnceDeliverer interface {
+	atLeastOnceDelivery() *AtLeastOnceDelivery
+}
+
+func NewAtLeastOnceDelivery(config AtLeastOnceDeliveryConfig) *AtLeastOnceDelivery {
+	if config.RedeliverInterval <= 0 {
+		config.RedeliverInterval = 5 * time.Second
+	}
+	if config.MaxUnconfirmed <= 0 {
+		config.MaxUnconfirmed = 100000
+	}
+	return &AtLeastOnceDelivery{
+		config:         config,
+		nextDeliveryID: 1,
+		unconfirmed:    make(map[uint64]*unconfirmedDelivery),
+	}
+}
+
+// Deliver sends destination the message made for the next delivery ID,
+// which the destination is expected to send back so that the actor can
+// persist its confirmation.
+func (ald *AtLeastOnceDelivery) Deliver(
+	destination ActorRef,
+	makeMessage func(deliveryID uint64) interface{},
+) error {
+	if len(ald.unconfirmed) >= ald.config.MaxUnconfirmed {
+		return ErrTooManyUnconfirmed
+	}
+	deliveryID := ald.nextDeliveryID
+	ald.nextDeliveryID++
+	delivery := &unconfirmedDelivery{
+		destination: destination,
+		message:     makeMessage(deliveryID),
+	}
+	ald.unconfirmed[deliveryID] = delivery
+	if ald.self != nil {
+		ald.send(delivery)
+	}
+	return nil
+}
+
+// ConfirmDelivery reports whether deliveryID was still unconfirmed.
+func (ald *AtLeastOnceDelivery) ConfirmDelivery(deliveryID uint64) bool {
+	_, found := ald.unconfirmed[deliveryID]
+	delete(ald.unconfirmed, deliveryID)
+	return found
+}
+
+func (ald *AtLeastOnceDelivery) NumberOfUnconfirmed() int {
+	return len(ald.unconfirmed)
+}
+
+// DeliverySnapshot returns the unconfirmed deliveries in delivery ID order
+// and the ID the next delivery will get.
+func (ald *AtLeastOnceDelivery) DeliverySnapshot() AtLeastOnceDeliverySnapshot {
+	snapshot := AtLeastOnceDeliverySnapshot{
+		NextDeliveryID: ald.nextDeliveryID,
+		Unconfirmed:    make([]UnconfirmedDelivery, 0, len(ald.unconfirmed)),
+	}
+	for _, deliveryID := range ald.sortedDeliveryIDs() {
+		delivery := ald.unconfirmed[deliveryID]
+		snapshot.Unconfirmed = append(snapshot.Unconfirmed, UnconfirmedDelivery{
+			DeliveryID:  deliveryID,
+			Destination: delivery.destination,
+			Message:     delivery.message,
+		})
+	}
+	return snapshot
+}
+
+// SetDeliverySnapshot replaces the unconfirmed deliveries with those in
+// snapshot. They are sent once recovery completes, or with the next
+// redelivery when it already has.
+func (ald *AtLeastOnceDelivery) SetDeliverySnapshot(
+	snapshot AtLeastOnceDeliverySnapshot,
+) {
+	ald.nextDeliveryID = snapshot.NextDeliveryID
+	if ald.nextDeliveryID == 0 {
+		ald.nextDeliveryID = 1
+	}
+	ald.unconfirmed = make(map[uint64]*unconfirmedDelivery)
+	for _, delivery := range snapshot.Unconfirmed {
+		ald.unconfirmed[delivery.DeliveryID] = &unconfirmedDelivery{
+			destination: delivery.Destination,
+			message:     delivery.Message,
+		}
+		if delivery.DeliveryID >= ald.nextDeliveryID {
+			ald.nextDeliveryID = delivery.DeliveryID + 1
+		}
+	}
+}
+
+func (ald *AtLeastOnceDelivery) atLeastOnceDelivery() *AtLeastOnceDelivery {
+	return ald
+}
+
+// start sends everything left unconfirmed by recovery.
+func (ald *AtLeastOnceDelivery) start(self ActorRef) {
+	ald.self = self
+	for _, deliveryID := range ald.sortedDeliveryIDs() {
+		ald.send(ald.unconfirmed[deliveryID])
+	}
+	ScheduleOnce(ald.config.RedeliverInterval, self, atLeastOnceRedeliver{})
+}
+
+func (ald *AtLeastOnceDelivery) redeliver() {
+	for _, deliveryID := range ald.sortedDeliveryIDs() {
+		delivery := ald.unconfirmed[deliveryID]
+		if time.Since(delivery.sentAt) >= ald.config.RedeliverInterval {
+			ald.send(delivery)
+		}
+	}
+	ScheduleOnce(ald.config.RedeliverInterval, ald.self, atLeastOnceRedeliver{})
+}
+
+func (ald *AtLeastOnceDelivery) send(delivery *unconfirmedDelivery) {
+	delivery.sentAt = time.Now()
+	delivery.destination.SendFrom(delivery.message, ald.self)
+}
+
+func (ald *AtLeastOnceDelivery) sortedDeliveryIDs() []uint64 {
+	deliveryIDs := make([]uint64, 0, len(ald.unconfirmed))
+	for deliveryID := range ald.unconfirmed {
+		deliveryIDs = append(deliveryIDs, deliveryID)
+	}
+	sort.Slice(deliveryIDs, func(i, j int) bool {
+		return deliveryIDs[i] < deliveryIDs[j]
+	})
+	return deliveryIDs
+}
@@ -0,0 +1,188 @@
+package actors_test
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	. "github.com/kphelps/actors/actors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type deliverySend struct {
+	text string
+}
+
+type deliveryMessage struct {
+	deliveryID uint64
+	text       string
+}
+
+type deliveryConfirm struct {
+	deliveryID uint64
+}
+
+type deliveryTruncate struct{}
+
+type deliveringActor struct {
+	*AtLeastOnceDelivery
+	id          string
+	destination ActorRef
+	errors      chan error
+	snapshot    AtLeastOnceDeliverySnapshot
+	snapshots   chan AtLeastOnceDeliverySnapshot
+}
+
+func (da *deliveringActor) PersistenceID() string {
+	return da.id
+}
+
+func (da *deliveringActor) Receive(context PersistentContext) {
+	switch message := context.Message().(type) {
+	case deliverySend:
+		context.Persist(&wrappers.StringValue{Value: message.text})
+	case deliveryConfirm:
+		context.Persist(&wrappers.UInt64Value{Value: message.deliveryID})
+	case deliveryTruncate:
+		da.snapshot = da.DeliverySnapshot()
+		sequenceID, _ := context.LastSequenceID()
+		context.DeleteEvents(sequenceID, PhysicalDeletion)
+	case DeleteEventsSuccess:
+		da.snapshots <- da.snapshot
+	}
+}
+
+func (da *deliveringActor) HandleEvent(event proto.Message) {
+	switch event := event.(type) {
+	case *wrappers.StringValue:
+		err := da.Deliver(da.destination, func(deliveryID uint64) interface{} {
+			return deliveryMessage{deliveryID, event.Value}
+		})
+		if err != nil {
+			da.errors <- err
+		}
+	case *wrappers.UInt64Value:
+		da.ConfirmDelivery(event.Value)
+	}
+}
+
+func (da *deliveringActor) HandleRecover(event proto.Message) {
+	da.HandleEvent(event)
+}
+
+var _ = Describe("AtLeastOnceDelivery", func() {
+	var id string
+	var received chan interface{}
+	var errors chan error
+	var snapshots chan AtLeastOnceDeliverySnapshot
+	var destination ActorRef
+	var ref ActorRef
+
+	newActor := func() *deliveringActor {
+		return &deliveringActor{
+			AtLeastOnceDelivery: NewAtLeastOnceDelivery(AtLeastOnceDeliveryConfig{
+				RedeliverInterval: 50 * time.Millisecond,
+				MaxUnconfirmed:    2,
+			}),
+			id:          id,
+			destination: destination,
+			errors:      errors,
+			snapshots:   snapshots,
+		}
+	}
+
+	spawn := func() ActorRef {
+		return SpawnPersistentActor(newActor())
+	}
+
+	BeforeEach(func() {
+		id = fmt.Sprintf("at-least-once-%d", time.Now().UnixNano())
+		received = make(chan interface{}, 100)
+		errors = make(chan error, 10)
+		snapshots = make(chan AtLeastOnceDeliverySnapshot, 1)
+		destination = SpawnActor(&ChannelActor{received})
+		ref = spawn()
+	})
+
+	AfterEach(func() {
+		ref.Stop()
+		destination.Stop()
+	})
+
+	It("Delivers messages with increasing delivery IDs", func() {
+		ref.Send(deliverySend{"a"})
+		ref.Send(deliverySend{"b"})
+		Eventually(received).Should(Receive(Equal(deliveryMessage{1, "a"})))
+		Eventually(received).Should(Receive(Equal(deliveryMessage{2, "b"})))
+	})
+
+	It("Redelivers until confirmed", func() {
+		ref.Send(deliverySend{"a"})
+		Eventually(received).Should(Receive(Equal(deliveryMessage{1, "a"})))
+		Eventually(received).Should(Receive(Equal(deliveryMessage{1, "a"})))
+
+		ref.Send(deliveryConfirm{1})
+		time.Sleep(100 * time.Millisecond)
+		for len(received) > 0 {
+			<-received
+		}
+		Consistently(received, 200*time.Millisecond).ShouldNot(Receive())
+	})
+
+	It("Restores unconfirmed deliveries on recovery", func() {
+		ref.Send(deliverySend{"a"})
+		ref.Send(deliverySend{"b"})
+		ref.Send(deliveryConfirm{1})
+		Eventually(received).Should(Receive(Equal(deliveryMessage{2, "b"})))
+		ref.Stop()
+		for len(received) > 0 {
+			<-received
+		}
+
+		ref = spawn()
+		Eventually(received).Should(Receive(Equal(deliveryMessage{2, "b"})))
+		Consistently(received, 100*time.Millisecond).ShouldNot(
+			Receive(Equal(deliveryMessage{1, "a"})),
+		)
+		ref.Send(deliverySend{"c"})
+		Eventually(received).Should(Receive(Equal(deliveryMessage{3, "c"})))
+	})
+
+	It("Restores a delivery snapshot in place of deleted events", func() {
+		ref.Send(deliverySend{"a"})
+		ref.Send(deliverySend{"b"})
+		ref.Send(deliveryConfirm{1})
+		Eventually(received).Should(Receive(Equal(deliveryMessage{2, "b"})))
+		ref.Send(deliveryTruncate{})
+		var snapshot AtLeastOnceDeliverySnapshot
+		Eventually(snapshots).Should(Receive(&snapshot))
+		Expect(snapshot.NextDeliveryID).To(Equal(uint64(3)))
+		Expect(snapshot.Unconfirmed).To(HaveLen(1))
+		Expect(snapshot.Unconfirmed[0].Message).To(Equal(deliveryMessage{2, "b"}))
+		ref.StopAndWait()
+		for len(received) > 0 {
+			<-received
+		}
+
+		actor := newActor()
+		actor.SetDeliverySnapshot(snapshot)
+		ref = SpawnPersistentActor(actor)
+		Eventually(received).Should(Receive(Equal(deliveryMessage{2, "b"})))
+		ref.Send(deliverySend{"c"})
+		Eventually(received).Should(Receive(Equal(deliveryMessage{3, "c"})))
+	})
+
+	It("Limits the number of unconfirmed deliveries", func() {
+		ref.Send(deliverySend{"a"})
+		ref.Send(deliverySend{"b"})
+		ref.Send(deliverySend{"c"})
+		Eventually(errors).Should(Receive(Equal(ErrTooManyUnconfirmed)))
+
+		ref.Send(deliveryConfirm{1})
+		ref.Send(deliverySend{"d"})
+		Eventually(received).Should(Receive(Equal(deliveryMessage{3, "d"})))
+	})
+})
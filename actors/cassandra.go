@@ -1,6 +1,7 @@
 package actors
 
 import (
+	"context"
 	"fmt"
 	"strings"
 	"time"
@@ -23,6 +24,7 @@ func CassandraConnect(config CassandraConfig) (*gocql.Session, error) {
 
 type BatchableQuery interface {
 	Execute(session *gocql.Session) error
+	ExecuteContext(ctx context.Context, session *gocql.Session) error
 	AddToBatch(batch *gocql.Batch)
 	Merge(q BatchableQuery) BatchableQuery
 	QueryCount() int
@@ -50,7 +52,14 @@ func QueryFromMap(statement string, names []string, inValues map[string]interfac
 }
 
 func (lq *LazyQuery) Execute(session *gocql.Session) error {
-	return session.Query(lq.Statement, lq.Values...).Exec()
+	return lq.ExecuteContext(context.Background(), session)
+}
+
+func (lq *LazyQuery) ExecuteContext(
+	ctx context.Context,
+	session *gocql.Session,
+) error {
+	return session.Query(lq.Statement, lq.Values...).WithContext(ctx).Exec()
 }
 
 func (lq *LazyQuery) AddToBatch(batch *gocql.Batch) {
@@ -80,7 +89,14 @@ func NewLazyQueryBatch(queries ...BatchableQuery) BatchableQuery {
 }
 
 func (lqb *LazyQueryBatch) Execute(session *gocql.Session) error {
-	batch := session.NewBatch(gocql.LoggedBatch)
+	return lqb.ExecuteContext(context.Background(), session)
+}
+
+func (lqb *LazyQueryBatch) ExecuteContext(
+	ctx context.Context,
+	session *gocql.Session,
+) error {
+	batch := session.NewBatch(gocql.LoggedBatch).WithContext(ctx)
 	lqb.AddToBatch(batch)
 	return session.ExecuteBatch(batch)
 }
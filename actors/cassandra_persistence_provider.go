@@ -1,7 +1,10 @@
 package actors
 
 import (
-	"reflect"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
 	"time"
 
 	"github.com/gocql/gocql"
@@ -14,36 +17,50 @@ type CassandraPersistenceProvider struct {
 	session        *gocql.Session
 	keyspace       string
 	partitionCount uint64
+	// Set on the copies made by withContext and applied to every query.
+	ctx context.Context
+	*writeNotifier
 }
 
 func ConfigureCassandraPersistenceProvider(
 	keyspace string,
 ) error {
-	provider := cassandraPersistenceProvider(keyspace)
+	provider := NewCassandraPersistenceProvider(keyspace)
 	return initPersistenceProvider(provider)
 }
 
-func cassandraPersistenceProvider(keyspace string) PersistenceProvider {
+func NewCassandraPersistenceProvider(keyspace string) PersistenceProvider {
 	return &CassandraPersistenceProvider{
 		keyspace:       keyspace,
 		partitionCount: uint64(10),
+		writeNotifier:  &writeNotifier{},
 	}
 }
 
-func (c *CassandraPersistenceProvider) eventSerializer(
-	event proto.Message,
-) ([]byte, error) {
-	return proto.Marshal(event)
+func (c *CassandraPersistenceProvider) withContext(
+	ctx context.Context,
+) *CassandraPersistenceProvider {
+	bound := *c
+	bound.ctx = ctx
+	return &bound
 }
 
-func (c *CassandraPersistenceProvider) eventDeserializer(
-	typeName string,
-	data []byte,
-) (proto.Message, error) {
-	messageType := proto.MessageType(typeName).Elem()
-	message := reflect.New(messageType).Interface().(proto.Message)
-	err := proto.Unmarshal(data, message)
-	return message, err
+func (c *CassandraPersistenceProvider) context() context.Context {
+	if c.ctx != nil {
+		return c.ctx
+	}
+	return context.Background()
+}
+
+func (c *CassandraPersistenceProvider) query(
+	stmt string,
+	values ...interface{},
+) *gocql.Query {
+	q := c.session.Query(stmt, values...)
+	if c.ctx != nil {
+		q = q.WithContext(c.ctx)
+	}
+	return q
 }
 
 func (c *CassandraPersistenceProvider) Initialize() error {
@@ -67,6 +84,26 @@ func (c *CassandraPersistenceProvider) Initialize() error {
 		return err
 	}
 
+	err = c.createActorDeletionsTable()
+	if err != nil {
+		return err
+	}
+
+	err = c.createFailedEventsTable()
+	if err != nil {
+		return err
+	}
+
+	err = c.createTagTables()
+	if err != nil {
+		return err
+	}
+
+	err = c.createDurableStateTable()
+	if err != nil {
+		return err
+	}
+
 	return c.initializeSession(3 * time.Second)
 }
 
@@ -103,7 +140,7 @@ func (c *CassandraPersistenceProvider) createKeyspace() error {
 }
 
 func (c *CassandraPersistenceProvider) createActorEventsTable() error {
-	return c.session.Query(
+	err := c.query(
 		`CREATE TABLE IF NOT EXISTS actor_events (
 			actor_id text,
 			partition_id bigint,
@@ -114,16 +151,99 @@ func (c *CassandraPersistenceProvider) createActorEventsTable() error {
 			PRIMARY KEY ((actor_id, partition_id), sequence_id)
 		)`,
 	).Exec()
+	if err != nil {
+		return err
+	}
+
+	return c.query(
+		`CREATE TABLE IF NOT EXISTS actor_heads (
+			actor_id text,
+			next_sequence_id bigint,
+			PRIMARY KEY (actor_id)
+		)`,
+	).Exec()
 }
 
 func (c *CassandraPersistenceProvider) createSequenceIDTable() error {
-	return c.session.Query(
+	err := c.query(
 		`CREATE TABLE IF NOT EXISTS sequence_ids (
 			name text,
 			sequence_id bigint,
 			PRIMARY KEY (name)
 		)`,
 	).Exec()
+	if err != nil {
+		return err
+	}
+
+	return c.query(
+		`CREATE TABLE IF NOT EXISTS projection_offsets (
+			name text,
+			time_uuid timeuuid,
+			PRIMARY KEY (name)
+		)`,
+	).Exec()
+}
+
+func (c *CassandraPersistenceProvider) createFailedEventsTable() error {
+	return c.query(
+		`CREATE TABLE IF NOT EXISTS failed_events (
+			projection text,
+			actor_id text,
+			sequence_id bigint,
+			error text,
+			failed_at timestamp,
+			PRIMARY KEY (projection, actor_id, sequence_id)
+		)`,
+	).Exec()
+}
+
+func (c *CassandraPersistenceProvider) createActorDeletionsTable() error {
+	return c.query(
+		`CREATE TABLE IF NOT EXISTS actor_deletions (
+			actor_id text,
+			deleted_to bigint,
+			PRIMARY KEY (actor_id)
+		)`,
+	).Exec()
+}
+
+func (c *CassandraPersistenceProvider) createTagTables() error {
+	err := c.query(
+		`CREATE TABLE IF NOT EXISTS tag_events (
+			tag text,
+			bucket bigint,
+			timestamp timeuuid,
+			actor_id text,
+			sequence_id bigint,
+			event blob,
+			event_type text,
+			PRIMARY KEY ((tag, bucket), timestamp, actor_id, sequence_id)
+		)`,
+	).Exec()
+	if err != nil {
+		return err
+	}
+
+	return c.query(
+		`CREATE TABLE IF NOT EXISTS tag_buckets (
+			tag text,
+			bucket bigint,
+			PRIMARY KEY (tag, bucket)
+		)`,
+	).Exec()
+}
+
+func (c *CassandraPersistenceProvider) createDurableStateTable() error {
+	return c.query(
+		`CREATE TABLE IF NOT EXISTS durable_state (
+			persistence_id text,
+			revision bigint,
+			state blob,
+			state_type text,
+			PRIMARY KEY (persistence_id)
+		)`,
+	).Exec()
 }
 
 func (c *CassandraPersistenceProvider) partitionIDFromSequenceID(
@@ -132,22 +252,76 @@ func (c *CassandraPersistenceProvider) partitionIDFromSequenceID(
 	return sequenceID % c.partitionCount
 }
 
+// The head is authoritative once an actor has one: a sequence ID claimed by
+// a writer that failed before its event landed is counted, and left as a gap,
+// so that recovery resumes past it instead of retrying a taken claim.
 func (c *CassandraPersistenceProvider) MaxSequenceID(
 	actorID string,
+) (uint64, error) {
+	nextSequenceID, found, err := c.headSequenceID(actorID)
+	if err != nil {
+		return 0, err
+	}
+	if found {
+		return nextSequenceID - 1, nil
+	}
+
+	maxSequenceID, err := c.storedMaxSequenceID(actorID)
+	if err != nil && err.Error() != "not found" {
+		return 0, err
+	}
+
+	// Physical deletion can remove every stored event, so the deletion
+	// marker keeps the maximum from moving backwards.
+	deletedTo, deleted, deletedErr := c.deletedTo(actorID)
+	if deletedErr != nil {
+		return 0, deletedErr
+	}
+	if deleted && (err != nil || deletedTo > maxSequenceID) {
+		return deletedTo, nil
+	}
+	return maxSequenceID, err
+}
+
+func (c *CassandraPersistenceProvider) headSequenceID(
+	actorID string,
+) (uint64, bool, error) {
+	stmt, names := qb.Select("actor_heads").
+		Columns("next_sequence_id").
+		Where(qb.Eq("actor_id")).
+		ToCql()
+	q := gocqlx.Query(c.query(stmt), names).BindMap(qb.M{
+		"actor_id": actorID,
+	})
+	var nextSequenceID uint64
+	err := gocqlx.Get(&nextSequenceID, q.Query)
+	if err == gocql.ErrNotFound {
+		return 0, false, nil
+	}
+	return nextSequenceID, err == nil, err
+}
+
+func (c *CassandraPersistenceProvider) storedMaxSequenceID(
+	actorID string,
 ) (uint64, error) {
 	maxSequenceID := uint64(0)
+	found := false
 	for i := uint64(0); i < c.partitionCount; i++ {
 		value, err := c.PartitionMaxSequenceID(actorID, i)
 		if err == nil {
-			if value > maxSequenceID {
+			if !found || value > maxSequenceID {
 				maxSequenceID = value
 			}
+			found = true
 		} else if err.Error() == "not found" {
 			continue
 		} else {
 			return 0, err
 		}
 	}
+	if !found {
+		return 0, errors.New("not found")
+	}
 	return maxSequenceID, nil
 }
 
@@ -164,7 +338,7 @@ func (c *CassandraPersistenceProvider) PartitionMaxSequenceID(
 		OrderBy("sequence_id", qb.DESC).
 		Limit(1).
 		ToCql()
-	q := gocqlx.Query(c.session.Query(stmt), names).BindMap(qb.M{
+	q := gocqlx.Query(c.query(stmt), names).BindMap(qb.M{
 		"actor_id":     actorID,
 		"partition_id": partitionID,
 	})
@@ -174,23 +348,56 @@ func (c *CassandraPersistenceProvider) PartitionMaxSequenceID(
 }
 
 type eventEnvelope struct {
+	Timestamp gocql.UUID
 	Event     []byte
 	EventType string
 }
 
+func (c *CassandraPersistenceProvider) deletedTo(
+	actorID string,
+) (uint64, bool, error) {
+	stmt, names := qb.Select("actor_deletions").
+		Columns("deleted_to").
+		Where(qb.Eq("actor_id")).
+		ToCql()
+	q := gocqlx.Query(c.query(stmt), names).BindMap(qb.M{
+		"actor_id": actorID,
+	})
+	var deletedTo uint64
+	err := gocqlx.Get(&deletedTo, q.Query)
+	if err == gocql.ErrNotFound {
+		return 0, false, nil
+	}
+	return deletedTo, err == nil, err
+}
+
 func (c *CassandraPersistenceProvider) GetEvent(
 	actorID string,
 	sequenceID uint64,
+) (PersistentEvent, error) {
+	deletedTo, deleted, err := c.deletedTo(actorID)
+	if err != nil {
+		return PersistentEvent{}, err
+	}
+	if deleted && sequenceID <= deletedTo {
+		return PersistentEvent{}, errors.New("not found")
+	}
+	return c.getEvent(actorID, sequenceID)
+}
+
+func (c *CassandraPersistenceProvider) getEvent(
+	actorID string,
+	sequenceID uint64,
 ) (PersistentEvent, error) {
 	stmt, names := qb.Select("actor_events").
-		Columns("event", "event_type").
+		Columns("timestamp", "event", "event_type").
 		Where(
 			qb.Eq("actor_id"),
 			qb.Eq("partition_id"),
 			qb.Eq("sequence_id"),
 		).
 		ToCql()
-	q := gocqlx.Query(c.session.Query(stmt), names).BindMap(qb.M{
+	q := gocqlx.Query(c.query(stmt), names).BindMap(qb.M{
 		"actor_id":     actorID,
 		"partition_id": c.partitionIDFromSequenceID(sequenceID),
 		"sequence_id":  sequenceID,
@@ -200,9 +407,11 @@ func (c *CassandraPersistenceProvider) GetEvent(
 	if err != nil {
 		return PersistentEvent{}, err
 	}
-	event, err := c.eventDeserializer(envelope.EventType, envelope.Event)
+	event, err := deserializeEvent(envelope.EventType, envelope.Event)
 	return PersistentEvent{
+		ActorID:    actorID,
 		SequenceID: sequenceID,
+		Offset:     envelope.Timestamp,
 		Event:      event,
 	}, err
 }
@@ -212,18 +421,27 @@ func (c *CassandraPersistenceProvider) GetEventsInclusive(
 	minSequenceID uint64,
 	maxSequenceID uint64,
 ) ([]PersistentEvent, error) {
-	length := maxSequenceID - minSequenceID + 1
-	if length < 0 {
-		length = 0
+	deletedTo, deleted, err := c.deletedTo(actorID)
+	if err != nil {
+		return nil, err
+	}
+	if deleted && minSequenceID <= deletedTo {
+		minSequenceID = deletedTo + 1
+	}
+	if minSequenceID > maxSequenceID {
+		return []PersistentEvent{}, nil
 	}
 
-	events := make([]PersistentEvent, length)
+	events := make([]PersistentEvent, 0, maxSequenceID-minSequenceID+1)
 	for i := minSequenceID; i <= maxSequenceID; i++ {
-		event, err := c.GetEvent(actorID, i)
-		if err != nil {
+		event, err := c.getEvent(actorID, i)
+		if err == gocql.ErrNotFound {
+			// Claimed by a writer whose event never landed.
+			continue
+		} else if err != nil {
 			return nil, err
 		}
-		events[i-minSequenceID] = event
+		events = append(events, event)
 	}
 	return events, nil
 }
@@ -233,7 +451,9 @@ func (c *CassandraPersistenceProvider) GetEvents(
 	sequenceID uint64,
 ) ([]PersistentEvent, error) {
 	maxSequenceID, err := c.MaxSequenceID(actorID)
-	if err != nil {
+	if err != nil && err.Error() == "not found" {
+		return []PersistentEvent{}, nil
+	} else if err != nil {
 		return nil, err
 	}
 	return c.GetEventsInclusive(actorID, sequenceID, maxSequenceID)
@@ -254,18 +474,451 @@ func (c *CassandraPersistenceProvider) PersistEvent(
 			"event_type",
 		).
 		ToCql()
-	serializedEvent, err := c.eventSerializer(event)
+	event, tags := untagEvent(event)
+	serializedEvent, err := serializeEvent(event)
+	if err != nil {
+		return err
+	}
+	err = c.claimSequenceID(actorID, sequenceID)
 	if err != nil {
 		return err
 	}
 
-	q := gocqlx.Query(c.session.Query(stmt), names).BindMap(qb.M{
+	timestamp := gocql.TimeUUID()
+	var write BatchableQuery = QueryFromMap(stmt, names, qb.M{
 		"actor_id":     actorID,
 		"partition_id": c.partitionIDFromSequenceID(sequenceID),
 		"sequence_id":  sequenceID,
-		"timestamp":    gocql.TimeUUID(),
+		"timestamp":    timestamp,
 		"event":        serializedEvent,
 		"event_type":   proto.MessageName(event),
 	})
-	return q.ExecRelease()
+	// The tag rows go in the same logged batch as the event, so a tag
+	// query can never miss an event that was written.
+	if len(tags) > 0 {
+		queries := []BatchableQuery{write}
+		for _, tag := range tags {
+			queries = append(queries, c.taggedEventQueries(
+				tag,
+				actorID,
+				sequenceID,
+				timestamp,
+				event,
+				serializedEvent,
+			)...)
+		}
+		write = NewLazyQueryBatch(queries...)
+	}
+	err = write.ExecuteContext(c.context(), c.session)
+	if err != nil {
+		releaseErr := c.releaseSequenceID(actorID, sequenceID)
+		if releaseErr != nil {
+			return fmt.Errorf(
+				"%v (releasing sequence ID %d: %v)",
+				err,
+				sequenceID,
+				releaseErr,
+			)
+		}
+		return err
+	}
+	c.notifyWritten(actorID)
+	return nil
+}
+
+// claimSequenceID moves the actor's head past sequenceID in a single
+// lightweight transaction, which fails for a gap, for a sequence ID that was
+// already written or deleted, and for all but one of several concurrent
+// writers.
+func (c *CassandraPersistenceProvider) claimSequenceID(
+	actorID string,
+	sequenceID uint64,
+) error {
+	var q *gocql.Query
+	if sequenceID == 0 {
+		stmt, names := qb.Insert("actor_heads").
+			Columns("actor_id", "next_sequence_id").
+			Unique().
+			ToCql()
+		q = gocqlx.Query(c.query(stmt), names).BindMap(qb.M{
+			"actor_id":         actorID,
+			"next_sequence_id": 1,
+		}).Query
+	} else {
+		q = c.query(
+			`UPDATE actor_heads SET next_sequence_id = ?
+			WHERE actor_id = ?
+			IF next_sequence_id = ?`,
+			sequenceID+1,
+			actorID,
+			sequenceID,
+		)
+	}
+	defer q.Release()
+	current := make(map[string]interface{})
+	applied, err := q.MapScanCAS(current)
+	if err != nil {
+		return err
+	}
+	if applied {
+		return nil
+	}
+	if _, found := current["next_sequence_id"]; found || sequenceID == 0 {
+		return errors.New("Invalid sequenceID")
+	}
+	return c.claimUntrackedSequenceID(actorID, sequenceID)
+}
+
+// Actors written before heads were tracked have no head row, so the first
+// write after an upgrade falls back to reading the journal once.
+func (c *CassandraPersistenceProvider) claimUntrackedSequenceID(
+	actorID string,
+	sequenceID uint64,
+) error {
+	maxSequenceID, err := c.MaxSequenceID(actorID)
+	if err != nil && err.Error() == "not found" {
+		return errors.New("Invalid sequenceID")
+	} else if err != nil {
+		return err
+	}
+	if sequenceID != maxSequenceID+1 {
+		return errors.New("Invalid sequenceID")
+	}
+	stmt, names := qb.Insert("actor_heads").
+		Columns("actor_id", "next_sequence_id").
+		Unique().
+		ToCql()
+	q := gocqlx.Query(c.query(stmt), names).BindMap(qb.M{
+		"actor_id":         actorID,
+		"next_sequence_id": sequenceID + 1,
+	})
+	defer q.Release()
+	applied, err := q.MapScanCAS(make(map[string]interface{}))
+	if err != nil {
+		return err
+	}
+	if !applied {
+		return errors.New("Invalid sequenceID")
+	}
+	return nil
+}
+
+// releaseSequenceID hands a claimed sequence ID back after its event could
+// not be written, so that the actor can retry it. If the claim cannot be
+// released the sequence ID stays a gap, which recovery skips.
+func (c *CassandraPersistenceProvider) releaseSequenceID(
+	actorID string,
+	sequenceID uint64,
+) error {
+	q := c.query(
+		`UPDATE actor_heads SET next_sequence_id = ?
+		WHERE actor_id = ?
+		IF next_sequence_id = ?`,
+		sequenceID,
+		actorID,
+		sequenceID+1,
+	)
+	if sequenceID == 0 {
+		q = c.query(
+			`DELETE FROM actor_heads WHERE actor_id = ? IF next_sequence_id = ?`,
+			actorID,
+			1,
+		)
+	}
+	defer q.Release()
+	_, err := q.MapScanCAS(make(map[string]interface{}))
+	return err
+}
+
+func (c *CassandraPersistenceProvider) tagBucket(timestamp gocql.UUID) int64 {
+	return timestamp.Time().Unix() / int64((24 * time.Hour).Seconds())
+}
+
+func (c *CassandraPersistenceProvider) taggedEventQueries(
+	tag string,
+	actorID string,
+	sequenceID uint64,
+	timestamp gocql.UUID,
+	event proto.Message,
+	serializedEvent []byte,
+) []BatchableQuery {
+	bucket := c.tagBucket(timestamp)
+	bucketStmt, bucketNames := qb.Insert("tag_buckets").
+		Columns("tag", "bucket").
+		ToCql()
+
+	stmt, names := qb.Insert("tag_events").
+		Columns(
+			"tag",
+			"bucket",
+			"timestamp",
+			"actor_id",
+			"sequence_id",
+			"event",
+			"event_type",
+		).
+		ToCql()
+	return []BatchableQuery{
+		QueryFromMap(bucketStmt, bucketNames, qb.M{
+			"tag":    tag,
+			"bucket": bucket,
+		}),
+		QueryFromMap(stmt, names, qb.M{
+			"tag":         tag,
+			"bucket":      bucket,
+			"timestamp":   timestamp,
+			"actor_id":    actorID,
+			"sequence_id": sequenceID,
+			"event":       serializedEvent,
+			"event_type":  proto.MessageName(event),
+		}),
+	}
+}
+
+type tagEventRow struct {
+	Timestamp  gocql.UUID
+	ActorID    string
+	SequenceID uint64
+	Event      []byte
+	EventType  string
+}
+
+func (c *CassandraPersistenceProvider) EventsByTag(
+	tag string,
+	offset gocql.UUID,
+	limit int,
+) ([]PersistentEvent, error) {
+	if offset.Timestamp() == 0 {
+		offset = gocql.UUIDFromTime(time.Unix(0, 0))
+	}
+
+	stmt, names := qb.Select("tag_buckets").
+		Columns("bucket").
+		Where(qb.Eq("tag"), qb.GtOrEq("bucket")).
+		ToCql()
+	var buckets []int64
+	err := gocqlx.Select(
+		&buckets,
+		gocqlx.Query(c.query(stmt), names).BindMap(qb.M{
+			"tag":    tag,
+			"bucket": c.tagBucket(offset),
+		}).Query,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]PersistentEvent, 0)
+	firstVisible := make(map[string]uint64)
+	for _, bucket := range buckets {
+		after := offset
+		// Deleted events are skipped after they are read, so a bucket is
+		// paged until it runs out rather than read once up to the limit.
+		for len(events) < limit {
+			pageSize := limit - len(events)
+			rows, err := c.tagEventPage(tag, bucket, after, pageSize)
+			if err != nil {
+				return nil, err
+			}
+			for _, row := range rows {
+				first, checked := firstVisible[row.ActorID]
+				if !checked {
+					deletedTo, deleted, err := c.deletedTo(row.ActorID)
+					if err != nil {
+						return nil, err
+					}
+					if deleted {
+						first = deletedTo + 1
+					}
+					firstVisible[row.ActorID] = first
+				}
+				if row.SequenceID < first {
+					continue
+				}
+
+				event, err := deserializeEvent(row.EventType, row.Event)
+				if err != nil {
+					return nil, err
+				}
+				events = append(events, PersistentEvent{
+					ActorID:    row.ActorID,
+					SequenceID: row.SequenceID,
+					Offset:     row.Timestamp,
+					Event:      event,
+				})
+			}
+			if len(rows) < pageSize {
+				break
+			}
+			after = rows[len(rows)-1].Timestamp
+		}
+	}
+	return events, nil
+}
+
+func (c *CassandraPersistenceProvider) tagEventPage(
+	tag string,
+	bucket int64,
+	after gocql.UUID,
+	limit int,
+) ([]tagEventRow, error) {
+	stmt, names := qb.Select("tag_events").
+		Columns("timestamp", "actor_id", "sequence_id", "event", "event_type").
+		Where(qb.Eq("tag"), qb.Eq("bucket"), qb.Gt("timestamp")).
+		Limit(uint(limit)).
+		ToCql()
+	var rows []tagEventRow
+	err := gocqlx.Select(
+		&rows,
+		gocqlx.Query(c.query(stmt), names).BindMap(qb.M{
+			"tag":       tag,
+			"bucket":    bucket,
+			"timestamp": after,
+		}).Query,
+	)
+	return rows, err
+}
+
+func (c *CassandraPersistenceProvider) DeleteEvents(
+	actorID string,
+	toSequenceID uint64,
+	mode DeletionMode,
+) error {
+	maxSequenceID, err := c.MaxSequenceID(actorID)
+	if err != nil && err.Error() == "not found" {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if toSequenceID > maxSequenceID {
+		toSequenceID = maxSequenceID
+	}
+
+	deletedTo, deleted, err := c.deletedTo(actorID)
+	if err != nil {
+		return err
+	}
+	if !deleted || toSequenceID > deletedTo {
+		stmt, names := qb.Insert("actor_deletions").
+			Columns("actor_id", "deleted_to").
+			ToCql()
+		err = gocqlx.Query(c.query(stmt), names).BindMap(qb.M{
+			"actor_id":   actorID,
+			"deleted_to": toSequenceID,
+		}).ExecRelease()
+		if err != nil {
+			return err
+		}
+	}
+
+	if mode != PhysicalDeletion {
+		return nil
+	}
+	stmt, names := qb.Delete("actor_events").
+		Where(
+			qb.Eq("actor_id"),
+			qb.Eq("partition_id"),
+			qb.LtOrEq("sequence_id"),
+		).
+		ToCql()
+	for i := uint64(0); i < c.partitionCount; i++ {
+		err = gocqlx.Query(c.query(stmt), names).BindMap(qb.M{
+			"actor_id":     actorID,
+			"partition_id": i,
+			"sequence_id":  toSequenceID,
+		}).ExecRelease()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *CassandraPersistenceProvider) PersistenceIDs() ([]string, error) {
+	seen := make(map[string]bool)
+	iter := c.query(
+		`SELECT DISTINCT actor_id, partition_id FROM actor_events`,
+	).Iter()
+	var actorID string
+	var partitionID uint64
+	for iter.Scan(&actorID, &partitionID) {
+		seen[actorID] = true
+	}
+	err := iter.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	// Physically deleted actors may have no rows left in actor_events.
+	iter = c.query(`SELECT actor_id FROM actor_deletions`).Iter()
+	for iter.Scan(&actorID) {
+		seen[actorID] = true
+	}
+	err = iter.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(seen))
+	for id := range seen {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func (c *CassandraPersistenceProvider) GetEventContext(
+	ctx context.Context,
+	actorID string,
+	sequenceID uint64,
+) (PersistentEvent, error) {
+	return c.withContext(ctx).GetEvent(actorID, sequenceID)
+}
+
+func (c *CassandraPersistenceProvider) GetEventsContext(
+	ctx context.Context,
+	actorID string,
+	sequenceID uint64,
+) ([]PersistentEvent, error) {
+	return c.withContext(ctx).GetEvents(actorID, sequenceID)
+}
+
+func (c *CassandraPersistenceProvider) PersistEventContext(
+	ctx context.Context,
+	actorID string,
+	sequenceID uint64,
+	event proto.Message,
+) error {
+	return c.withContext(ctx).PersistEvent(actorID, sequenceID, event)
+}
+
+func (c *CassandraPersistenceProvider) MaxSequenceIDContext(
+	ctx context.Context,
+	actorID string,
+) (uint64, error) {
+	return c.withContext(ctx).MaxSequenceID(actorID)
+}
+
+func (c *CassandraPersistenceProvider) DeleteEventsContext(
+	ctx context.Context,
+	actorID string,
+	toSequenceID uint64,
+	mode DeletionMode,
+) error {
+	return c.withContext(ctx).DeleteEvents(actorID, toSequenceID, mode)
+}
+
+func (c *CassandraPersistenceProvider) EventsByTagContext(
+	ctx context.Context,
+	tag string,
+	offset gocql.UUID,
+	limit int,
+) ([]PersistentEvent, error) {
+	return c.withContext(ctx).EventsByTag(tag, offset, limit)
+}
+
+func (c *CassandraPersistenceProvider) PersistenceIDsContext(
+	ctx context.Context,
+) ([]string, error) {
+	return c.withContext(ctx).PersistenceIDs()
 }
@@ -0,0 +1,449 @@
+package actors
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+type MemberStatus int
+
+// Statuses only move forward, which is what lets gossip merge by taking
+// the later status.
+const (
+	MemberStatusJoining MemberStatus = iota
+	MemberStatusUp
+	MemberStatusLeaving
+	MemberStatusExiting
+	MemberStatusDown
+	MemberStatusRemoved
+)
+
+type Member struct {
+	Address string
+	// Tells a restarted node apart from its previous incarnation.
+	UID    int64
+	Status MemberStatus
+	// Order in which members came up, assigned by the leader. Lower is
+	// older.
+	UpNumber int
+}
+
+type MemberJoined struct{ Member Member }
+type MemberUp struct{ Member Member }
+type MemberLeft struct{ Member Member }
+type MemberExited struct{ Member Member }
+type MemberDowned struct{ Member Member }
+type MemberRemoved struct{ Member Member }
+type UnreachableMember struct{ Member Member }
+type ReachableMember struct{ Member Member }
+
+// CurrentClusterState is sent to new subscribers before any member events.
+type CurrentClusterState struct {
+	Members     []Member
+	Unreachable []string
+	Leader      string
+}
+
+type ClusterConfig struct {
+	Remote *RemoteSystem
+	// The first seed node starts the cluster when it is this node; every
+	// other node joins through the seeds.
+	SeedNodes       []string
+	GossipInterval  time.Duration
+	FailureDetector PhiAccrualConfig
+	// Unreachable members are marked down after this long. Zero leaves
+	// them unreachable until Down is called.
+	AutoDownAfter time.Duration
+}
+
+const clusterActorName = "cluster"
+
+// Cluster tracks the members of a set of RemoteSystems. Members gossip
+// their view of the cluster to each other every GossipInterval, and the
+// gossip doubles as the heartbeat for failure detection.
+type Cluster struct {
+	remote *RemoteSystem
+	ref    ActorRef
+}
+
+type clusterGossip struct {
+	From    string
+	Members []Member
+}
+
+type clusterTick struct{}
+
+type clusterSubscribe struct {
+	ref ActorRef
+}
+
+type clusterUnsubscribe struct {
+	ref ActorRef
+}
+
+type clusterLeave struct{}
+
+type clusterDown struct {
+	address string
+}
+
+type getClusterState struct{}
+
+func init() {
+	defaultSerializers.RegisterJSON("actors.clusterGossip", clusterGossip{})
+}
+
+func NewCluster(config ClusterConfig) *Cluster {
+	if config.GossipInterval <= 0 {
+		config.GossipInterval = time.Second
+	}
+	if config.FailureDetector.FirstHeartbeatEstimate <= 0 {
+		config.FailureDetector.FirstHeartbeatEstimate = config.GossipInterval
+	}
+	actor := &clusterActor{
+		config: config,
+		self: Member{
+			Address: config.Remote.Address(),
+			UID:     time.Now().UnixNano(),
+			Status:  MemberStatusJoining,
+		},
+		members:     make(map[string]Member),
+		detectors:   make(map[string]*PhiAccrualFailureDetector),
+		unreachable: make(map[string]time.Time),
+		subscribers: make(map[ActorRef]struct{}),
+	}
+	ref := SpawnActor(actor)
+	config.Remote.Register(clusterActorName, ref)
+	return &Cluster{
+		remote: config.Remote,
+		ref:    ref,
+	}
+}
+
+func (c *Cluster) SelfAddress() string {
+	return c.remote.Address()
+}
+
+func (c *Cluster) Remote() *RemoteSystem {
+	return c.remote
+}
+
+func (c *Cluster) State(ctx context.Context) (CurrentClusterState, error) {
+	state, err := c.ref.AskContext(ctx, getClusterState{})
+	if err != nil {
+		return CurrentClusterState{}, err
+	}
+	return state.(CurrentClusterState), nil
+}
+
+// Members excludes removed members.
+func (c *Cluster) Members(ctx context.Context) ([]Member, error) {
+	state, err := c.State(ctx)
+	return state.Members, err
+}
+
+// Subscribe sends ref the CurrentClusterState and then every member event.
+func (c *Cluster) Subscribe(ref ActorRef) {
+	c.ref.Send(clusterSubscribe{ref})
+}
+
+func (c *Cluster) Unsubscribe(ref ActorRef) {
+	c.ref.Send(clusterUnsubscribe{ref})
+}
+
+// Leave hands this node's membership back gracefully. Subscribers see
+// MemberRemoved for this node once the leader has let it go.
+func (c *Cluster) Leave() {
+	c.ref.Send(clusterLeave{})
+}
+
+func (c *Cluster) Down(address string) {
+	c.ref.Send(clusterDown{address})
+}
+
+// Close stops taking part in the cluster without leaving, which other
+// members see as a crash.
+func (c *Cluster) Close() {
+	c.remote.Unregister(clusterActorName)
+	c.ref.Stop()
+}
+
+type clusterActor struct {
+	config      ClusterConfig
+	self        Member
+	members     map[string]Member
+	detectors   map[string]*PhiAccrualFailureDetector
+	unreachable map[string]time.Time
+	subscribers map[ActorRef]struct{}
+	joined      bool
+	stopped     bool
+}
+
+func (ca *clusterActor) OnStart(context ActorContext) {
+	ca.members[ca.self.Address] = ca.self
+	seeds := ca.config.SeedNodes
+	ca.joined = len(seeds) == 0 || seeds[0] == ca.self.Address
+	ca.tick(context)
+}
+
+func (ca *clusterActor) OnStop(context ActorContext) {
+}
+
+func (ca *clusterActor) Receive(context ActorContext) {
+	switch message := context.Message().(type) {
+	case clusterTick:
+		ca.tick(context)
+	case clusterGossip:
+		ca.receiveGossip(message)
+	case clusterSubscribe:
+		ca.subscribers[message.ref] = struct{}{}
+		message.ref.Send(ca.state())
+	case clusterUnsubscribe:
+		delete(ca.subscribers, message.ref)
+	case clusterLeave:
+		member := ca.members[ca.self.Address]
+		if member.Status < MemberStatusLeaving {
+			member.Status = MemberStatusLeaving
+			ca.update(member)
+			ca.gossip()
+		}
+	case clusterDown:
+		member, found := ca.members[message.address]
+		if found && member.Status < MemberStatusDown {
+			member.Status = MemberStatusDown
+			ca.update(member)
+		}
+	case getClusterState:
+		context.Reply(ca.state())
+	}
+}
+
+func (ca *clusterActor) tick(context ActorContext) {
+	if ca.stopped {
+		return
+	}
+	ca.checkReachability(time.Now())
+	if ca.joined && ca.leader() == ca.self.Address {
+		ca.leaderActions()
+	}
+	ca.gossip()
+	ScheduleOnce(ca.config.GossipInterval, context.Self(), clusterTick{})
+}
+
+func (ca *clusterActor) receiveGossip(gossip clusterGossip) {
+	if ca.stopped {
+		return
+	}
+	detector, found := ca.detectors[gossip.From]
+	if found {
+		detector.Heartbeat(time.Now())
+	}
+	for _, member := range gossip.Members {
+		if member.Address == ca.self.Address && member.UID == ca.self.UID {
+			ca.joined = true
+		}
+		current, found := ca.members[member.Address]
+		if found {
+			member = mergeMember(current, member)
+		}
+		ca.update(member)
+	}
+}
+
+func mergeMember(a Member, b Member) Member {
+	if a.UID != b.UID {
+		if a.UID > b.UID {
+			return a
+		}
+		return b
+	}
+	if b.Status > a.Status {
+		a.Status = b.Status
+	}
+	if a.UpNumber == 0 || (b.UpNumber != 0 && b.UpNumber < a.UpNumber) {
+		a.UpNumber = b.UpNumber
+	}
+	return a
+}
+
+// update records member and tells subscribers if its status changed.
+func (ca *clusterActor) update(member Member) {
+	if member.Address == ca.self.Address && member.UID != ca.self.UID {
+		return
+	}
+	previous, found := ca.members[member.Address]
+	ca.members[member.Address] = member
+	if found && previous.UID == member.UID && previous.Status == member.Status {
+		return
+	}
+
+	if member.Address != ca.self.Address {
+		if member.Status == MemberStatusRemoved {
+			delete(ca.detectors, member.Address)
+			delete(ca.unreachable, member.Address)
+		} else if !found || previous.UID != member.UID {
+			detector := NewPhiAccrualFailureDetector(ca.config.FailureDetector)
+			detector.Heartbeat(time.Now())
+			ca.detectors[member.Address] = detector
+		}
+	}
+	ca.publish(memberEvent(member))
+
+	// Once the leader has let us go, or we've been downed, there is nothing
+	// left to take part in.
+	if member.Address == ca.self.Address && member.Status >= MemberStatusExiting {
+		if member.Status != MemberStatusRemoved {
+			member.Status = MemberStatusRemoved
+			ca.members[member.Address] = member
+			ca.publish(memberEvent(member))
+		}
+		ca.stopped = true
+	}
+}
+
+func memberEvent(member Member) interface{} {
+	switch member.Status {
+	case MemberStatusJoining:
+		return MemberJoined{member}
+	case MemberStatusUp:
+		return MemberUp{member}
+	case MemberStatusLeaving:
+		return MemberLeft{member}
+	case MemberStatusExiting:
+		return MemberExited{member}
+	case MemberStatusDown:
+		return MemberDowned{member}
+	default:
+		return MemberRemoved{member}
+	}
+}
+
+func (ca *clusterActor) publish(event interface{}) {
+	for subscriber := range ca.subscribers {
+		subscriber.Send(event)
+	}
+}
+
+func (ca *clusterActor) checkReachability(now time.Time) {
+	for address, detector := range ca.detectors {
+		member := ca.members[address]
+		since, unreachable := ca.unreachable[address]
+		available := detector.IsAvailable(now)
+		if !available && !unreachable {
+			ca.unreachable[address] = now
+			ca.publish(UnreachableMember{member})
+		} else if available && unreachable {
+			delete(ca.unreachable, address)
+			ca.publish(ReachableMember{member})
+		} else if unreachable &&
+			ca.config.AutoDownAfter > 0 &&
+			now.Sub(since) >= ca.config.AutoDownAfter &&
+			member.Status < MemberStatusDown {
+			member.Status = MemberStatusDown
+			ca.update(member)
+		}
+	}
+}
+
+// The leader is the reachable Up member with the lowest address, or the
+// lowest Joining one while nobody is Up yet.
+func (ca *clusterActor) leader() string {
+	leader := ""
+	for _, address := range ca.sortedAddresses() {
+		member := ca.members[address]
+		_, unreachable := ca.unreachable[address]
+		if unreachable {
+			continue
+		}
+		if member.Status == MemberStatusUp {
+			return address
+		}
+		if member.Status == MemberStatusJoining && leader == "" {
+			leader = address
+		}
+	}
+	return leader
+}
+
+func (ca *clusterActor) leaderActions() {
+	upNumber := 0
+	for _, member := range ca.members {
+		if member.UpNumber > upNumber {
+			upNumber = member.UpNumber
+		}
+	}
+	for _, address := range ca.sortedAddresses() {
+		member := ca.members[address]
+		switch member.Status {
+		case MemberStatusJoining:
+			upNumber++
+			member.Status = MemberStatusUp
+			member.UpNumber = upNumber
+		case MemberStatusLeaving:
+			member.Status = MemberStatusExiting
+		case MemberStatusExiting, MemberStatusDown:
+			member.Status = MemberStatusRemoved
+		default:
+			continue
+		}
+		ca.update(member)
+	}
+}
+
+func (ca *clusterActor) gossip() {
+	gossip := clusterGossip{
+		From:    ca.self.Address,
+		Members: make([]Member, 0, len(ca.members)),
+	}
+	targets := make(map[string]struct{})
+	for address, member := range ca.members {
+		gossip.Members = append(gossip.Members, member)
+		if member.Status != MemberStatusRemoved {
+			targets[address] = struct{}{}
+		}
+	}
+	if !ca.joined {
+		for _, seed := range ca.config.SeedNodes {
+			targets[seed] = struct{}{}
+		}
+	}
+	delete(targets, ca.self.Address)
+
+	// Sends to other systems are queued and written by the remote system,
+	// so an unreachable member can't hold up the tick or incoming gossip.
+	for address := range targets {
+		ref, err := ca.config.Remote.ActorFor(address + "/" + clusterActorName)
+		if err == nil {
+			ref.Send(gossip)
+		}
+	}
+}
+
+func (ca *clusterActor) sortedAddresses() []string {
+	addresses := make([]string, 0, len(ca.members))
+	for address := range ca.members {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+	return addresses
+}
+
+func (ca *clusterActor) state() CurrentClusterState {
+	state := CurrentClusterState{
+		Members:     make([]Member, 0, len(ca.members)),
+		Unreachable: make([]string, 0, len(ca.unreachable)),
+		Leader:      ca.leader(),
+	}
+	for _, address := range ca.sortedAddresses() {
+		member := ca.members[address]
+		if member.Status != MemberStatusRemoved {
+			state.Members = append(state.Members, member)
+		}
+		_, unreachable := ca.unreachable[address]
+		if unreachable {
+			state.Unreachable = append(state.Unreachable, address)
+		}
+	}
+	return state
+}
@@ -0,0 +1,395 @@
+package actors
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+type ClusterShardingConfig struct {
+	TypeName              string
+	Cluster               *Cluster
+	ActorConstructor      ActorConstructor
+	ShardCount            int
+	GetActorIDFromMessage GetActorIDFromMessage
+	GetShardFromMessage   GetShardFromMessage
+
+	// How often the coordinator looks for unevenly spread shards, and how
+	// many more shards the busiest region may hold than the idlest before
+	// one is moved.
+	RebalanceInterval  time.Duration
+	RebalanceThreshold int
+	// How often a region re-registers and asks again for shard homes it is
+	// still waiting on.
+	RetryInterval time.Duration
+	// How long a hand off waits on regions that have not acknowledged it,
+	// and then on the owner to stop the shard, before going ahead without
+	// them.
+	HandOffTimeout time.Duration
+	// How many messages a region holds for each shard whose home it is
+	// waiting on. Further messages go to dead letters.
+	BufferSize int
+
+	// Remembered entities also keep their shards running: a shard is
+	// started on its new home as soon as it moves rather than when the
+	// next message for it arrives.
+	RememberEntities RememberEntitiesConfig
+}
+
+// Sent between regions and the coordinator, so every field is exported.
+type shardingRegister struct {
+	Region string
+}
+
+type shardingGetHome struct {
+	Shard  int
+	Region string
+}
+
+type shardingHome struct {
+	Shard  int
+	Region string
+}
+
+type shardingBeginHandOff struct {
+	Shard int
+}
+
+type shardingBeginHandOffAck struct {
+	Shard  int
+	Region string
+}
+
+type shardingHandOff struct {
+	Shard int
+}
+
+type shardingShardStopped struct {
+	Shard int
+}
+
+type shardingRetry struct{}
+
+func init() {
+	defaultSerializers.RegisterJSON("actors.shardingRegister", shardingRegister{})
+	defaultSerializers.RegisterJSON("actors.shardingGetHome", shardingGetHome{})
+	defaultSerializers.RegisterJSON("actors.shardingHome", shardingHome{})
+	defaultSerializers.RegisterJSON("actors.shardingBeginHandOff", shardingBeginHandOff{})
+	defaultSerializers.RegisterJSON("actors.shardingBeginHandOffAck", shardingBeginHandOffAck{})
+	defaultSerializers.RegisterJSON("actors.shardingHandOff", shardingHandOff{})
+	defaultSerializers.RegisterJSON("actors.shardingShardStopped", shardingShardStopped{})
+}
+
+func shardRegionName(typeName string) string {
+	return "sharding/" + typeName
+}
+
+func shardCoordinatorName(typeName string) string {
+	return "sharding/" + typeName + "/coordinator"
+}
+
+// StartClusterSharding is the distributed counterpart of MakeShardedActor.
+// The returned region accepts messages for any entity and routes them to
+// whichever member hosts the entity's shard. Shard homes are handed out by a
+// coordinator running on the oldest member, which keeps them in the journal
+// so that a new coordinator picks up where the last one left off.
+func StartClusterSharding(config ClusterShardingConfig) ActorRef {
+	if config.GetActorIDFromMessage == nil {
+		config.GetActorIDFromMessage = DefaultActorIDFromMessage
+	}
+	if config.GetShardFromMessage == nil {
+		config.GetShardFromMessage = HashShardFromActorID(
+			config.GetActorIDFromMessage,
+			config.ShardCount,
+		)
+	}
+	if config.RebalanceInterval <= 0 {
+		config.RebalanceInterval = 10 * time.Second
+	}
+	if config.RebalanceThreshold <= 0 {
+		config.RebalanceThreshold = 1
+	}
+	if config.RetryInterval <= 0 {
+		config.RetryInterval = time.Second
+	}
+	if config.HandOffTimeout <= 0 {
+		config.HandOffTimeout = 10 * time.Second
+	}
+	if config.BufferSize <= 0 {
+		config.BufferSize = 1000
+	}
+	config.RememberEntities = config.RememberEntities.withDefaults()
+	ref := SpawnActor(&shardRegion{
+		config:  config,
+		self:    config.Cluster.SelfAddress(),
+		members: make(map[string]Member),
+		homes:   make(map[int]string),
+		shards:  make(map[int]ActorRef),
+		buffers: make(map[int][]bufferedMessage),
+	})
+	config.Cluster.Remote().Register(shardRegionName(config.TypeName), ref)
+	return ref
+}
+
+type bufferedMessage struct {
+	message interface{}
+	sender  ActorRef
+}
+
+type shardRegion struct {
+	config             ClusterShardingConfig
+	self               string
+	members            map[string]Member
+	coordinatorAddress string
+	coordinator        ActorRef
+	localCoordinator   ActorRef
+	homes              map[int]string
+	shards             map[int]ActorRef
+	// Messages for shards whose home is not known yet, or which are being
+	// handed off.
+	buffers map[int][]bufferedMessage
+}
+
+func (sr *shardRegion) OnStart(context ActorContext) {
+	sr.config.Cluster.Subscribe(context.Self())
+	ScheduleOnce(sr.config.RetryInterval, context.Self(), shardingRetry{})
+}
+
+func (sr *shardRegion) OnStop(context ActorContext) {
+	sr.config.Cluster.Unsubscribe(context.Self())
+	for _, shard := range sr.shards {
+		shard.Stop()
+	}
+	sr.stopCoordinator()
+}
+
+func (sr *shardRegion) Receive(context ActorContext) {
+	switch message := context.Message().(type) {
+	case CurrentClusterState:
+		for _, member := range message.Members {
+			sr.members[member.Address] = member
+		}
+		sr.updateCoordinator(context)
+	case MemberJoined, MemberUp, MemberLeft, MemberExited, MemberDowned, MemberRemoved:
+		member := eventMember(message)
+		if member.Status == MemberStatusRemoved {
+			delete(sr.members, member.Address)
+		} else {
+			sr.members[member.Address] = member
+		}
+		sr.updateCoordinator(context)
+		if sr.localCoordinator != nil {
+			sr.localCoordinator.Send(message)
+		}
+	case UnreachableMember, ReachableMember:
+	case shardingHome:
+		sr.homes[message.Shard] = message.Region
+		if message.Region == sr.self && sr.config.RememberEntities.Enabled {
+			sr.getShard(message.Shard)
+		}
+		buffered := sr.buffers[message.Shard]
+		delete(sr.buffers, message.Shard)
+		for _, m := range buffered {
+			sr.deliver(context, m.message, m.sender)
+		}
+	case shardingBeginHandOff:
+		delete(sr.homes, message.Shard)
+		sr.sendToCoordinator(shardingBeginHandOffAck{
+			Shard:  message.Shard,
+			Region: sr.self,
+		})
+	case shardingHandOff:
+		shard, found := sr.shards[message.Shard]
+		if found {
+			shard.Stop()
+			delete(sr.shards, message.Shard)
+		}
+		delete(sr.homes, message.Shard)
+		sr.sendToCoordinator(shardingShardStopped{message.Shard})
+	case GetShardRegionState, GetShardStats, GetEntityIDs:
+		shards := make(map[int]ActorRef, len(sr.shards))
+		for shardID, shard := range sr.shards {
+			shards[shardID] = shard
+		}
+		answerShardQuery(context, shards)
+	case GetClusterShardingStats:
+		sr.answerClusterStats(context, message)
+	case shardingRetry:
+		sr.register()
+		for shard := range sr.buffers {
+			sr.requestHome(shard)
+		}
+		ScheduleOnce(sr.config.RetryInterval, context.Self(), shardingRetry{})
+	default:
+		sr.deliver(context, message, context.Sender())
+	}
+}
+
+func eventMember(event interface{}) Member {
+	switch event := event.(type) {
+	case MemberJoined:
+		return event.Member
+	case MemberUp:
+		return event.Member
+	case MemberLeft:
+		return event.Member
+	case MemberExited:
+		return event.Member
+	case MemberDowned:
+		return event.Member
+	case MemberRemoved:
+		return event.Member
+	case UnreachableMember:
+		return event.Member
+	case ReachableMember:
+		return event.Member
+	}
+	panic(fmt.Sprintf("not a member event: %T", event))
+}
+
+// oldestMember is the Up member with the lowest UpNumber.
+func oldestMember(members map[string]Member) string {
+	oldest := Member{}
+	for _, member := range members {
+		if member.Status != MemberStatusUp {
+			continue
+		}
+		if oldest.Address == "" ||
+			member.UpNumber < oldest.UpNumber ||
+			(member.UpNumber == oldest.UpNumber && member.Address < oldest.Address) {
+			oldest = member
+		}
+	}
+	return oldest.Address
+}
+
+func (sr *shardRegion) updateCoordinator(context ActorContext) {
+	oldest := oldestMember(sr.members)
+	if oldest == sr.coordinatorAddress {
+		return
+	}
+	sr.coordinatorAddress = oldest
+	sr.coordinator = nil
+	if oldest != sr.self {
+		sr.stopCoordinator()
+	}
+	if oldest == "" {
+		return
+	}
+	if oldest == sr.self && sr.localCoordinator == nil {
+		sr.startCoordinator()
+	}
+
+	remote := sr.config.Cluster.Remote()
+	coordinator, err := remote.ActorFor(oldest + "/" + shardCoordinatorName(sr.config.TypeName))
+	if err != nil {
+		return
+	}
+	sr.coordinator = coordinator
+	sr.register()
+	for shard := range sr.buffers {
+		sr.requestHome(shard)
+	}
+}
+
+func (sr *shardRegion) startCoordinator() {
+	sr.localCoordinator = SpawnPersistentActor(newShardCoordinator(sr.config))
+	sr.config.Cluster.Remote().Register(
+		shardCoordinatorName(sr.config.TypeName),
+		sr.localCoordinator,
+	)
+	state := CurrentClusterState{}
+	for _, member := range sr.members {
+		state.Members = append(state.Members, member)
+	}
+	sr.localCoordinator.Send(state)
+}
+
+func (sr *shardRegion) stopCoordinator() {
+	if sr.localCoordinator == nil {
+		return
+	}
+	sr.config.Cluster.Remote().Unregister(shardCoordinatorName(sr.config.TypeName))
+	sr.localCoordinator.Stop()
+	sr.localCoordinator = nil
+}
+
+func (sr *shardRegion) register() {
+	sr.sendToCoordinator(shardingRegister{sr.self})
+}
+
+func (sr *shardRegion) requestHome(shard int) {
+	sr.sendToCoordinator(shardingGetHome{Shard: shard, Region: sr.self})
+}
+
+func (sr *shardRegion) sendToCoordinator(message interface{}) {
+	if sr.coordinator != nil {
+		sr.coordinator.Send(message)
+	}
+}
+
+func (sr *shardRegion) deliver(
+	context ActorContext,
+	message interface{},
+	sender ActorRef,
+) {
+	shard := sr.config.GetShardFromMessage(message)
+	actorID := sr.config.GetActorIDFromMessage(message)
+	err := validateShard(actorID, shard, sr.config.ShardCount)
+	if err != nil {
+		sr.config.Cluster.Remote().deadLetter(actorID, message, sender, err)
+		return
+	}
+	home, known := sr.homes[shard]
+	if !known {
+		if len(sr.buffers[shard]) >= sr.config.BufferSize {
+			sr.config.Cluster.Remote().deadLetter(
+				actorID,
+				message,
+				sender,
+				errors.New("shard buffer full"),
+			)
+			return
+		}
+		sr.buffers[shard] = append(sr.buffers[shard], bufferedMessage{message, sender})
+		if len(sr.buffers[shard]) == 1 {
+			sr.requestHome(shard)
+		}
+		return
+	}
+
+	if home == sr.self {
+		envelope := shardEnvelope{
+			actorID: actorID,
+			message: message,
+		}
+		sr.getShard(shard).SendFrom(envelope, sender)
+		return
+	}
+
+	region, err := sr.config.Cluster.Remote().ActorFor(
+		home + "/" + shardRegionName(sr.config.TypeName),
+	)
+	if err == nil {
+		region.SendFrom(message, sender)
+	}
+}
+
+func (sr *shardRegion) getShard(shardID int) ActorRef {
+	ref, found := sr.shards[shardID]
+	if found {
+		return ref
+	}
+	actor := newActorShard(shardID, sr.config.ActorConstructor)
+	if sr.config.RememberEntities.Enabled {
+		actor = newRememberingActorShard(
+			shardID,
+			sr.config.ActorConstructor,
+			sr.config.TypeName,
+			sr.config.RememberEntities,
+		)
+	}
+	ref = SpawnActor(actor)
+	sr.shards[shardID] = ref
+	return ref
+}
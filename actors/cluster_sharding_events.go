@@ -0,0 +1,28 @@
+package actors
+
+import "github.com/golang/protobuf/proto"
+
+// Written out by hand in the shape protoc-gen-go produces so that the shard
+// coordinator can keep its allocations in the journal.
+
+type shardHomeAllocated struct {
+	Shard  int32  `protobuf:"varint,1,opt,name=shard" json:"shard,omitempty"`
+	Region string `protobuf:"bytes,2,opt,name=region" json:"region,omitempty"`
+}
+
+func (m *shardHomeAllocated) Reset()         { *m = shardHomeAllocated{} }
+func (m *shardHomeAllocated) String() string { return proto.CompactTextString(m) }
+func (*shardHomeAllocated) ProtoMessage()    {}
+
+type shardHomeDeallocated struct {
+	Shard int32 `protobuf:"varint,1,opt,name=shard" json:"shard,omitempty"`
+}
+
+func (m *shardHomeDeallocated) Reset()         { *m = shardHomeDeallocated{} }
+func (m *shardHomeDeallocated) String() string { return proto.CompactTextString(m) }
+func (*shardHomeDeallocated) ProtoMessage()    {}
+
+func init() {
+	proto.RegisterType((*shardHomeAllocated)(nil), "actors.ShardHomeAllocated")
+	proto.RegisterType((*shardHomeDeallocated)(nil), "actors.ShardHomeDeallocated")
+}
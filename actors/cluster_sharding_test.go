@@ -0,0 +1,278 @@
+package actors_test
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/wrappers"
+	. "github.com/kphelps/actors/actors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type shardedCount struct {
+	ActorID string
+}
+
+type shardedCountReply struct {
+	Address string
+	Count   int
+}
+
+func init() {
+	DefaultSerializers().RegisterJSON("actors_test.shardedCount", shardedCount{})
+	DefaultSerializers().RegisterJSON("actors_test.shardedCountReply", shardedCountReply{})
+}
+
+type entityStart struct {
+	address string
+	actorID string
+}
+
+type shardingNode struct {
+	*clusterNode
+	region ActorRef
+}
+
+var _ = Describe("ClusterSharding", func() {
+	var typeName string
+	var nodes []*shardingNode
+
+	startRegion := func(
+		node *clusterNode,
+		typeName string,
+		remember RememberEntitiesConfig,
+		started chan entityStart,
+	) ActorRef {
+		address := node.remote.Address()
+		return StartClusterSharding(ClusterShardingConfig{
+			TypeName: typeName,
+			Cluster:  node.cluster,
+			ActorConstructor: func(actorID string) Actor {
+				if started != nil {
+					started <- entityStart{address, actorID}
+				}
+				count := 0
+				return NewFunctionActor(func(context ActorContext) {
+					count++
+					context.Reply(shardedCountReply{address, count})
+				})
+			},
+			ShardCount: 10,
+			GetActorIDFromMessage: func(message interface{}) string {
+				return message.(shardedCount).ActorID
+			},
+			GetShardFromMessage: func(message interface{}) int {
+				hash := fnv.New32a()
+				hash.Write([]byte(message.(shardedCount).ActorID))
+				return int(hash.Sum32() % 10)
+			},
+			RebalanceInterval: 50 * time.Millisecond,
+			RetryInterval:     20 * time.Millisecond,
+			HandOffTimeout:    200 * time.Millisecond,
+			RememberEntities:  remember,
+		})
+	}
+
+	startShardingNode := func(seeds ...string) *shardingNode {
+		node := startClusterNode(seeds...)
+		region := startRegion(node, typeName, RememberEntitiesConfig{}, nil)
+		return &shardingNode{node, region}
+	}
+
+	count := func(node *shardingNode, actorID string) shardedCountReply {
+		return node.region.Ask(shardedCount{actorID}).(shardedCountReply)
+	}
+
+	hostsOf := func(node *shardingNode, actorIDs []string) map[string]bool {
+		hosts := make(map[string]bool)
+		for _, actorID := range actorIDs {
+			hosts[count(node, actorID).Address] = true
+		}
+		return hosts
+	}
+
+	actorIDs := make([]string, 30)
+	for i := range actorIDs {
+		actorIDs[i] = fmt.Sprintf("entity-%d", i)
+	}
+
+	BeforeEach(func() {
+		typeName = fmt.Sprintf("counter-%d", time.Now().UnixNano())
+		seed := startShardingNode()
+		nodes = []*shardingNode{
+			seed,
+			startShardingNode(seed.remote.Address()),
+			startShardingNode(seed.remote.Address()),
+		}
+		for _, node := range nodes {
+			Eventually(memberStatuses(node.clusterNode)).Should(HaveLen(3))
+		}
+	})
+
+	AfterEach(func() {
+		for _, node := range nodes {
+			node.region.Stop()
+			node.stop()
+		}
+	})
+
+	It("Routes messages for an entity to one place", func() {
+		first := count(nodes[0], "a")
+		second := count(nodes[1], "a")
+		third := count(nodes[2], "a")
+		Expect(second.Address).To(Equal(first.Address))
+		Expect(third.Address).To(Equal(first.Address))
+		Expect(third.Count).To(Equal(3))
+	})
+
+	It("Spreads shards across members", func() {
+		Eventually(func() map[string]bool {
+			return hostsOf(nodes[0], actorIDs)
+		}).Should(HaveLen(3))
+	})
+
+	It("Moves shards onto a member that joins", func() {
+		joined := startShardingNode(nodes[0].remote.Address())
+		nodes = append(nodes, joined)
+		Eventually(func() bool {
+			return hostsOf(nodes[0], actorIDs)[joined.remote.Address()]
+		}, 3*time.Second).Should(BeTrue())
+	})
+
+	It("Hands off the shards of a member that leaves", func() {
+		leaving := nodes[2]
+		leaving.cluster.Leave()
+		Eventually(func() bool {
+			return hostsOf(nodes[0], actorIDs)[leaving.remote.Address()]
+		}, 3*time.Second).Should(BeFalse())
+	})
+
+	It("Hands off shards past a region that stops answering", func() {
+		Eventually(func() map[string]bool {
+			return hostsOf(nodes[0], actorIDs)
+		}).Should(HaveLen(3))
+		leaving := nodes[1].remote.Address()
+		var moving []string
+		for _, actorID := range actorIDs {
+			if count(nodes[0], actorID).Address == leaving {
+				moving = append(moving, actorID)
+			}
+		}
+
+		// The member stays up, so its region is never dropped by the
+		// coordinator and never acknowledges a hand off.
+		nodes[2].region.Stop()
+		nodes[1].cluster.Leave()
+
+		survivor := nodes[0].remote.Address()
+		Eventually(func() bool {
+			for _, actorID := range moving {
+				ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+				reply, err := nodes[0].region.AskContext(ctx, shardedCount{actorID})
+				cancel()
+				if err == nil && reply.(shardedCountReply).Address == survivor {
+					return true
+				}
+			}
+			return false
+		}, 3*time.Second).Should(BeTrue())
+	})
+
+	It("Keeps allocating after a conflicting write to the coordinator's journal", func() {
+		count(nodes[0], actorIDs[0])
+		// A stale coordinator from before a fail over writes one more event.
+		persistenceID := "sharding-coordinator-" + typeName
+		provider := GetPersistenceProvider()
+		max, err := provider.MaxSequenceID(persistenceID)
+		Expect(err).NotTo(HaveOccurred())
+		err = provider.PersistEvent(persistenceID, max+1, &wrappers.StringValue{Value: "stale"})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(hostsOf(nodes[0], actorIDs)).NotTo(BeEmpty())
+	})
+
+	It("Keeps shard homes when the coordinator moves", func() {
+		survivor := nodes[1].remote.Address()
+		var entity string
+		Eventually(func() string {
+			for _, actorID := range actorIDs {
+				if count(nodes[1], actorID).Address == survivor {
+					entity = actorID
+					return actorID
+				}
+			}
+			return ""
+		}).ShouldNot(BeEmpty())
+		before := count(nodes[1], entity).Count
+
+		crashed := nodes[0]
+		crashed.region.Stop()
+		crashed.stop()
+		nodes = nodes[1:]
+		Eventually(memberStatuses(nodes[0].clusterNode), 2*time.Second).Should(HaveLen(2))
+
+		reply := count(nodes[1], entity)
+		Expect(reply.Address).To(Equal(survivor))
+		Expect(reply.Count).To(Equal(before + 1))
+	})
+
+	It("Restarts remembered entities on another member", func() {
+		started := make(chan entityStart, 100)
+		rememberedType := typeName + "-remembered"
+		regions := make([]ActorRef, len(nodes))
+		for i, node := range nodes {
+			regions[i] = startRegion(
+				node.clusterNode,
+				rememberedType,
+				RememberEntitiesConfig{Enabled: true},
+				started,
+			)
+			defer regions[i].Stop()
+		}
+
+		oldest := nodes[0].remote.Address()
+		var leaving, entity string
+		Eventually(func() string {
+			for _, actorID := range actorIDs {
+				reply := regions[0].Ask(shardedCount{actorID}).(shardedCountReply)
+				if reply.Address != oldest {
+					leaving, entity = reply.Address, actorID
+					break
+				}
+			}
+			return leaving
+		}, 3*time.Second).ShouldNot(BeEmpty())
+		for _, node := range nodes {
+			if node.remote.Address() == leaving {
+				node.cluster.Leave()
+			}
+		}
+
+		Eventually(started, 3*time.Second).Should(Receive(
+			WithTransform(func(start entityStart) bool {
+				return start.actorID == entity && start.address != leaving
+			}, BeTrue()),
+		))
+	})
+
+	It("Aggregates entity counts across regions", func() {
+		Eventually(func() map[string]bool {
+			return hostsOf(nodes[0], actorIDs)
+		}).Should(HaveLen(3))
+
+		stats := nodes[1].region.Ask(GetClusterShardingStats{}).(ClusterShardingStats)
+		Expect(stats.Failed).To(BeEmpty())
+		Expect(stats.Regions).To(HaveLen(3))
+		total := 0
+		for _, regionStats := range stats.Regions {
+			for _, count := range regionStats.EntityCounts {
+				total += count
+			}
+		}
+		Expect(total).To(Equal(len(actorIDs)))
+	})
+})
@@ -0,0 +1,181 @@
+package actors_test
+
+import (
+	"context"
+	"time"
+
+	. "github.com/kphelps/actors/actors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type clusterNode struct {
+	remote  *RemoteSystem
+	cluster *Cluster
+	events  chan interface{}
+}
+
+func startClusterNode(seeds ...string) *clusterNode {
+	remote, err := NewRemoteSystem(RemoteConfig{})
+	Expect(err).NotTo(HaveOccurred())
+	if len(seeds) == 0 {
+		seeds = []string{remote.Address()}
+	}
+	node := &clusterNode{
+		remote: remote,
+		cluster: NewCluster(ClusterConfig{
+			Remote:         remote,
+			SeedNodes:      seeds,
+			GossipInterval: 20 * time.Millisecond,
+			FailureDetector: PhiAccrualConfig{
+				MinStdDev: 10 * time.Millisecond,
+			},
+			AutoDownAfter: 100 * time.Millisecond,
+		}),
+		events: make(chan interface{}, 100),
+	}
+	node.cluster.Subscribe(SpawnActor(&ChannelActor{node.events}))
+	return node
+}
+
+func (cn *clusterNode) stop() {
+	cn.cluster.Close()
+	cn.remote.Close()
+}
+
+func (cn *clusterNode) members() []Member {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	members, err := cn.cluster.Members(ctx)
+	ExpectWithOffset(1, err).NotTo(HaveOccurred())
+	return members
+}
+
+func memberStatuses(node *clusterNode) func() map[string]MemberStatus {
+	return func() map[string]MemberStatus {
+		statuses := make(map[string]MemberStatus)
+		for _, member := range node.members() {
+			statuses[member.Address] = member.Status
+		}
+		return statuses
+	}
+}
+
+func allUp(nodes ...*clusterNode) map[string]MemberStatus {
+	statuses := make(map[string]MemberStatus)
+	for _, node := range nodes {
+		statuses[node.remote.Address()] = MemberStatusUp
+	}
+	return statuses
+}
+
+var _ = Describe("Cluster", func() {
+	var nodes []*clusterNode
+
+	BeforeEach(func() {
+		seed := startClusterNode()
+		nodes = []*clusterNode{
+			seed,
+			startClusterNode(seed.remote.Address()),
+			startClusterNode(seed.remote.Address()),
+		}
+		for _, node := range nodes {
+			Eventually(memberStatuses(node)).Should(Equal(allUp(nodes...)))
+		}
+	})
+
+	AfterEach(func() {
+		for _, node := range nodes {
+			node.stop()
+		}
+	})
+
+	It("Brings joining members up in order under the lowest address", func() {
+		members := nodes[2].members()
+		upNumbers := make(map[string]int)
+		for _, member := range members {
+			upNumbers[member.Address] = member.UpNumber
+		}
+		Expect(upNumbers[nodes[0].remote.Address()]).To(Equal(1))
+		Expect(upNumbers).To(HaveLen(3))
+		state, err := nodes[1].cluster.State(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(state.Leader).To(Equal(members[0].Address))
+	})
+
+	It("Tells subscribers about member events", func() {
+		Expect(nodes[0].events).To(Receive(BeAssignableToTypeOf(CurrentClusterState{})))
+		address := nodes[2].remote.Address()
+		Eventually(nodes[0].events).Should(Receive(Equal(MemberUp{
+			Member: Member{
+				Address:  address,
+				UID:      memberUID(nodes[0], address),
+				Status:   MemberStatusUp,
+				UpNumber: memberUpNumber(nodes[0], address),
+			},
+		})))
+	})
+
+	It("Removes a member that leaves", func() {
+		leaving := nodes[1]
+		address := leaving.remote.Address()
+		uid := memberUID(nodes[0], address)
+		upNumber := memberUpNumber(nodes[0], address)
+		leaving.cluster.Leave()
+		Eventually(memberStatuses(nodes[0])).Should(Equal(allUp(nodes[0], nodes[2])))
+		Eventually(memberStatuses(nodes[2])).Should(Equal(allUp(nodes[0], nodes[2])))
+		Eventually(leaving.events).Should(Receive(Equal(MemberRemoved{
+			Member: Member{
+				Address:  address,
+				UID:      uid,
+				Status:   MemberStatusRemoved,
+				UpNumber: upNumber,
+			},
+		})))
+	})
+
+	It("Downs and removes a member that crashes", func() {
+		crashed := nodes[2]
+		crashed.stop()
+		Eventually(nodes[0].events).Should(Receive(BeAssignableToTypeOf(UnreachableMember{})))
+		Eventually(memberStatuses(nodes[0]), 2*time.Second).Should(
+			Equal(allUp(nodes[0], nodes[1])),
+		)
+		Eventually(memberStatuses(nodes[1]), 2*time.Second).Should(
+			Equal(allUp(nodes[0], nodes[1])),
+		)
+		nodes = nodes[:2]
+	})
+
+	It("Fails to report its state once closed", func() {
+		nodes[2].cluster.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, err := nodes[2].cluster.State(ctx)
+		Expect(err).To(Equal(context.DeadlineExceeded))
+	})
+
+	It("Removes a member that is downed", func() {
+		nodes[0].cluster.Down(nodes[1].remote.Address())
+		Eventually(memberStatuses(nodes[2])).Should(Equal(allUp(nodes[0], nodes[2])))
+	})
+})
+
+func memberUID(node *clusterNode, address string) int64 {
+	for _, member := range node.members() {
+		if member.Address == address {
+			return member.UID
+		}
+	}
+	return 0
+}
+
+func memberUpNumber(node *clusterNode, address string) int {
+	for _, member := range node.members() {
+		if member.Address == address {
+			return member.UpNumber
+		}
+	}
+	return 0
+}
@@ -0,0 +1,123 @@
+package actors
+
+import (
+	"context"
+
+	"github.com/gocql/gocql"
+	"github.com/golang/protobuf/proto"
+)
+
+// ContextPersistenceProvider is a PersistenceProvider whose calls can be
+// cancelled and carry deadlines.
+type ContextPersistenceProvider interface {
+	PersistenceProvider
+	GetEventContext(ctx context.Context, actorID string, sequenceID uint64) (PersistentEvent, error)
+	GetEventsContext(ctx context.Context, actorID string, sequenceID uint64) ([]PersistentEvent, error)
+	PersistEventContext(ctx context.Context, actorID string, sequenceID uint64, event proto.Message) error
+	MaxSequenceIDContext(ctx context.Context, actorID string) (uint64, error)
+	DeleteEventsContext(ctx context.Context, actorID string, toSequenceID uint64, mode DeletionMode) error
+	EventsByTagContext(ctx context.Context, tag string, offset gocql.UUID, limit int) ([]PersistentEvent, error)
+	PersistenceIDsContext(ctx context.Context) ([]string, error)
+}
+
+// NewContextPersistenceProvider returns provider itself if it is already
+// context aware. Otherwise calls are wrapped so that a cancelled context
+// fails them before they reach the provider; a call already running is not
+// interrupted.
+func NewContextPersistenceProvider(
+	provider PersistenceProvider,
+) ContextPersistenceProvider {
+	contextProvider, ok := provider.(ContextPersistenceProvider)
+	if ok {
+		return contextProvider
+	}
+	return &contextCheckingProvider{provider}
+}
+
+type contextCheckingProvider struct {
+	PersistenceProvider
+}
+
+func (ccp *contextCheckingProvider) GetEventContext(
+	ctx context.Context,
+	actorID string,
+	sequenceID uint64,
+) (PersistentEvent, error) {
+	err := ctx.Err()
+	if err != nil {
+		return PersistentEvent{}, err
+	}
+	return ccp.GetEvent(actorID, sequenceID)
+}
+
+func (ccp *contextCheckingProvider) GetEventsContext(
+	ctx context.Context,
+	actorID string,
+	sequenceID uint64,
+) ([]PersistentEvent, error) {
+	err := ctx.Err()
+	if err != nil {
+		return nil, err
+	}
+	return ccp.GetEvents(actorID, sequenceID)
+}
+
+func (ccp *contextCheckingProvider) PersistEventContext(
+	ctx context.Context,
+	actorID string,
+	sequenceID uint64,
+	event proto.Message,
+) error {
+	err := ctx.Err()
+	if err != nil {
+		return err
+	}
+	return ccp.PersistEvent(actorID, sequenceID, event)
+}
+
+func (ccp *contextCheckingProvider) MaxSequenceIDContext(
+	ctx context.Context,
+	actorID string,
+) (uint64, error) {
+	err := ctx.Err()
+	if err != nil {
+		return 0, err
+	}
+	return ccp.MaxSequenceID(actorID)
+}
+
+func (ccp *contextCheckingProvider) DeleteEventsContext(
+	ctx context.Context,
+	actorID string,
+	toSequenceID uint64,
+	mode DeletionMode,
+) error {
+	err := ctx.Err()
+	if err != nil {
+		return err
+	}
+	return ccp.DeleteEvents(actorID, toSequenceID, mode)
+}
+
+func (ccp *contextCheckingProvider) EventsByTagContext(
+	ctx context.Context,
+	tag string,
+	offset gocql.UUID,
+	limit int,
+) ([]PersistentEvent, error) {
+	err := ctx.Err()
+	if err != nil {
+		return nil, err
+	}
+	return ccp.EventsByTag(tag, offset, limit)
+}
+
+func (ccp *contextCheckingProvider) PersistenceIDsContext(
+	ctx context.Context,
+) ([]string, error) {
+	err := ctx.Err()
+	if err != nil {
+		return nil, err
+	}
+	return ccp.PersistenceIDs()
+}
@@ -0,0 +1,40 @@
+package actors
+
+import (
+	"errors"
+	"sync"
+)
+
+var ErrActorStopped = errors.New("actor stopped")
+
+var deadLetters = struct {
+	sync.RWMutex
+	ref ActorRef
+}{}
+
+// SetDeadLetters sets the actor that receives a DeadLetter for every message
+// sent to a stopped local actor. Those messages are dropped while it is nil.
+func SetDeadLetters(ref ActorRef) {
+	deadLetters.Lock()
+	defer deadLetters.Unlock()
+	deadLetters.ref = ref
+}
+
+func localDeadLetter(message interface{}, sender ActorRef) {
+	// Dead letters of dead letters are dropped rather than sent around
+	// again, in case the dead letter actor itself has stopped.
+	if _, ok := message.(DeadLetter); ok {
+		return
+	}
+	deadLetters.RLock()
+	ref := deadLetters.ref
+	deadLetters.RUnlock()
+	if ref == nil {
+		return
+	}
+	ref.Send(DeadLetter{
+		Sender:  sender,
+		Message: message,
+		Err:     ErrActorStopped,
+	})
+}
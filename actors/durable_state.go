@@ -0,0 +1,327 @@
+package actors
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/gocql/gocql"
+	"github.com/golang/protobuf/proto"
+	"github.com/scylladb/gocqlx"
+	"github.com/scylladb/gocqlx/qb"
+)
+
+var ErrRevisionConflict = errors.New("revision conflict")
+
+type DurableState struct {
+	Revision uint64
+	State    proto.Message
+}
+
+// DurableStateStore keeps only the latest state of each persistence ID.
+// Every write names the revision it creates, which has to follow the stored
+// revision, so concurrent writers find out about each other instead of
+// overwriting each other's state.
+type DurableStateStore interface {
+	Get(persistenceID string) (DurableState, error)
+	// Upsert stores state as revision, failing with ErrRevisionConflict
+	// unless the stored revision is revision - 1. Revision 1 creates the
+	// state.
+	Upsert(persistenceID string, revision uint64, state proto.Message) error
+	// Delete fails with ErrRevisionConflict unless revision is the stored
+	// revision. The next Upsert starts again at revision 1.
+	Delete(persistenceID string, revision uint64) error
+}
+
+type InMemoryDurableStateStore struct {
+	sync.Mutex
+	states map[string]DurableState
+}
+
+func NewInMemoryDurableStateStore() *InMemoryDurableStateStore {
+	return &InMemoryDurableStateStore{
+		states: make(map[string]DurableState),
+	}
+}
+
+func (s *InMemoryDurableStateStore) Get(
+	persistenceID string,
+) (DurableState, error) {
+	s.Lock()
+	defer s.Unlock()
+	stored, found := s.states[persistenceID]
+	if !found {
+		return DurableState{}, errors.New("not found")
+	}
+	return DurableState{
+		Revision: stored.Revision,
+		State:    proto.Clone(stored.State),
+	}, nil
+}
+
+func (s *InMemoryDurableStateStore) Upsert(
+	persistenceID string,
+	revision uint64,
+	state proto.Message,
+) error {
+	s.Lock()
+	defer s.Unlock()
+	stored := s.states[persistenceID]
+	if revision != stored.Revision+1 {
+		return ErrRevisionConflict
+	}
+	s.states[persistenceID] = DurableState{
+		Revision: revision,
+		State:    proto.Clone(state),
+	}
+	return nil
+}
+
+func (s *InMemoryDurableStateStore) Delete(
+	persistenceID string,
+	revision uint64,
+) error {
+	s.Lock()
+	defer s.Unlock()
+	stored, found := s.states[persistenceID]
+	if !found || stored.Revision != revision {
+		return ErrRevisionConflict
+	}
+	delete(s.states, persistenceID)
+	return nil
+}
+
+// CassandraDurableStateStore uses the durable_state table created by the
+// CassandraPersistenceProvider. Revisions are checked with lightweight
+// transactions.
+type CassandraDurableStateStore struct {
+	cassandra *gocql.Session
+}
+
+func NewCassandraDurableStateStore(
+	cassandra *gocql.Session,
+) *CassandraDurableStateStore {
+	return &CassandraDurableStateStore{
+		cassandra: cassandra,
+	}
+}
+
+func (s *CassandraDurableStateStore) Get(
+	persistenceID string,
+) (DurableState, error) {
+	stmt, names := qb.Select("durable_state").
+		Columns("revision", "state", "state_type").
+		Where(qb.Eq("persistence_id")).
+		ToCql()
+	q := gocqlx.Query(s.cassandra.Query(stmt), names).BindMap(qb.M{
+		"persistence_id": persistenceID,
+	})
+	defer q.Release()
+
+	var revision uint64
+	var data []byte
+	var stateType string
+	err := q.Query.Scan(&revision, &data, &stateType)
+	if err == gocql.ErrNotFound {
+		return DurableState{}, errors.New("not found")
+	} else if err != nil {
+		return DurableState{}, err
+	}
+	state, err := deserializeEvent(stateType, data)
+	if err != nil {
+		return DurableState{}, err
+	}
+	return DurableState{
+		Revision: revision,
+		State:    state,
+	}, nil
+}
+
+func (s *CassandraDurableStateStore) Upsert(
+	persistenceID string,
+	revision uint64,
+	state proto.Message,
+) error {
+	data, err := serializeEvent(state)
+	if err != nil {
+		return err
+	}
+
+	var q *gocql.Query
+	if revision == 1 {
+		stmt, names := qb.Insert("durable_state").
+			Columns("persistence_id", "revision", "state", "state_type").
+			Unique().
+			ToCql()
+		q = gocqlx.Query(s.cassandra.Query(stmt), names).BindMap(qb.M{
+			"persistence_id": persistenceID,
+			"revision":       revision,
+			"state":          data,
+			"state_type":     proto.MessageName(state),
+		}).Query
+	} else {
+		q = s.cassandra.Query(
+			`UPDATE durable_state
+			SET revision = ?, state = ?, state_type = ?
+			WHERE persistence_id = ?
+			IF revision = ?`,
+			revision,
+			data,
+			proto.MessageName(state),
+			persistenceID,
+			revision-1,
+		)
+	}
+	defer q.Release()
+	return casResult(q)
+}
+
+func (s *CassandraDurableStateStore) Delete(
+	persistenceID string,
+	revision uint64,
+) error {
+	q := s.cassandra.Query(
+		`DELETE FROM durable_state WHERE persistence_id = ? IF revision = ?`,
+		persistenceID,
+		revision,
+	)
+	defer q.Release()
+	return casResult(q)
+}
+
+func casResult(q *gocql.Query) error {
+	applied, err := q.MapScanCAS(make(map[string]interface{}))
+	if err != nil {
+		return err
+	}
+	if !applied {
+		return ErrRevisionConflict
+	}
+	return nil
+}
+
+// DurableStateActor is the counterpart of PersistentActor for actors that
+// only need their latest state: instead of events, the whole state is
+// persisted on every change.
+type DurableStateActor interface {
+	PersistenceID() string
+	Receive(context DurableStateContext)
+	// HandleState is given the stored state when the actor starts, and
+	// every state as soon as it is written. It is given nil when there is
+	// no stored state.
+	HandleState(state proto.Message)
+}
+
+type DurableStateContext interface {
+	ActorContext
+	// Persist writes state as the next revision. On ErrRevisionConflict the
+	// state somebody else wrote is loaded and handed to HandleState, so the
+	// command can be retried against it. If that state cannot be loaded,
+	// the load error is returned instead.
+	Persist(state proto.Message) error
+	// Delete reloads the state on ErrRevisionConflict, as Persist does.
+	Delete() error
+	Revision() uint64
+}
+
+type durableStateContextImpl struct {
+	ActorContext
+	id       string
+	revision uint64
+	store    DurableStateStore
+	actor    DurableStateActor
+}
+
+func (dsc *durableStateContextImpl) Persist(state proto.Message) error {
+	err := dsc.store.Upsert(dsc.id, dsc.revision+1, state)
+	if err == ErrRevisionConflict {
+		loadErr := dsc.load()
+		if loadErr != nil {
+			return loadErr
+		}
+	}
+	if err != nil {
+		return err
+	}
+	dsc.revision++
+	dsc.actor.HandleState(state)
+	return nil
+}
+
+func (dsc *durableStateContextImpl) Delete() error {
+	err := dsc.store.Delete(dsc.id, dsc.revision)
+	if err == ErrRevisionConflict {
+		loadErr := dsc.load()
+		if loadErr != nil {
+			return loadErr
+		}
+	}
+	if err != nil {
+		return err
+	}
+	dsc.revision = 0
+	dsc.actor.HandleState(nil)
+	return nil
+}
+
+func (dsc *durableStateContextImpl) Revision() uint64 {
+	return dsc.revision
+}
+
+func (dsc *durableStateContextImpl) load() error {
+	stored, err := dsc.store.Get(dsc.id)
+	if err != nil && err.Error() != "not found" {
+		return err
+	}
+	dsc.revision = stored.Revision
+	dsc.actor.HandleState(stored.State)
+	return nil
+}
+
+type durableStateActorCell struct {
+	context durableStateContextImpl
+	failed  bool
+}
+
+func NewDurableStateActor(
+	actor DurableStateActor,
+	store DurableStateStore,
+) Actor {
+	return &durableStateActorCell{
+		context: durableStateContextImpl{
+			store: store,
+			actor: actor,
+		},
+	}
+}
+
+func SpawnDurableStateActor(
+	actor DurableStateActor,
+	store DurableStateStore,
+) ActorRef {
+	return SpawnActor(NewDurableStateActor(actor, store))
+}
+
+func (dsac *durableStateActorCell) OnStart(context ActorContext) {
+	dsac.context.ActorContext = context
+	dsac.context.id = dsac.context.actor.PersistenceID()
+	err := dsac.context.load()
+	if err != nil {
+		failedHandler, ok := dsac.context.actor.(RecoveryFailedHandler)
+		if !ok {
+			panic(err)
+		}
+		failedHandler.RecoveryFailed(err)
+		dsac.failed = true
+		context.Self().Stop()
+	}
+}
+
+func (dsac *durableStateActorCell) Receive(context ActorContext) {
+	if dsac.failed {
+		return
+	}
+	dsac.context.actor.Receive(&dsac.context)
+}
+
+func (dsac *durableStateActorCell) OnStop(context ActorContext) {
+}
@@ -0,0 +1,218 @@
+package actors_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	. "github.com/kphelps/actors/actors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func describeDurableStateStore(newStore func() DurableStateStore) {
+	var store DurableStateStore
+	var id string
+
+	BeforeEach(func() {
+		store = newStore()
+		id = fmt.Sprintf("durable-state-%d", time.Now().UnixNano())
+	})
+
+	It("Doesn't find missing state", func() {
+		_, err := store.Get(id)
+		Expect(err).To(MatchError("not found"))
+	})
+
+	It("Stores the latest revision", func() {
+		Expect(store.Upsert(id, 1, &wrappers.StringValue{Value: "a"})).To(Succeed())
+		Expect(store.Upsert(id, 2, &wrappers.StringValue{Value: "b"})).To(Succeed())
+		stored, err := store.Get(id)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stored.Revision).To(Equal(uint64(2)))
+		Expect(proto.Equal(stored.State, &wrappers.StringValue{Value: "b"})).To(BeTrue())
+	})
+
+	It("Rejects revisions that don't follow the stored one", func() {
+		Expect(store.Upsert(id, 2, &wrappers.StringValue{Value: "a"})).To(
+			Equal(ErrRevisionConflict),
+		)
+		Expect(store.Upsert(id, 1, &wrappers.StringValue{Value: "a"})).To(Succeed())
+		Expect(store.Upsert(id, 1, &wrappers.StringValue{Value: "b"})).To(
+			Equal(ErrRevisionConflict),
+		)
+		Expect(store.Upsert(id, 3, &wrappers.StringValue{Value: "b"})).To(
+			Equal(ErrRevisionConflict),
+		)
+	})
+
+	It("Deletes the stored revision", func() {
+		Expect(store.Upsert(id, 1, &wrappers.StringValue{Value: "a"})).To(Succeed())
+		Expect(store.Delete(id, 2)).To(Equal(ErrRevisionConflict))
+		Expect(store.Delete(id, 1)).To(Succeed())
+		_, err := store.Get(id)
+		Expect(err).To(MatchError("not found"))
+		Expect(store.Upsert(id, 1, &wrappers.StringValue{Value: "b"})).To(Succeed())
+	})
+}
+
+var _ = Describe("InMemoryDurableStateStore", func() {
+	describeDurableStateStore(func() DurableStateStore {
+		return NewInMemoryDurableStateStore()
+	})
+})
+
+var _ = Describe("CassandraDurableStateStore", func() {
+	describeDurableStateStore(func() DurableStateStore {
+		return NewCassandraDurableStateStore(cassandraSession)
+	})
+})
+
+type durableIncrement struct{}
+
+type durableGet struct{}
+
+type durableDelete struct{}
+
+type durableCounter struct {
+	id    string
+	count int64
+}
+
+func (dc *durableCounter) PersistenceID() string {
+	return dc.id
+}
+
+func (dc *durableCounter) Receive(context DurableStateContext) {
+	switch context.Message().(type) {
+	case durableIncrement:
+		err := ErrRevisionConflict
+		for err == ErrRevisionConflict {
+			err = context.Persist(&wrappers.Int64Value{Value: dc.count + 1})
+		}
+		Expect(err).NotTo(HaveOccurred())
+		context.Reply(dc.count)
+	case durableGet:
+		context.Reply([]interface{}{dc.count, context.Revision()})
+	case durableDelete:
+		context.Reply(context.Delete())
+	}
+}
+
+func (dc *durableCounter) HandleState(state proto.Message) {
+	if state == nil {
+		dc.count = 0
+		return
+	}
+	dc.count = state.(*wrappers.Int64Value).Value
+}
+
+type failingDurableCounter struct {
+	*durableCounter
+	failures chan error
+}
+
+func (fdc failingDurableCounter) RecoveryFailed(err error) {
+	fdc.failures <- err
+}
+
+// unreadableDurableStateStore fails every Get once unreadable is set.
+type unreadableDurableStateStore struct {
+	DurableStateStore
+	unreadable int32
+}
+
+func (s *unreadableDurableStateStore) Get(
+	persistenceID string,
+) (DurableState, error) {
+	if atomic.LoadInt32(&s.unreadable) == 1 {
+		return DurableState{}, errors.New("unreadable")
+	}
+	return s.DurableStateStore.Get(persistenceID)
+}
+
+var _ = Describe("DurableStateActor", func() {
+	var id string
+	var store DurableStateStore
+	var ref ActorRef
+
+	spawn := func() ActorRef {
+		return SpawnDurableStateActor(&durableCounter{id: id}, store)
+	}
+
+	BeforeEach(func() {
+		id = fmt.Sprintf("durable-counter-%d", time.Now().UnixNano())
+		store = NewInMemoryDurableStateStore()
+		ref = spawn()
+	})
+
+	AfterEach(func() {
+		ref.Stop()
+	})
+
+	It("Loads its state on start", func() {
+		for i := 0; i < 3; i++ {
+			ref.Ask(durableIncrement{})
+		}
+		ref.Stop()
+
+		ref = spawn()
+		Expect(ref.Ask(durableGet{})).To(Equal([]interface{}{int64(3), uint64(3)}))
+	})
+
+	It("Reloads the state on a revision conflict", func() {
+		other := spawn()
+		defer other.Stop()
+		Expect(ref.Ask(durableGet{})).To(Equal([]interface{}{int64(0), uint64(0)}))
+		Expect(other.Ask(durableGet{})).To(Equal([]interface{}{int64(0), uint64(0)}))
+
+		Expect(ref.Ask(durableIncrement{})).To(Equal(int64(1)))
+		Expect(other.Ask(durableIncrement{})).To(Equal(int64(2)))
+		stored, err := store.Get(id)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stored.Revision).To(Equal(uint64(2)))
+	})
+
+	It("Deletes its state", func() {
+		ref.Ask(durableIncrement{})
+		Expect(ref.Ask(durableDelete{})).To(BeNil())
+		Expect(ref.Ask(durableGet{})).To(Equal([]interface{}{int64(0), uint64(0)}))
+		ref.Stop()
+
+		ref = spawn()
+		Expect(ref.Ask(durableGet{})).To(Equal([]interface{}{int64(0), uint64(0)}))
+		Expect(ref.Ask(durableIncrement{})).To(Equal(int64(1)))
+	})
+
+	It("Fails start when its state can't be loaded", func() {
+		unreadable := &unreadableDurableStateStore{DurableStateStore: store, unreadable: 1}
+		failures := make(chan error, 1)
+		failing := SpawnDurableStateActor(
+			failingDurableCounter{&durableCounter{id: id}, failures},
+			unreadable,
+		)
+		Eventually(failures).Should(Receive(MatchError("unreadable")))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, err := failing.AskContext(ctx, durableGet{})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Returns the error when a conflicting state can't be loaded", func() {
+		unreadable := &unreadableDurableStateStore{DurableStateStore: store}
+		ref.Stop()
+		ref = SpawnDurableStateActor(&durableCounter{id: id}, unreadable)
+		Expect(ref.Ask(durableGet{})).To(Equal([]interface{}{int64(0), uint64(0)}))
+
+		err := store.Upsert(id, 1, &wrappers.Int64Value{Value: 5})
+		Expect(err).NotTo(HaveOccurred())
+		atomic.StoreInt32(&unreadable.unreadable, 1)
+		Expect(ref.Ask(durableDelete{})).To(MatchError("unreadable"))
+	})
+})
@@ -0,0 +1,132 @@
+package actors
+
+import (
+	"sync"
+	"time"
+)
+
+// A PersistenceProvider implementing EventNotifier lets readers wake as soon
+// as an event is written instead of waiting for their next poll. The
+// returned channels are closed by the next matching write. Notifications
+// only cover writes made through this process; readers still poll for the
+// rest.
+type EventNotifier interface {
+	EventsWritten() <-chan struct{}
+	ActorEventsWritten(actorID string) <-chan struct{}
+}
+
+// writeNotifier is embedded by providers to implement EventNotifier.
+type writeNotifier struct {
+	lock   sync.Mutex
+	all    chan struct{}
+	actors map[string]chan struct{}
+}
+
+func (wn *writeNotifier) EventsWritten() <-chan struct{} {
+	wn.lock.Lock()
+	defer wn.lock.Unlock()
+	if wn.all == nil {
+		wn.all = make(chan struct{})
+	}
+	return wn.all
+}
+
+func (wn *writeNotifier) ActorEventsWritten(actorID string) <-chan struct{} {
+	wn.lock.Lock()
+	defer wn.lock.Unlock()
+	if wn.actors == nil {
+		wn.actors = make(map[string]chan struct{})
+	}
+	written, found := wn.actors[actorID]
+	if !found {
+		written = make(chan struct{})
+		wn.actors[actorID] = written
+	}
+	return written
+}
+
+func (wn *writeNotifier) notifyWritten(actorID string) {
+	wn.lock.Lock()
+	defer wn.lock.Unlock()
+	if wn.all != nil {
+		close(wn.all)
+		wn.all = nil
+	}
+	written, found := wn.actors[actorID]
+	if found {
+		close(written)
+		delete(wn.actors, actorID)
+	}
+}
+
+// PollingBackoff controls how event sources poll when they are caught up.
+// The wait starts at MinInterval and grows by Multiplier after each empty
+// poll up to MaxInterval.
+type PollingBackoff struct {
+	MinInterval time.Duration
+	MaxInterval time.Duration
+	Multiplier  float64
+}
+
+var pollingBackoff = struct {
+	sync.RWMutex
+	backoff PollingBackoff
+}{
+	backoff: PollingBackoff{
+		MinInterval: 50 * time.Millisecond,
+		MaxInterval: 5 * time.Second,
+		Multiplier:  2,
+	},
+}
+
+// ConfigurePollingBackoff applies to event sources created afterwards.
+func ConfigurePollingBackoff(backoff PollingBackoff) {
+	if backoff.MinInterval <= 0 {
+		backoff.MinInterval = time.Millisecond
+	}
+	if backoff.MaxInterval < backoff.MinInterval {
+		backoff.MaxInterval = backoff.MinInterval
+	}
+	if backoff.Multiplier < 1 {
+		backoff.Multiplier = 1
+	}
+	pollingBackoff.Lock()
+	defer pollingBackoff.Unlock()
+	pollingBackoff.backoff = backoff
+}
+
+type pollWaiter struct {
+	backoff  PollingBackoff
+	interval time.Duration
+}
+
+func newPollWaiter() *pollWaiter {
+	pollingBackoff.RLock()
+	backoff := pollingBackoff.backoff
+	pollingBackoff.RUnlock()
+	return &pollWaiter{
+		backoff:  backoff,
+		interval: backoff.MinInterval,
+	}
+}
+
+// wait blocks until wake is closed or the current interval passes. A nil
+// wake channel only waits on the interval.
+func (pw *pollWaiter) wait(wake <-chan struct{}) {
+	timer := time.NewTimer(pw.interval)
+	defer timer.Stop()
+	select {
+	case <-wake:
+		pw.reset()
+		return
+	case <-timer.C:
+	}
+	pw.interval = time.Duration(float64(pw.interval) * pw.backoff.Multiplier)
+	if pw.interval > pw.backoff.MaxInterval {
+		pw.interval = pw.backoff.MaxInterval
+	}
+}
+
+func (pw *pollWaiter) reset() {
+	pw.interval = pw.backoff.MinInterval
+}
@@ -0,0 +1,137 @@
+package actors
+
+import (
+	"errors"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// EventSourcedBehavior is a persistent actor written as two functions: the
+// command handler decides what to do with a command given the current
+// state, and the event handler applies an event to the state, both live and
+// on recovery.
+type EventSourcedBehavior[S any] struct {
+	PersistenceID  string
+	EmptyState     S
+	CommandHandler func(state S, command interface{}) Effect[S]
+	EventHandler   func(state S, event proto.Message) S
+	// Commands the command handler returns Unhandled for are sent here as
+	// DeadLetters. They are dropped when it is nil.
+	DeadLetters ActorRef
+}
+
+// Effect is what the command handler wants done with a command. Side
+// effects chained onto it run in order once its events are persisted and
+// applied, and see the resulting state.
+type Effect[S any] struct {
+	events      []proto.Message
+	unhandled   bool
+	stop        bool
+	sideEffects []func(context ActorContext, state S)
+}
+
+// Persist persists events and applies them to the state.
+func Persist[S any](events ...proto.Message) Effect[S] {
+	return Effect[S]{events: events}
+}
+
+// None persists nothing, which still lets side effects run.
+func None[S any]() Effect[S] {
+	return Effect[S]{}
+}
+
+func Unhandled[S any]() Effect[S] {
+	return Effect[S]{unhandled: true}
+}
+
+// Stop stops the actor. Commands that arrive before it has stopped are
+// dropped.
+func Stop[S any]() Effect[S] {
+	return Effect[S]{stop: true}
+}
+
+// ThenReply replies to the command's sender with the result of reply.
+func (e Effect[S]) ThenReply(reply func(state S) interface{}) Effect[S] {
+	return e.addSideEffect(func(context ActorContext, state S) {
+		context.Reply(reply(state))
+	})
+}
+
+// ThenRun runs callback only after every event has been written.
+func (e Effect[S]) ThenRun(callback func(state S)) Effect[S] {
+	return e.addSideEffect(func(context ActorContext, state S) {
+		callback(state)
+	})
+}
+
+func (e Effect[S]) ThenStop() Effect[S] {
+	e.stop = true
+	return e
+}
+
+func (e Effect[S]) addSideEffect(
+	sideEffect func(context ActorContext, state S),
+) Effect[S] {
+	sideEffects := make([]func(ActorContext, S), len(e.sideEffects), len(e.sideEffects)+1)
+	copy(sideEffects, e.sideEffects)
+	e.sideEffects = append(sideEffects, sideEffect)
+	return e
+}
+
+func SpawnEventSourcedBehavior[S any](behavior EventSourcedBehavior[S]) ActorRef {
+	return SpawnPersistentActor(&eventSourcedActor[S]{
+		behavior: behavior,
+		state:    behavior.EmptyState,
+	})
+}
+
+type eventSourcedActor[S any] struct {
+	behavior EventSourcedBehavior[S]
+	state    S
+	stopping bool
+}
+
+func (esa *eventSourcedActor[S]) PersistenceID() string {
+	return esa.behavior.PersistenceID
+}
+
+// Persist panics when a write fails, so events are applied as soon as they
+// are written and the side effects only run once all of them are.
+func (esa *eventSourcedActor[S]) Receive(context PersistentContext) {
+	if esa.stopping {
+		return
+	}
+	command := context.Message()
+	effect := esa.behavior.CommandHandler(esa.state, command)
+	if effect.unhandled {
+		if esa.behavior.DeadLetters != nil {
+			esa.behavior.DeadLetters.Send(DeadLetter{
+				Recipient: esa.behavior.PersistenceID,
+				Sender:    context.Sender(),
+				Message:   command,
+				Err:       errors.New("unhandled"),
+			})
+		}
+		return
+	}
+	for _, event := range effect.events {
+		context.Persist(event)
+		esa.state = esa.behavior.EventHandler(esa.state, event)
+	}
+	for _, sideEffect := range effect.sideEffects {
+		sideEffect(context, esa.state)
+	}
+	if effect.stop {
+		esa.stopping = true
+		// The actor can't wait on its own stop from inside Receive.
+		self := context.Self()
+		go self.Stop()
+	}
+}
+
+// Live events are applied in Receive.
+func (esa *eventSourcedActor[S]) HandleEvent(event proto.Message) {}
+
+func (esa *eventSourcedActor[S]) HandleRecover(event proto.Message) {
+	esa.state = esa.behavior.EventHandler(esa.state, event)
+}
@@ -0,0 +1,130 @@
+package actors_test
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	. "github.com/kphelps/actors/actors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type accountDeposit struct {
+	amount int64
+}
+
+type accountWithdraw struct {
+	amount int64
+}
+
+type accountBalance struct{}
+
+type accountClose struct{}
+
+var _ = Describe("EventSourcedBehavior", func() {
+	var id string
+	var ran chan interface{}
+	var deadLetters chan interface{}
+	var ref ActorRef
+	var stopped bool
+
+	balance := func(state int64) interface{} {
+		return state
+	}
+
+	spawn := func() ActorRef {
+		return SpawnEventSourcedBehavior(EventSourcedBehavior[int64]{
+			PersistenceID: id,
+			CommandHandler: func(state int64, command interface{}) Effect[int64] {
+				switch command := command.(type) {
+				case accountDeposit:
+					return Persist[int64](&wrappers.Int64Value{Value: command.amount}).
+						ThenRun(func(state int64) {
+							written, _ := GetPersistenceProvider().MaxSequenceID(id)
+							ran <- written
+						}).
+						ThenReply(balance)
+				case accountWithdraw:
+					if command.amount > state {
+						return None[int64]().ThenReply(balance)
+					}
+					return Persist[int64](&wrappers.Int64Value{Value: -command.amount}).
+						ThenReply(balance)
+				case accountBalance:
+					return None[int64]().ThenReply(balance)
+				case accountClose:
+					return Persist[int64](&wrappers.Int64Value{Value: -state}).ThenStop()
+				}
+				return Unhandled[int64]()
+			},
+			EventHandler: func(state int64, event proto.Message) int64 {
+				return state + event.(*wrappers.Int64Value).Value
+			},
+			DeadLetters: SpawnActor(&ChannelActor{deadLetters}),
+		})
+	}
+
+	BeforeEach(func() {
+		id = fmt.Sprintf("event-sourced-%d", time.Now().UnixNano())
+		ran = make(chan interface{}, 10)
+		deadLetters = make(chan interface{}, 10)
+		ref = spawn()
+		stopped = false
+	})
+
+	AfterEach(func() {
+		if !stopped {
+			ref.Stop()
+		}
+	})
+
+	It("Replies with the state after persisting", func() {
+		Expect(ref.Ask(accountDeposit{10})).To(Equal(int64(10)))
+		Expect(ref.Ask(accountWithdraw{4})).To(Equal(int64(6)))
+		Expect(ref.Ask(accountWithdraw{100})).To(Equal(int64(6)))
+	})
+
+	It("Recovers the state from events", func() {
+		ref.Ask(accountDeposit{10})
+		ref.Ask(accountWithdraw{3})
+		ref.Stop()
+
+		ref = spawn()
+		Expect(ref.Ask(accountBalance{})).To(Equal(int64(7)))
+	})
+
+	It("Runs callbacks once events are written", func() {
+		ref.Ask(accountDeposit{10})
+		Expect(ran).To(Receive(Equal(uint64(0))))
+		ref.Ask(accountDeposit{10})
+		Expect(ran).To(Receive(Equal(uint64(1))))
+	})
+
+	It("Sends unhandled commands to dead letters", func() {
+		ref.Send("unknown")
+		Eventually(deadLetters).Should(Receive(WithTransform(
+			func(deadLetter DeadLetter) interface{} {
+				return deadLetter.Message
+			},
+			Equal("unknown"),
+		)))
+	})
+
+	It("Stops after persisting", func() {
+		ref.Ask(accountDeposit{10})
+		ref.Send(accountClose{})
+		stopped = true
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		_, err := ref.AskContext(ctx, accountBalance{})
+		Expect(err).To(HaveOccurred())
+
+		ref = spawn()
+		stopped = false
+		Expect(ref.Ask(accountBalance{})).To(Equal(int64(0)))
+	})
+})
@@ -1,30 +1,40 @@
 package actors
 
-import (
-	"time"
-
-	"github.com/kphelps/streams/streams"
-)
+import "github.com/kphelps/streams/streams"
 
 type EventStream struct {
 	Output chan PersistentEvent
 	stream streams.RunnableStream
 }
 
+// NewActorEventSource reads with GetEvents rather than one sequence ID at a
+// time, so that it moves past deleted events instead of waiting on them.
 func NewActorEventSource(
 	actorID string,
 	sequenceID uint64,
 ) streams.Source {
 	pp := GetPersistenceProvider()
+	notifier, _ := pp.(EventNotifier)
+	waiter := newPollWaiter()
+	pending := make([]PersistentEvent, 0)
 	return streams.NewSource(func() PersistentEvent {
-		for {
-			event, err := pp.GetEvent(actorID, sequenceID)
-			if err == nil {
-				sequenceID++
-				return event
+		for len(pending) == 0 {
+			var written <-chan struct{}
+			if notifier != nil {
+				written = notifier.ActorEventsWritten(actorID)
+			}
+			events, err := pp.GetEvents(actorID, sequenceID)
+			if err == nil && len(events) > 0 {
+				pending = events
+				waiter.reset()
+				break
 			}
-			time.Sleep(time.Second)
+			waiter.wait(written)
 		}
+		event := pending[0]
+		pending = pending[1:]
+		sequenceID = event.SequenceID + 1
+		return event
 	})
 }
 
@@ -0,0 +1,89 @@
+package actors_test
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/wrappers"
+	. "github.com/kphelps/actors/actors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ActorEventStream", func() {
+	var actorID string
+	var stream *EventStream
+
+	persist := func(sequenceID uint64) {
+		event := &wrappers.StringValue{Value: "event"}
+		err := GetPersistenceProvider().PersistEvent(actorID, sequenceID, event)
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	BeforeEach(func() {
+		actorID = fmt.Sprintf("stream-%d", time.Now().UnixNano())
+	})
+
+	AfterEach(func() {
+		stream.Close()
+		ConfigurePollingBackoff(PollingBackoff{
+			MinInterval: 50 * time.Millisecond,
+			MaxInterval: 5 * time.Second,
+			Multiplier:  2,
+		})
+	})
+
+	It("Wakes as soon as an event is written", func() {
+		ConfigurePollingBackoff(PollingBackoff{MinInterval: time.Hour})
+		stream = NewActorEventStream(actorID, 0)
+		stream.Open()
+		Consistently(stream.Output).ShouldNot(Receive())
+
+		persist(0)
+		var event PersistentEvent
+		Eventually(stream.Output).Should(Receive(&event))
+		Expect(event.SequenceID).To(BeZero())
+	})
+
+	It("Polls for writes it was not notified of", func() {
+		ConfigurePollingBackoff(PollingBackoff{
+			MinInterval: time.Millisecond,
+			MaxInterval: 10 * time.Millisecond,
+			Multiplier:  2,
+		})
+		persist(0)
+		persist(1)
+		stream = NewActorEventStream(actorID, 1)
+		stream.Open()
+		var event PersistentEvent
+		Eventually(stream.Output).Should(Receive(&event))
+		Expect(event.SequenceID).To(Equal(uint64(1)))
+	})
+
+	It("Can be reconfigured while streams are opening", func() {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			ConfigurePollingBackoff(PollingBackoff{MinInterval: time.Millisecond})
+		}()
+		persist(0)
+		stream = NewActorEventStream(actorID, 0)
+		stream.Open()
+		Eventually(stream.Output).Should(Receive())
+		Eventually(done).Should(BeClosed())
+	})
+
+	It("Skips events deleted before it reached them", func() {
+		persist(0)
+		persist(1)
+		persist(2)
+		err := GetPersistenceProvider().DeleteEvents(actorID, 1, LogicalDeletion)
+		Expect(err).NotTo(HaveOccurred())
+		stream = NewActorEventStream(actorID, 0)
+		stream.Open()
+		var event PersistentEvent
+		Eventually(stream.Output).Should(Receive(&event))
+		Expect(event.SequenceID).To(Equal(uint64(2)))
+	})
+})
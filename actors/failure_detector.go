@@ -0,0 +1,112 @@
+package actors
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+type PhiAccrualConfig struct {
+	// Phi above which a member is considered unavailable.
+	Threshold     float64
+	MaxSampleSize int
+	MinStdDev     time.Duration
+	// Added to the mean interval so that GC pauses and the like don't
+	// trigger the detector.
+	AcceptableHeartbeatPause time.Duration
+	// Seeds the history before any real intervals have been seen.
+	FirstHeartbeatEstimate time.Duration
+}
+
+// PhiAccrualFailureDetector reports suspicion as a continuous value, phi,
+// derived from how late the next heartbeat is given the distribution of
+// previous heartbeat intervals.
+type PhiAccrualFailureDetector struct {
+	sync.Mutex
+	config    PhiAccrualConfig
+	intervals []float64
+	sum       float64
+	squares   float64
+	last      time.Time
+}
+
+func NewPhiAccrualFailureDetector(
+	config PhiAccrualConfig,
+) *PhiAccrualFailureDetector {
+	if config.Threshold <= 0 {
+		config.Threshold = 8
+	}
+	if config.MaxSampleSize <= 0 {
+		config.MaxSampleSize = 200
+	}
+	if config.MinStdDev <= 0 {
+		config.MinStdDev = 100 * time.Millisecond
+	}
+	if config.FirstHeartbeatEstimate <= 0 {
+		config.FirstHeartbeatEstimate = time.Second
+	}
+	return &PhiAccrualFailureDetector{
+		config: config,
+	}
+}
+
+func (d *PhiAccrualFailureDetector) Heartbeat(now time.Time) {
+	d.Lock()
+	defer d.Unlock()
+	if d.last.IsZero() {
+		estimate := durationMillis(d.config.FirstHeartbeatEstimate)
+		deviation := estimate / 4
+		d.record(estimate - deviation)
+		d.record(estimate + deviation)
+	} else {
+		d.record(durationMillis(now.Sub(d.last)))
+	}
+	d.last = now
+}
+
+func (d *PhiAccrualFailureDetector) Phi(now time.Time) float64 {
+	d.Lock()
+	defer d.Unlock()
+	if d.last.IsZero() {
+		return 0
+	}
+	count := float64(len(d.intervals))
+	mean := d.sum / count
+	variance := d.squares/count - mean*mean
+	stdDev := math.Max(
+		math.Sqrt(math.Max(variance, 0)),
+		durationMillis(d.config.MinStdDev),
+	)
+	mean += durationMillis(d.config.AcceptableHeartbeatPause)
+	return phi(durationMillis(now.Sub(d.last)), mean, stdDev)
+}
+
+func (d *PhiAccrualFailureDetector) IsAvailable(now time.Time) bool {
+	return d.Phi(now) < d.config.Threshold
+}
+
+func (d *PhiAccrualFailureDetector) record(interval float64) {
+	if len(d.intervals) >= d.config.MaxSampleSize {
+		dropped := d.intervals[0]
+		d.intervals = d.intervals[1:]
+		d.sum -= dropped
+		d.squares -= dropped * dropped
+	}
+	d.intervals = append(d.intervals, interval)
+	d.sum += interval
+	d.squares += interval * interval
+}
+
+// A logistic approximation of the normal CDF, as used by Akka.
+func phi(elapsed float64, mean float64, stdDev float64) float64 {
+	y := (elapsed - mean) / stdDev
+	e := math.Exp(-y * (1.5976 + 0.070566*y*y))
+	if elapsed > mean {
+		return -math.Log10(e / (1 + e))
+	}
+	return -math.Log10(1 - 1/(1+e))
+}
+
+func durationMillis(duration time.Duration) float64 {
+	return float64(duration) / float64(time.Millisecond)
+}
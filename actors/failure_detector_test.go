@@ -0,0 +1,49 @@
+package actors_test
+
+import (
+	"time"
+
+	. "github.com/kphelps/actors/actors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("PhiAccrualFailureDetector", func() {
+	var detector *PhiAccrualFailureDetector
+	var now time.Time
+
+	BeforeEach(func() {
+		detector = NewPhiAccrualFailureDetector(PhiAccrualConfig{
+			FirstHeartbeatEstimate: time.Second,
+			MinStdDev:              10 * time.Millisecond,
+		})
+		now = time.Now()
+		for i := 0; i < 10; i++ {
+			detector.Heartbeat(now)
+			now = now.Add(time.Second)
+		}
+		now = now.Add(-time.Second)
+	})
+
+	It("Is available while heartbeats arrive on time", func() {
+		Expect(detector.IsAvailable(now.Add(500 * time.Millisecond))).To(BeTrue())
+		Expect(detector.IsAvailable(now.Add(time.Second))).To(BeTrue())
+	})
+
+	It("Grows suspicious as heartbeats go missing", func() {
+		early := detector.Phi(now.Add(time.Second))
+		late := detector.Phi(now.Add(2 * time.Second))
+		Expect(late).To(BeNumerically(">", early))
+		Expect(detector.IsAvailable(now.Add(5 * time.Second))).To(BeFalse())
+	})
+
+	It("Tolerates the acceptable pause", func() {
+		detector = NewPhiAccrualFailureDetector(PhiAccrualConfig{
+			FirstHeartbeatEstimate:   time.Second,
+			AcceptableHeartbeatPause: 5 * time.Second,
+		})
+		detector.Heartbeat(now)
+		Expect(detector.IsAvailable(now.Add(5 * time.Second))).To(BeTrue())
+	})
+})
@@ -0,0 +1,323 @@
+package actors
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const (
+	segmentHeaderSize = int64(8)
+	recordHeaderSize  = int64(8)
+	segmentFileSuffix = ".seg"
+)
+
+var segmentMagic = []byte("AJNL\x00\x00\x00\x01")
+
+var errTornRecord = errors.New("torn record")
+
+type journalSegment struct {
+	id      uint64
+	path    string
+	file    *os.File
+	size    int64
+	records int
+	live    int
+}
+
+type segmentLog struct {
+	dir         string
+	segmentSize int64
+	segments    []*journalSegment
+	dirty       bool
+}
+
+type segmentVisitor func(
+	log *segmentLog,
+	segment *journalSegment,
+	offset int64,
+	payload []byte,
+) error
+
+// Opens every segment in dir in order, calling visit for each intact record.
+// A torn or corrupt tail on the last segment is truncated away, since it can
+// only be the result of a crash part way through an append.
+func openSegmentLog(
+	dir string,
+	segmentSize int64,
+	visit segmentVisitor,
+) (*segmentLog, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+segmentFileSuffix))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	log := &segmentLog{
+		dir:         dir,
+		segmentSize: segmentSize,
+		segments:    make([]*journalSegment, 0, len(paths)),
+	}
+	for i, path := range paths {
+		segment, err := openJournalSegment(path)
+		if err != nil {
+			log.close()
+			return nil, err
+		}
+		log.segments = append(log.segments, segment)
+		err = log.recoverSegment(segment, i == len(paths)-1, visit)
+		if err != nil {
+			log.close()
+			return nil, err
+		}
+	}
+
+	if len(log.segments) == 0 {
+		_, err = log.roll()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return log, nil
+}
+
+func segmentPath(dir string, id uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%020d%s", id, segmentFileSuffix))
+}
+
+func openJournalSegment(path string) (*journalSegment, error) {
+	var id uint64
+	_, err := fmt.Sscanf(filepath.Base(path), "%020d"+segmentFileSuffix, &id)
+	if err != nil {
+		return nil, fmt.Errorf("invalid segment name: %s", path)
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &journalSegment{
+		id:   id,
+		path: path,
+		file: file,
+		size: info.Size(),
+	}, nil
+}
+
+func (sl *segmentLog) recoverSegment(
+	segment *journalSegment,
+	last bool,
+	visit segmentVisitor,
+) error {
+	header := make([]byte, segmentHeaderSize)
+	_, err := segment.file.ReadAt(header, 0)
+	if err != nil || string(header) != string(segmentMagic) {
+		if !last || segment.size > segmentHeaderSize {
+			return fmt.Errorf("corrupt journal segment header: %s", segment.path)
+		}
+		return sl.resetSegment(segment)
+	}
+
+	offset := segmentHeaderSize
+	for offset < segment.size {
+		payload, err := readJournalRecord(segment.file, offset, segment.size)
+		if err == errTornRecord && last {
+			return sl.truncateSegment(segment, offset)
+		} else if err != nil {
+			return fmt.Errorf(
+				"corrupt journal segment %s at offset %d: %s",
+				segment.path,
+				offset,
+				err,
+			)
+		}
+		err = visit(sl, segment, offset, payload)
+		if err != nil {
+			return err
+		}
+		segment.records++
+		offset += recordHeaderSize + int64(len(payload))
+	}
+	return nil
+}
+
+func (sl *segmentLog) resetSegment(segment *journalSegment) error {
+	err := segment.file.Truncate(0)
+	if err != nil {
+		return err
+	}
+	_, err = segment.file.WriteAt(segmentMagic, 0)
+	if err != nil {
+		return err
+	}
+	segment.size = segmentHeaderSize
+	return segment.file.Sync()
+}
+
+func (sl *segmentLog) truncateSegment(
+	segment *journalSegment,
+	offset int64,
+) error {
+	err := segment.file.Truncate(offset)
+	if err != nil {
+		return err
+	}
+	segment.size = offset
+	return segment.file.Sync()
+}
+
+func readJournalRecord(
+	file *os.File,
+	offset int64,
+	size int64,
+) ([]byte, error) {
+	if offset+recordHeaderSize > size {
+		return nil, errTornRecord
+	}
+	header := make([]byte, recordHeaderSize)
+	_, err := file.ReadAt(header, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	length := int64(binary.BigEndian.Uint32(header[0:4]))
+	checksum := binary.BigEndian.Uint32(header[4:8])
+	if offset+recordHeaderSize+length > size {
+		return nil, errTornRecord
+	}
+
+	payload := make([]byte, length)
+	_, err = file.ReadAt(payload, offset+recordHeaderSize)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(payload) != checksum {
+		return nil, errTornRecord
+	}
+	return payload, nil
+}
+
+func (sl *segmentLog) active() *journalSegment {
+	return sl.segments[len(sl.segments)-1]
+}
+
+func (sl *segmentLog) roll() (*journalSegment, error) {
+	id := uint64(0)
+	if len(sl.segments) > 0 {
+		current := sl.active()
+		err := current.file.Sync()
+		if err != nil {
+			return nil, err
+		}
+		id = current.id + 1
+	}
+
+	path := segmentPath(sl.dir, id)
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, err
+	}
+	_, err = file.WriteAt(segmentMagic, 0)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	segment := &journalSegment{
+		id:   id,
+		path: path,
+		file: file,
+		size: segmentHeaderSize,
+	}
+	sl.segments = append(sl.segments, segment)
+	return segment, nil
+}
+
+func (sl *segmentLog) append(payload []byte) (*journalSegment, int64, error) {
+	recordSize := recordHeaderSize + int64(len(payload))
+	segment := sl.active()
+	if segment.size > segmentHeaderSize &&
+		segment.size+recordSize > sl.segmentSize {
+		var err error
+		segment, err = sl.roll()
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	record := make([]byte, recordSize)
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(payload))
+	copy(record[recordHeaderSize:], payload)
+
+	offset := segment.size
+	_, err := segment.file.WriteAt(record, offset)
+	if err != nil {
+		// Leave nothing behind that recovery would have to treat as torn.
+		segment.file.Truncate(offset)
+		return nil, 0, err
+	}
+	segment.size += recordSize
+	segment.records++
+	sl.dirty = true
+	return segment, offset, nil
+}
+
+func (sl *segmentLog) read(
+	segment *journalSegment,
+	offset int64,
+) ([]byte, error) {
+	return readJournalRecord(segment.file, offset, segment.size)
+}
+
+func (sl *segmentLog) sync() error {
+	if !sl.dirty {
+		return nil
+	}
+	err := sl.active().file.Sync()
+	if err != nil {
+		return err
+	}
+	sl.dirty = false
+	return nil
+}
+
+func (sl *segmentLog) sealed() []*journalSegment {
+	return sl.segments[:len(sl.segments)-1]
+}
+
+func (sl *segmentLog) remove(segment *journalSegment) error {
+	for i, s := range sl.segments {
+		if s == segment {
+			sl.segments = append(sl.segments[:i], sl.segments[i+1:]...)
+			break
+		}
+	}
+	segment.file.Close()
+	return os.Remove(segment.path)
+}
+
+func (sl *segmentLog) close() error {
+	var firstErr error
+	for _, segment := range sl.segments {
+		err := segment.file.Close()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
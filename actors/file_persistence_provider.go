@@ -0,0 +1,823 @@
+package actors
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/golang/protobuf/proto"
+)
+
+type FileSyncPolicy int
+
+const (
+	FileSyncEveryWrite FileSyncPolicy = iota
+	FileSyncBatched
+	FileSyncInterval
+)
+
+type FileSegmentLayout int
+
+const (
+	SharedSegments FileSegmentLayout = iota
+	PerActorSegments
+)
+
+type FilePersistenceConfig struct {
+	Directory     string
+	Layout        FileSegmentLayout
+	SegmentSize   int64
+	SyncPolicy    FileSyncPolicy
+	SyncBatchSize int
+	SyncInterval  time.Duration
+}
+
+const (
+	fileEventRecord byte = iota + 1
+	fileDeletionRecord
+	fileTaggedEventRecord
+)
+
+type fileRecord struct {
+	kind       byte
+	actorID    string
+	sequenceID uint64
+	offset     gocql.UUID
+	tags       []string
+	eventType  string
+	event      []byte
+}
+
+type fileTagEntry struct {
+	actorID    string
+	sequenceID uint64
+	offset     gocql.UUID
+}
+
+type fileEventPosition struct {
+	log     *segmentLog
+	segment *journalSegment
+	offset  int64
+}
+
+type fileActorIndex struct {
+	firstSequenceID uint64
+	positions       []fileEventPosition
+	markerSegment   *journalSegment
+}
+
+type fileRecoveryState struct {
+	positions     map[uint64]fileEventPosition
+	deleted       bool
+	deletedTo     uint64
+	markerSegment *journalSegment
+}
+
+type FilePersistenceProvider struct {
+	sync.RWMutex
+	config       FilePersistenceConfig
+	logs         map[string]*segmentLog
+	index        map[string]*fileActorIndex
+	tags         map[string][]fileTagEntry
+	offsets      offsetClock
+	recovery     map[string]*fileRecoveryState
+	unsynced     int
+	stopSyncLoop chan struct{}
+	writeNotifier
+}
+
+func ConfigureFilePersistenceProvider(config FilePersistenceConfig) error {
+	return initPersistenceProvider(NewFilePersistenceProvider(config))
+}
+
+func NewFilePersistenceProvider(
+	config FilePersistenceConfig,
+) *FilePersistenceProvider {
+	if config.SegmentSize <= 0 {
+		config.SegmentSize = 64 * 1024 * 1024
+	}
+	if config.SyncBatchSize <= 0 {
+		config.SyncBatchSize = 100
+	}
+	if config.SyncInterval <= 0 {
+		config.SyncInterval = 100 * time.Millisecond
+	}
+	return &FilePersistenceProvider{
+		config: config,
+		logs:   make(map[string]*segmentLog),
+		index:  make(map[string]*fileActorIndex),
+		tags:   make(map[string][]fileTagEntry),
+	}
+}
+
+func (f *FilePersistenceProvider) Initialize() error {
+	f.Lock()
+	defer f.Unlock()
+
+	f.recovery = make(map[string]*fileRecoveryState)
+	var err error
+	if f.config.Layout == PerActorSegments {
+		err = f.recoverActorLogs()
+	} else {
+		_, err = f.openLog(filepath.Join(f.config.Directory, "journal"))
+	}
+	if err == nil {
+		err = f.finishRecovery()
+	}
+	if err != nil {
+		f.closeLogs()
+		return err
+	}
+
+	if f.config.SyncPolicy == FileSyncInterval {
+		f.stopSyncLoop = make(chan struct{})
+		go f.syncLoop(f.stopSyncLoop)
+	}
+	return nil
+}
+
+func (f *FilePersistenceProvider) Close() error {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.stopSyncLoop != nil {
+		close(f.stopSyncLoop)
+		f.stopSyncLoop = nil
+	}
+	err := f.syncLogs()
+	closeErr := f.closeLogs()
+	if err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (f *FilePersistenceProvider) recoverActorLogs() error {
+	actorsDir := filepath.Join(f.config.Directory, "actors")
+	entries, err := ioutil.ReadDir(actorsDir)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		_, err := f.openLog(filepath.Join(actorsDir, entry.Name()))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *FilePersistenceProvider) openLog(dir string) (*segmentLog, error) {
+	log, err := openSegmentLog(dir, f.config.SegmentSize, f.recoverRecord)
+	if err != nil {
+		return nil, err
+	}
+	f.logs[dir] = log
+	return log, nil
+}
+
+func (f *FilePersistenceProvider) closeLogs() error {
+	var firstErr error
+	for dir, log := range f.logs {
+		err := log.close()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(f.logs, dir)
+	}
+	return firstErr
+}
+
+func (f *FilePersistenceProvider) logFor(actorID string) (*segmentLog, error) {
+	dir := filepath.Join(f.config.Directory, "journal")
+	if f.config.Layout == PerActorSegments {
+		dir = filepath.Join(
+			f.config.Directory,
+			"actors",
+			hex.EncodeToString([]byte(actorID)),
+		)
+	}
+	log, found := f.logs[dir]
+	if found {
+		return log, nil
+	}
+	return f.openLog(dir)
+}
+
+func (f *FilePersistenceProvider) recoverRecord(
+	log *segmentLog,
+	segment *journalSegment,
+	offset int64,
+	payload []byte,
+) error {
+	record, err := unmarshalFileRecord(payload)
+	if err != nil {
+		return err
+	}
+
+	state, found := f.recovery[record.actorID]
+	if !found {
+		state = &fileRecoveryState{
+			positions: make(map[uint64]fileEventPosition),
+		}
+		f.recovery[record.actorID] = state
+	}
+
+	switch record.kind {
+	case fileTaggedEventRecord:
+		f.offsets.observe(record.offset)
+		for _, tag := range record.tags {
+			f.tags[tag] = append(f.tags[tag], fileTagEntry{
+				actorID:    record.actorID,
+				sequenceID: record.sequenceID,
+				offset:     record.offset,
+			})
+		}
+		fallthrough
+	case fileEventRecord:
+		// Later records win, so a copy made by compaction replaces the
+		// original if the source segment had not been removed yet.
+		state.positions[record.sequenceID] = fileEventPosition{
+			log,
+			segment,
+			offset,
+		}
+	case fileDeletionRecord:
+		if !state.deleted || record.sequenceID > state.deletedTo {
+			state.deleted = true
+			state.deletedTo = record.sequenceID
+		}
+		state.markerSegment = segment
+	}
+	return nil
+}
+
+// Records are scanned in file order, which compaction does not preserve, so
+// the index is only assembled once every log has been read.
+func (f *FilePersistenceProvider) finishRecovery() error {
+	defer func() { f.recovery = nil }()
+
+	for tag, entries := range f.tags {
+		sort.Slice(entries, func(i, j int) bool {
+			return compareOffsets(entries[i].offset, entries[j].offset) < 0
+		})
+		unique := entries[:0]
+		for i, entry := range entries {
+			if i == 0 || entry != entries[i-1] {
+				unique = append(unique, entry)
+			}
+		}
+		f.tags[tag] = unique
+	}
+
+	for actorID, state := range f.recovery {
+		index := f.actorIndex(actorID)
+		index.markerSegment = state.markerSegment
+
+		first := uint64(0)
+		if state.deleted {
+			first = state.deletedTo + 1
+		} else if len(state.positions) == 0 {
+			continue
+		} else {
+			first = ^uint64(0)
+			for sequenceID := range state.positions {
+				if sequenceID < first {
+					first = sequenceID
+				}
+			}
+		}
+		index.firstSequenceID = first
+
+		for sequenceID := first; ; sequenceID++ {
+			position, found := state.positions[sequenceID]
+			if !found {
+				break
+			}
+			position.segment.live++
+			index.positions = append(index.positions, position)
+		}
+
+		for sequenceID := range state.positions {
+			if sequenceID >= index.nextSequenceID() {
+				return errors.New("corrupt journal: sequence gap")
+			}
+		}
+	}
+	return nil
+}
+
+func (f *FilePersistenceProvider) actorIndex(actorID string) *fileActorIndex {
+	index, found := f.index[actorID]
+	if !found {
+		index = &fileActorIndex{
+			positions: make([]fileEventPosition, 0),
+		}
+		f.index[actorID] = index
+	}
+	return index
+}
+
+func (fai *fileActorIndex) nextSequenceID() uint64 {
+	return fai.firstSequenceID + uint64(len(fai.positions))
+}
+
+func (fai *fileActorIndex) deleteTo(sequenceID uint64) {
+	if sequenceID < fai.firstSequenceID {
+		return
+	}
+	count := sequenceID - fai.firstSequenceID + 1
+	if count > uint64(len(fai.positions)) {
+		count = uint64(len(fai.positions))
+	}
+	for _, position := range fai.positions[:count] {
+		position.segment.live--
+	}
+	fai.positions = fai.positions[count:]
+	fai.firstSequenceID = sequenceID + 1
+}
+
+// segmentsTo lists the segments holding events up to sequenceID.
+func (fai *fileActorIndex) segmentsTo(sequenceID uint64) []*journalSegment {
+	segments := make([]*journalSegment, 0)
+	seen := make(map[*journalSegment]bool)
+	for i, position := range fai.positions {
+		if fai.firstSequenceID+uint64(i) > sequenceID {
+			break
+		}
+		if !seen[position.segment] {
+			seen[position.segment] = true
+			segments = append(segments, position.segment)
+		}
+	}
+	return segments
+}
+
+func (f *FilePersistenceProvider) GetEvent(
+	actorID string,
+	sequenceID uint64,
+) (PersistentEvent, error) {
+	f.RLock()
+	defer f.RUnlock()
+	return f.getEvent(actorID, sequenceID)
+}
+
+func (f *FilePersistenceProvider) getEvent(
+	actorID string,
+	sequenceID uint64,
+) (PersistentEvent, error) {
+	index, found := f.index[actorID]
+	if !found ||
+		sequenceID < index.firstSequenceID ||
+		sequenceID >= index.nextSequenceID() {
+		return PersistentEvent{}, errors.New("not found")
+	}
+
+	position := index.positions[sequenceID-index.firstSequenceID]
+	payload, err := position.log.read(position.segment, position.offset)
+	if err != nil {
+		return PersistentEvent{}, err
+	}
+	record, err := unmarshalFileRecord(payload)
+	if err != nil {
+		return PersistentEvent{}, err
+	}
+	event, err := deserializeEvent(record.eventType, record.event)
+	return PersistentEvent{
+		ActorID:    actorID,
+		SequenceID: sequenceID,
+		Offset:     record.offset,
+		Event:      event,
+	}, err
+}
+
+func (f *FilePersistenceProvider) GetEvents(
+	actorID string,
+	sequenceID uint64,
+) ([]PersistentEvent, error) {
+	f.RLock()
+	defer f.RUnlock()
+
+	index, found := f.index[actorID]
+	if !found {
+		return []PersistentEvent{}, nil
+	}
+	if sequenceID < index.firstSequenceID {
+		sequenceID = index.firstSequenceID
+	}
+
+	events := make([]PersistentEvent, 0)
+	for i := sequenceID; i < index.nextSequenceID(); i++ {
+		event, err := f.getEvent(actorID, i)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+func (f *FilePersistenceProvider) PersistEvent(
+	actorID string,
+	sequenceID uint64,
+	event proto.Message,
+) error {
+	event, tags := untagEvent(event)
+	data, err := serializeEvent(event)
+	if err != nil {
+		return err
+	}
+
+	f.Lock()
+	defer f.Unlock()
+
+	index := f.actorIndex(actorID)
+	if sequenceID != index.nextSequenceID() {
+		return errors.New("Invalid sequenceID")
+	}
+
+	log, err := f.logFor(actorID)
+	if err != nil {
+		return err
+	}
+	record := fileRecord{
+		kind:       fileTaggedEventRecord,
+		actorID:    actorID,
+		sequenceID: sequenceID,
+		offset:     f.offsets.next(),
+		tags:       tags,
+		eventType:  proto.MessageName(event),
+		event:      data,
+	}
+	segment, offset, err := log.append(record.marshal())
+	if err != nil {
+		return err
+	}
+	segment.live++
+	index.positions = append(
+		index.positions,
+		fileEventPosition{log, segment, offset},
+	)
+	for _, tag := range tags {
+		f.tags[tag] = append(f.tags[tag], fileTagEntry{
+			actorID:    actorID,
+			sequenceID: sequenceID,
+			offset:     record.offset,
+		})
+	}
+	err = f.syncAfterWrite()
+	if err != nil {
+		return err
+	}
+	f.notifyWritten(actorID)
+	return nil
+}
+
+func (f *FilePersistenceProvider) MaxSequenceID(
+	actorID string,
+) (uint64, error) {
+	f.RLock()
+	defer f.RUnlock()
+
+	index, found := f.index[actorID]
+	if !found || index.nextSequenceID() == 0 {
+		return 0, errors.New("not found")
+	}
+	return index.nextSequenceID() - 1, nil
+}
+
+func (f *FilePersistenceProvider) DeleteEvents(
+	actorID string,
+	toSequenceID uint64,
+	mode DeletionMode,
+) error {
+	f.Lock()
+	defer f.Unlock()
+
+	index, found := f.index[actorID]
+	if !found || index.nextSequenceID() == 0 {
+		return nil
+	}
+	if toSequenceID >= index.nextSequenceID() {
+		toSequenceID = index.nextSequenceID() - 1
+	}
+	if toSequenceID < index.firstSequenceID {
+		return nil
+	}
+
+	log, err := f.logFor(actorID)
+	if err != nil {
+		return err
+	}
+	segments := index.segmentsTo(toSequenceID)
+	err = f.appendDeletionMarker(log, actorID, toSequenceID)
+	if err != nil {
+		return err
+	}
+	index.deleteTo(toSequenceID)
+
+	err = f.syncLogs()
+	if err != nil {
+		return err
+	}
+	if mode == PhysicalDeletion {
+		return f.removeSegments(log, segments)
+	}
+	return nil
+}
+
+func (f *FilePersistenceProvider) EventsByTag(
+	tag string,
+	offset gocql.UUID,
+	limit int,
+) ([]PersistentEvent, error) {
+	f.RLock()
+	defer f.RUnlock()
+
+	entries := f.tags[tag]
+	start := sort.Search(len(entries), func(i int) bool {
+		return compareOffsets(entries[i].offset, offset) > 0
+	})
+
+	events := make([]PersistentEvent, 0)
+	for _, entry := range entries[start:] {
+		if len(events) >= limit {
+			break
+		}
+		event, err := f.getEvent(entry.actorID, entry.sequenceID)
+		if err != nil && err.Error() == "not found" {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+func (f *FilePersistenceProvider) PersistenceIDs() ([]string, error) {
+	f.RLock()
+	defer f.RUnlock()
+
+	ids := make([]string, 0, len(f.index))
+	for actorID, index := range f.index {
+		if index.nextSequenceID() > 0 {
+			ids = append(ids, actorID)
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// Compact reclaims the space held by logically deleted events. Segments left
+// with no live events are deleted and sparsely populated ones are rewritten.
+func (f *FilePersistenceProvider) Compact() error {
+	f.Lock()
+	defer f.Unlock()
+
+	for _, log := range f.logs {
+		err := f.collectSegments(log)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *FilePersistenceProvider) appendDeletionMarker(
+	log *segmentLog,
+	actorID string,
+	sequenceID uint64,
+) error {
+	record := fileRecord{
+		kind:       fileDeletionRecord,
+		actorID:    actorID,
+		sequenceID: sequenceID,
+	}
+	segment, _, err := log.append(record.marshal())
+	if err != nil {
+		return err
+	}
+	f.actorIndex(actorID).markerSegment = segment
+	return nil
+}
+
+func (f *FilePersistenceProvider) collectSegments(log *segmentLog) error {
+	for _, segment := range append([]*journalSegment{}, log.sealed()...) {
+		if segment.live > 0 && segment.live*2 >= segment.records {
+			continue
+		}
+		err := f.rewriteSegment(log, segment)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeSegments rewrites every one of segments however many live events
+// they still hold, so that deleted events are gone from disk. The active
+// segment is rolled first since only sealed segments can be removed.
+func (f *FilePersistenceProvider) removeSegments(
+	log *segmentLog,
+	segments []*journalSegment,
+) error {
+	for _, segment := range segments {
+		if segment == log.active() {
+			_, err := log.roll()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for _, segment := range segments {
+		err := f.rewriteSegment(log, segment)
+		if err != nil {
+			return err
+		}
+	}
+	return f.collectSegments(log)
+}
+
+// Copies the live events and deletion markers out of segment into the
+// active one so that the segment file can be deleted.
+func (f *FilePersistenceProvider) rewriteSegment(
+	log *segmentLog,
+	segment *journalSegment,
+) error {
+	for actorID, index := range f.index {
+		for i, position := range index.positions {
+			if position.segment != segment {
+				continue
+			}
+			payload, err := log.read(segment, position.offset)
+			if err != nil {
+				return err
+			}
+			newSegment, offset, err := log.append(payload)
+			if err != nil {
+				return err
+			}
+			newSegment.live++
+			index.positions[i] = fileEventPosition{log, newSegment, offset}
+		}
+		if index.markerSegment == segment {
+			err := f.appendDeletionMarker(
+				log,
+				actorID,
+				index.firstSequenceID-1,
+			)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err := log.sync()
+	if err != nil {
+		return err
+	}
+	return log.remove(segment)
+}
+
+func (f *FilePersistenceProvider) syncAfterWrite() error {
+	switch f.config.SyncPolicy {
+	case FileSyncEveryWrite:
+		return f.syncLogs()
+	case FileSyncBatched:
+		f.unsynced++
+		if f.unsynced >= f.config.SyncBatchSize {
+			return f.syncLogs()
+		}
+	}
+	return nil
+}
+
+func (f *FilePersistenceProvider) syncLogs() error {
+	f.unsynced = 0
+	for _, log := range f.logs {
+		err := log.sync()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *FilePersistenceProvider) syncLoop(stop chan struct{}) {
+	ticker := time.NewTicker(f.config.SyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			f.Lock()
+			f.syncLogs()
+			f.Unlock()
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (r *fileRecord) marshal() []byte {
+	size := 1 + 8 +
+		binary.MaxVarintLen64 + len(r.actorID) +
+		binary.MaxVarintLen64 + len(r.eventType) +
+		len(r.event)
+	if r.kind == fileTaggedEventRecord {
+		size += len(r.offset) + binary.MaxVarintLen64
+		for _, tag := range r.tags {
+			size += binary.MaxVarintLen64 + len(tag)
+		}
+	}
+
+	buf := make([]byte, size)
+	buf[0] = r.kind
+	n := 1
+	putString := func(value string) {
+		n += binary.PutUvarint(buf[n:], uint64(len(value)))
+		n += copy(buf[n:], value)
+	}
+
+	putString(r.actorID)
+	binary.BigEndian.PutUint64(buf[n:], r.sequenceID)
+	n += 8
+	if r.kind == fileTaggedEventRecord {
+		n += copy(buf[n:], r.offset[:])
+		n += binary.PutUvarint(buf[n:], uint64(len(r.tags)))
+		for _, tag := range r.tags {
+			putString(tag)
+		}
+	}
+	putString(r.eventType)
+	n += copy(buf[n:], r.event)
+	return buf[:n]
+}
+
+func unmarshalFileRecord(data []byte) (fileRecord, error) {
+	invalid := errors.New("invalid journal record")
+	record := fileRecord{}
+	if len(data) < 1 {
+		return record, invalid
+	}
+	record.kind = data[0]
+	n := 1
+
+	readString := func() (string, bool) {
+		length, read := binary.Uvarint(data[n:])
+		if read <= 0 || uint64(len(data)-n-read) < length {
+			return "", false
+		}
+		n += read
+		value := string(data[n : n+int(length)])
+		n += int(length)
+		return value, true
+	}
+
+	actorID, ok := readString()
+	if !ok || len(data)-n < 8 {
+		return record, invalid
+	}
+	record.actorID = actorID
+	record.sequenceID = binary.BigEndian.Uint64(data[n:])
+	n += 8
+
+	if record.kind == fileTaggedEventRecord {
+		if len(data)-n < len(record.offset) {
+			return record, invalid
+		}
+		n += copy(record.offset[:], data[n:])
+		count, read := binary.Uvarint(data[n:])
+		if read <= 0 {
+			return record, invalid
+		}
+		n += read
+		for i := uint64(0); i < count; i++ {
+			tag, ok := readString()
+			if !ok {
+				return record, invalid
+			}
+			record.tags = append(record.tags, tag)
+		}
+	}
+
+	eventType, ok := readString()
+	if !ok {
+		return record, invalid
+	}
+	record.eventType = eventType
+	record.event = data[n:]
+	return record, nil
+}
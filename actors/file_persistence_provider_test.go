@@ -0,0 +1,259 @@
+package actors_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/gocql/gocql"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	. "github.com/kphelps/actors/actors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("FilePersistenceProvider", func() {
+	var dir string
+	var config FilePersistenceConfig
+	var provider *FilePersistenceProvider
+
+	event := func(value string) *wrappers.StringValue {
+		return &wrappers.StringValue{Value: value}
+	}
+
+	open := func() {
+		provider = NewFilePersistenceProvider(config)
+		Expect(provider.Initialize()).To(Succeed())
+	}
+
+	reopen := func() {
+		Expect(provider.Close()).To(Succeed())
+		open()
+	}
+
+	persistN := func(actorID string, n int) {
+		for i := 0; i < n; i++ {
+			value := fmt.Sprintf("%s-%d", actorID, i)
+			err := provider.PersistEvent(actorID, uint64(i), event(value))
+			Expect(err).NotTo(HaveOccurred())
+		}
+	}
+
+	expectEvents := func(actorID string, from uint64, to uint64) {
+		events, err := provider.GetEvents(actorID, from)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(events).To(HaveLen(int(to - from)))
+		for i, e := range events {
+			sequenceID := from + uint64(i)
+			Expect(e.SequenceID).To(Equal(sequenceID))
+			value := fmt.Sprintf("%s-%d", actorID, sequenceID)
+			Expect(e.Event).To(Equal(event(value)))
+		}
+	}
+
+	segmentFiles := func() []string {
+		files, err := filepath.Glob(
+			filepath.Join(dir, "journal", "*.seg"),
+		)
+		Expect(err).NotTo(HaveOccurred())
+		return files
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "file-journal")
+		Expect(err).NotTo(HaveOccurred())
+		config = FilePersistenceConfig{Directory: dir}
+		open()
+	})
+
+	AfterEach(func() {
+		provider.Close()
+		os.RemoveAll(dir)
+	})
+
+	It("Reads back persisted events", func() {
+		persistN("a", 3)
+		persistN("b", 2)
+		expectEvents("a", 0, 3)
+		expectEvents("b", 0, 2)
+		max, err := provider.MaxSequenceID("a")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(max).To(Equal(uint64(2)))
+	})
+
+	It("Rejects out of order sequence IDs", func() {
+		persistN("a", 2)
+		Expect(provider.PersistEvent("a", 1, event("x"))).NotTo(Succeed())
+		Expect(provider.PersistEvent("a", 3, event("x"))).NotTo(Succeed())
+	})
+
+	It("Recovers events after a restart", func() {
+		persistN("a", 5)
+		reopen()
+		expectEvents("a", 0, 5)
+		Expect(provider.PersistEvent("a", 5, event("a-5"))).To(Succeed())
+		expectEvents("a", 0, 6)
+	})
+
+	It("Recovers the tag index after a restart", func() {
+		for i := uint64(0); i < 3; i++ {
+			value := fmt.Sprintf("a-%d", i)
+			tagged := &Tagged{Event: event(value), Tags: []string{"t"}}
+			Expect(provider.PersistEvent("a", i, tagged)).To(Succeed())
+		}
+		before, err := provider.EventsByTag("t", gocql.UUID{}, 10)
+		Expect(err).NotTo(HaveOccurred())
+
+		reopen()
+		after, err := provider.EventsByTag("t", gocql.UUID{}, 10)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(after).To(Equal(before))
+
+		tagged := &Tagged{Event: event("a-3"), Tags: []string{"t"}}
+		Expect(provider.PersistEvent("a", 3, tagged)).To(Succeed())
+		rest, err := provider.EventsByTag("t", after[2].Offset, 10)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rest).To(HaveLen(1))
+		Expect(rest[0].Event).To(Equal(event("a-3")))
+	})
+
+	It("Truncates a torn write on recovery", func() {
+		persistN("a", 3)
+		Expect(provider.Close()).To(Succeed())
+
+		files := segmentFiles()
+		file, err := os.OpenFile(
+			files[len(files)-1],
+			os.O_WRONLY|os.O_APPEND,
+			0644,
+		)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = file.Write([]byte{0, 0, 0, 40, 1, 2, 3, 4, 5})
+		Expect(err).NotTo(HaveOccurred())
+		file.Close()
+
+		open()
+		expectEvents("a", 0, 3)
+		Expect(provider.PersistEvent("a", 3, event("a-3"))).To(Succeed())
+		reopen()
+		expectEvents("a", 0, 4)
+	})
+
+	It("Removes physically deleted events from the active segment", func() {
+		persistN("a", 3)
+		Expect(provider.DeleteEvents("a", 1, PhysicalDeletion)).To(Succeed())
+		for _, file := range segmentFiles() {
+			contents, err := ioutil.ReadFile(file)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(contents)).NotTo(ContainSubstring("a-1"))
+		}
+		expectEvents("a", 2, 3)
+
+		reopen()
+		expectEvents("a", 2, 3)
+	})
+
+	Context("Small segments", func() {
+		BeforeEach(func() {
+			Expect(provider.Close()).To(Succeed())
+			config.SegmentSize = 128
+			open()
+		})
+
+		It("Rolls to a new segment", func() {
+			persistN("a", 20)
+			Expect(len(segmentFiles())).To(BeNumerically(">", 1))
+			reopen()
+			expectEvents("a", 0, 20)
+		})
+
+		It("Removes physically deleted segments", func() {
+			persistN("a", 20)
+			before := len(segmentFiles())
+			Expect(provider.DeleteEvents("a", 14, PhysicalDeletion)).To(Succeed())
+			Expect(len(segmentFiles())).To(BeNumerically("<", before))
+			expectEvents("a", 15, 20)
+			_, err := provider.GetEvent("a", 3)
+			Expect(err).To(HaveOccurred())
+
+			reopen()
+			expectEvents("a", 15, 20)
+			Expect(provider.PersistEvent("a", 20, event("a-20"))).To(Succeed())
+		})
+
+		It("Keeps logically deleted segments until compaction", func() {
+			persistN("a", 20)
+			before := len(segmentFiles())
+			Expect(provider.DeleteEvents("a", 14, LogicalDeletion)).To(Succeed())
+			Expect(len(segmentFiles())).To(BeNumerically(">=", before))
+			expectEvents("a", 15, 20)
+
+			Expect(provider.Compact()).To(Succeed())
+			Expect(len(segmentFiles())).To(BeNumerically("<", before))
+			reopen()
+			expectEvents("a", 15, 20)
+		})
+
+		It("Keeps other actors' events when compacting", func() {
+			for i := 0; i < 10; i++ {
+				persistN(fmt.Sprintf("actor-%d", i), 5)
+			}
+			Expect(provider.DeleteEvents("actor-0", 4, PhysicalDeletion)).To(Succeed())
+			reopen()
+			for i := 1; i < 10; i++ {
+				expectEvents(fmt.Sprintf("actor-%d", i), 0, 5)
+			}
+			events, err := provider.GetEvents("actor-0", 0)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(events).To(BeEmpty())
+		})
+	})
+
+	Context("Per-actor segments", func() {
+		BeforeEach(func() {
+			Expect(provider.Close()).To(Succeed())
+			config.Layout = PerActorSegments
+			open()
+		})
+
+		It("Recovers each actor's log", func() {
+			persistN("a", 3)
+			persistN("b/c", 4)
+			reopen()
+			expectEvents("a", 0, 3)
+			expectEvents("b/c", 0, 4)
+		})
+	})
+
+	Context("Batched sync", func() {
+		BeforeEach(func() {
+			Expect(provider.Close()).To(Succeed())
+			config.SyncPolicy = FileSyncBatched
+			config.SyncBatchSize = 4
+			open()
+		})
+
+		It("Persists events", func() {
+			persistN("a", 10)
+			reopen()
+			expectEvents("a", 0, 10)
+		})
+	})
+
+	Context("Interval sync", func() {
+		BeforeEach(func() {
+			Expect(provider.Close()).To(Succeed())
+			config.SyncPolicy = FileSyncInterval
+			open()
+		})
+
+		It("Persists events", func() {
+			persistN("a", 10)
+			reopen()
+			expectEvents("a", 0, 10)
+		})
+	})
+})
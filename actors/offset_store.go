@@ -0,0 +1,136 @@
+package actors
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/gocql/gocql"
+	"github.com/scylladb/gocqlx"
+	"github.com/scylladb/gocqlx/qb"
+)
+
+type InMemoryOffsetStore struct {
+	sync.Mutex
+	offsets map[string]Offset
+}
+
+func NewInMemoryOffsetStore() *InMemoryOffsetStore {
+	return &InMemoryOffsetStore{
+		offsets: make(map[string]Offset),
+	}
+}
+
+func (s *InMemoryOffsetStore) GetOffset(name string) (Offset, error) {
+	s.Lock()
+	defer s.Unlock()
+	return s.offsets[name], nil
+}
+
+func (s *InMemoryOffsetStore) SaveOffset(name string, offset Offset) error {
+	s.Lock()
+	defer s.Unlock()
+	s.offsets[name] = offset
+	return nil
+}
+
+// Effects committed to an InMemoryOffsetStore are funcs run under the
+// store's lock; the offset only moves if the func succeeds.
+func (s *InMemoryOffsetStore) CommitWithOffset(
+	name string,
+	offset Offset,
+	effect ProjectionEffect,
+) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if effect != nil {
+		apply, ok := effect.(func() error)
+		if !ok {
+			return errors.New("in-memory effects must be a func() error")
+		}
+		err := apply()
+		if err != nil {
+			return err
+		}
+	}
+	s.offsets[name] = offset
+	return nil
+}
+
+type CassandraOffsetStore struct {
+	cassandra       *gocql.Session
+	sequenceTracker SequenceTracker
+}
+
+func NewCassandraOffsetStore(
+	cassandra *gocql.Session,
+	sequenceTracker SequenceTracker,
+) *CassandraOffsetStore {
+	return &CassandraOffsetStore{
+		cassandra:       cassandra,
+		sequenceTracker: sequenceTracker,
+	}
+}
+
+// Sequence offsets are kept by the SequenceTracker, so read sides keep the
+// offsets they stored before time-UUID offsets existed. Time-UUID offsets go
+// to the projection_offsets table.
+func (s *CassandraOffsetStore) GetOffset(name string) (Offset, error) {
+	sequenceID, err := s.sequenceTracker.GetSequenceID(name)
+	if err != nil {
+		return Offset{}, err
+	}
+
+	stmt, names := qb.Select("projection_offsets").
+		Columns("time_uuid").
+		Where(qb.Eq("name")).
+		ToCql()
+	q := gocqlx.Query(s.cassandra.Query(stmt), names).BindMap(qb.M{
+		"name": name,
+	})
+	var timeUUID gocql.UUID
+	err = gocqlx.Get(&timeUUID, q.Query)
+	if err != nil && err != gocql.ErrNotFound {
+		return Offset{}, err
+	}
+	return Offset{Sequence: sequenceID, TimeUUID: timeUUID}, nil
+}
+
+func (s *CassandraOffsetStore) SaveOffset(name string, offset Offset) error {
+	return s.offsetQuery(name, offset).Execute(s.cassandra)
+}
+
+func (s *CassandraOffsetStore) offsetQuery(
+	name string,
+	offset Offset,
+) BatchableQuery {
+	if offset.TimeUUID == (gocql.UUID{}) {
+		return s.sequenceTracker.UpdateSequence(name, offset.Sequence)
+	}
+	stmt, names := qb.Update("projection_offsets").
+		Where(qb.Eq("name")).
+		Set("time_uuid").
+		ToCql()
+	return QueryFromMap(stmt, names, qb.M{
+		"name":      name,
+		"time_uuid": offset.TimeUUID,
+	})
+}
+
+// Effects committed to a CassandraOffsetStore are BatchableQuery values,
+// executed in one logged batch with the offset update.
+func (s *CassandraOffsetStore) CommitWithOffset(
+	name string,
+	offset Offset,
+	effect ProjectionEffect,
+) error {
+	q := s.offsetQuery(name, offset)
+	if effect != nil {
+		query, ok := effect.(BatchableQuery)
+		if !ok {
+			return errors.New("cassandra effects must be a BatchableQuery")
+		}
+		q = query.Merge(q)
+	}
+	return q.Execute(s.cassandra)
+}
@@ -2,8 +2,12 @@ package actors
 
 import (
 	"errors"
+	"fmt"
+	"reflect"
+	"sort"
 	"sync"
 
+	"github.com/gocql/gocql"
 	"github.com/golang/protobuf/proto"
 )
 
@@ -15,26 +19,71 @@ func initPersistenceProvider(provider PersistenceProvider) error {
 }
 
 type PersistentEvent struct {
+	ActorID    string
 	SequenceID uint64
+	Offset     gocql.UUID
 	Event      proto.Message
 }
 
+type DeletionMode int
+
+const (
+	// Deleted events are hidden behind a marker but remain in storage.
+	LogicalDeletion DeletionMode = iota
+	// Deleted events are removed from storage.
+	PhysicalDeletion
+)
+
 type PersistenceProvider interface {
 	Initialize() error
 	GetEvent(actorID string, sequenceID uint64) (PersistentEvent, error)
 	GetEvents(actorID string, sequenceID uint64) ([]PersistentEvent, error)
 	PersistEvent(actorID string, sequenceID uint64, event proto.Message) error
 	MaxSequenceID(actorID string) (uint64, error)
+	DeleteEvents(actorID string, toSequenceID uint64, mode DeletionMode) error
+	EventsByTag(tag string, offset gocql.UUID, limit int) ([]PersistentEvent, error)
+	PersistenceIDs() ([]string, error)
+}
+
+func serializeEvent(event proto.Message) ([]byte, error) {
+	return proto.Marshal(event)
+}
+
+func deserializeEvent(typeName string, data []byte) (proto.Message, error) {
+	messageType := proto.MessageType(typeName)
+	if messageType == nil {
+		return nil, fmt.Errorf("unknown event type: %s", typeName)
+	}
+	message := reflect.New(messageType.Elem()).Interface().(proto.Message)
+	err := proto.Unmarshal(data, message)
+	return message, err
+}
+
+type inMemoryEvent struct {
+	event  proto.Message
+	offset gocql.UUID
+}
+
+type inMemoryTagEntry struct {
+	actorID    string
+	sequenceID uint64
+	offset     gocql.UUID
 }
 
 type InMemoryPersistenceProvider struct {
 	sync.Mutex
-	events map[string][]proto.Message
+	events    map[string][]inMemoryEvent
+	deletedTo map[string]uint64
+	tags      map[string][]inMemoryTagEntry
+	offsets   offsetClock
+	writeNotifier
 }
 
 func NewPersistenceProvider() PersistenceProvider {
 	return &InMemoryPersistenceProvider{
-		events: make(map[string][]proto.Message),
+		events:    make(map[string][]inMemoryEvent),
+		deletedTo: make(map[string]uint64),
+		tags:      make(map[string][]inMemoryTagEntry),
 	}
 }
 
@@ -53,13 +102,18 @@ func (imp *InMemoryPersistenceProvider) GetEvents(
 	imp.Lock()
 	defer imp.Unlock()
 
-	out := make([]PersistentEvent, uint64(len(imp.events[actorID]))-sequenceID)
-	for i := uint64(0); i < uint64(len(imp.events[actorID]))-sequenceID; i++ {
-		event, err := imp.GetEvent(actorID, sequenceID+i)
+	deletedTo, deleted := imp.deletedTo[actorID]
+	if deleted && sequenceID <= deletedTo {
+		sequenceID = deletedTo + 1
+	}
+
+	out := make([]PersistentEvent, 0)
+	for i := sequenceID; i < uint64(len(imp.events[actorID])); i++ {
+		event, err := imp.getEvent(actorID, i)
 		if err != nil {
-			return []PersistentEvent{}, nil
+			return nil, err
 		}
-		out[i] = event
+		out = append(out, event)
 	}
 	return out, nil
 }
@@ -67,20 +121,36 @@ func (imp *InMemoryPersistenceProvider) GetEvents(
 func (i *InMemoryPersistenceProvider) GetEvent(
 	actorID string,
 	sequenceID uint64,
+) (PersistentEvent, error) {
+	i.Lock()
+	defer i.Unlock()
+	return i.getEvent(actorID, sequenceID)
+}
+
+func (i *InMemoryPersistenceProvider) getEvent(
+	actorID string,
+	sequenceID uint64,
 ) (PersistentEvent, error) {
 	actorEvents, found := i.events[actorID]
 	if !found {
 		return PersistentEvent{}, errors.New("not found")
 	}
 
-	if sequenceID > uint64(len(actorEvents)) {
+	if sequenceID >= uint64(len(actorEvents)) {
+		return PersistentEvent{}, errors.New("not found")
+	}
+
+	deletedTo, deleted := i.deletedTo[actorID]
+	if deleted && sequenceID <= deletedTo {
 		return PersistentEvent{}, errors.New("not found")
 	}
 
 	event := actorEvents[sequenceID]
 	return PersistentEvent{
+		ActorID:    actorID,
 		SequenceID: sequenceID,
-		Event:      event,
+		Offset:     event.offset,
+		Event:      event.event,
 	}, nil
 }
 
@@ -92,24 +162,107 @@ func (i *InMemoryPersistenceProvider) PersistEvent(
 	i.Lock()
 	defer i.Unlock()
 
-	actorEvents, found := i.events[actorID]
-	if !found {
-		i.events[actorID] = []proto.Message{event}
-	} else {
-		if sequenceID != uint64(len(actorEvents)) {
-			return errors.New("Invalid sequenceID")
-		}
-		i.events[actorID] = append(actorEvents, event)
+	actorEvents := i.events[actorID]
+	if sequenceID != uint64(len(actorEvents)) {
+		return errors.New("Invalid sequenceID")
 	}
+	event, tags := untagEvent(event)
+	offset := i.offsets.next()
+	i.events[actorID] = append(actorEvents, inMemoryEvent{
+		event:  event,
+		offset: offset,
+	})
+	for _, tag := range tags {
+		i.tags[tag] = append(i.tags[tag], inMemoryTagEntry{
+			actorID:    actorID,
+			sequenceID: sequenceID,
+			offset:     offset,
+		})
+	}
+	i.notifyWritten(actorID)
 	return nil
 }
 
 func (i *InMemoryPersistenceProvider) MaxSequenceID(
 	actorID string,
 ) (uint64, error) {
-	events, found := i.events[actorID]
-	if !found {
-		return uint64(0), nil
+	i.Lock()
+	defer i.Unlock()
+
+	events := i.events[actorID]
+	if len(events) == 0 {
+		return 0, errors.New("not found")
+	}
+	return uint64(len(events) - 1), nil
+}
+
+func (i *InMemoryPersistenceProvider) DeleteEvents(
+	actorID string,
+	toSequenceID uint64,
+	mode DeletionMode,
+) error {
+	i.Lock()
+	defer i.Unlock()
+
+	events := i.events[actorID]
+	if len(events) == 0 {
+		return nil
+	}
+	if toSequenceID >= uint64(len(events)) {
+		toSequenceID = uint64(len(events) - 1)
+	}
+
+	deletedTo, deleted := i.deletedTo[actorID]
+	if deleted && toSequenceID <= deletedTo {
+		return nil
+	}
+	i.deletedTo[actorID] = toSequenceID
+
+	if mode == PhysicalDeletion {
+		for j := uint64(0); j <= toSequenceID; j++ {
+			events[j].event = nil
+		}
+	}
+	return nil
+}
+
+func (i *InMemoryPersistenceProvider) EventsByTag(
+	tag string,
+	offset gocql.UUID,
+	limit int,
+) ([]PersistentEvent, error) {
+	i.Lock()
+	defer i.Unlock()
+
+	entries := i.tags[tag]
+	start := sort.Search(len(entries), func(j int) bool {
+		return compareOffsets(entries[j].offset, offset) > 0
+	})
+
+	out := make([]PersistentEvent, 0)
+	for _, entry := range entries[start:] {
+		if len(out) >= limit {
+			break
+		}
+		event, err := i.getEvent(entry.actorID, entry.sequenceID)
+		if err != nil {
+			continue
+		}
+		out = append(out, event)
+	}
+	return out, nil
+}
+
+func (i *InMemoryPersistenceProvider) PersistenceIDs() ([]string, error) {
+	i.Lock()
+	defer i.Unlock()
+
+	ids := make([]string, 0, len(i.events))
+	for actorID, events := range i.events {
+		if len(events) > 0 {
+			ids = append(ids, actorID)
+		}
 	}
-	return uint64(len(events)), nil
+	sort.Strings(ids)
+	return ids, nil
 }
@@ -0,0 +1,75 @@
+package actors_test
+
+import (
+	"io/ioutil"
+	"os"
+
+	. "github.com/kphelps/actors/actors"
+	"github.com/kphelps/actors/persistencetck"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("PersistenceProvider", func() {
+	var dirs []string
+
+	AfterEach(func() {
+		for _, dir := range dirs {
+			os.RemoveAll(dir)
+		}
+		dirs = nil
+	})
+
+	persistencetck.DescribeProvider(
+		"InMemoryPersistenceProvider",
+		NewPersistenceProvider,
+	)
+
+	persistencetck.DescribeProvider(
+		"FilePersistenceProvider",
+		func() PersistenceProvider {
+			dir, err := ioutil.TempDir("", "file-journal")
+			Expect(err).NotTo(HaveOccurred())
+			dirs = append(dirs, dir)
+			provider := NewFilePersistenceProvider(FilePersistenceConfig{
+				Directory:   dir,
+				SegmentSize: 4096,
+			})
+			Expect(provider.Initialize()).To(Succeed())
+			return provider
+		},
+	)
+
+	persistencetck.DescribeProvider(
+		"CassandraPersistenceProvider",
+		GetPersistenceProvider,
+	)
+
+	Describe("CassandraPersistenceProvider heads", func() {
+		It("Resumes past a sequence ID claimed by a writer that died", func() {
+			provider := GetPersistenceProvider()
+			actorID := persistencetck.NewActorID()
+			persistencetck.PersistN(provider, actorID, 0, 2)
+			// The head moved past 2 but the event was never written.
+			err := cassandraSession.Query(
+				`UPDATE actor_heads SET next_sequence_id = 3 WHERE actor_id = ?`,
+				actorID,
+			).Exec()
+			Expect(err).NotTo(HaveOccurred())
+
+			max, err := provider.MaxSequenceID(actorID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(max).To(Equal(uint64(2)))
+			persistencetck.PersistN(provider, actorID, 3, 1)
+
+			events, err := provider.GetEvents(actorID, 0)
+			Expect(err).NotTo(HaveOccurred())
+			sequenceIDs := make([]uint64, 0, len(events))
+			for _, event := range events {
+				sequenceIDs = append(sequenceIDs, event.SequenceID)
+			}
+			Expect(sequenceIDs).To(Equal([]uint64{0, 1, 3}))
+		})
+	})
+})
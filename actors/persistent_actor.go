@@ -1,6 +1,10 @@
 package actors
 
-import "github.com/golang/protobuf/proto"
+import (
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+)
 
 type PersistentActor interface {
 	PersistenceID() string
@@ -12,6 +16,18 @@ type PersistentActor interface {
 type PersistentContext interface {
 	ActorContext
 	Persist(event proto.Message)
+	// LastSequenceID is false until the actor has persisted an event.
+	LastSequenceID() (uint64, bool)
+	DeleteEvents(toSequenceID uint64, mode DeletionMode)
+}
+
+type DeleteEventsSuccess struct {
+	ToSequenceID uint64
+}
+
+type DeleteEventsFailure struct {
+	ToSequenceID uint64
+	Err          error
 }
 
 type persistentContextImpl struct {
@@ -19,7 +35,9 @@ type persistentContextImpl struct {
 	id         string
 	sequenceID uint64
 	pp         PersistenceProvider
+	tagger     EventTagger
 	liveEvents []proto.Message
+	deleter    *eventDeleter
 }
 
 func newPersistentContext() persistentContextImpl {
@@ -30,29 +48,125 @@ func newPersistentContext() persistentContextImpl {
 }
 
 func (pci *persistentContextImpl) Persist(event proto.Message) {
+	_, isTagged := event.(*Tagged)
+	if pci.tagger != nil && !isTagged {
+		tags := pci.tagger.Tags(event)
+		if len(tags) > 0 {
+			event = &Tagged{Event: event, Tags: tags}
+		}
+	}
+
 	err := pci.pp.PersistEvent(pci.id, pci.sequenceID, event)
 	if err != nil {
 		panic(err)
 	}
 	pci.sequenceID++
+	event, _ = untagEvent(event)
 	pci.liveEvents = append(pci.liveEvents, event)
 }
 
-func (pci *persistentContextImpl) Events() ([]PersistentEvent, error) {
-	return pci.pp.GetEvents(pci.id, 0)
+func (pci *persistentContextImpl) LastSequenceID() (uint64, bool) {
+	if pci.sequenceID == 0 {
+		return 0, false
+	}
+	return pci.sequenceID - 1, true
+}
+
+// DeleteEvents runs in the background and reports the outcome to the actor
+// as a DeleteEventsSuccess or DeleteEventsFailure message.
+func (pci *persistentContextImpl) DeleteEvents(
+	toSequenceID uint64,
+	mode DeletionMode,
+) {
+	pci.deleter.delete(deleteEventsRequest{toSequenceID, mode})
+}
+
+type deleteEventsRequest struct {
+	toSequenceID uint64
+	mode         DeletionMode
+}
+
+// eventDeleter runs an actor's deletions one at a time on a single
+// goroutine, which exits once the queue is empty. Outcomes are dropped
+// after the actor stops.
+type eventDeleter struct {
+	sync.Mutex
+	pp      PersistenceProvider
+	id      string
+	self    ActorRef
+	queue   []deleteEventsRequest
+	running bool
+	stopped bool
+}
+
+func (ed *eventDeleter) delete(request deleteEventsRequest) {
+	ed.Lock()
+	defer ed.Unlock()
+	ed.queue = append(ed.queue, request)
+	if !ed.running {
+		ed.running = true
+		go ed.run()
+	}
+}
+
+func (ed *eventDeleter) run() {
+	for {
+		ed.Lock()
+		if len(ed.queue) == 0 {
+			ed.running = false
+			ed.Unlock()
+			return
+		}
+		request := ed.queue[0]
+		ed.queue = ed.queue[1:]
+		ed.Unlock()
+
+		err := ed.pp.DeleteEvents(ed.id, request.toSequenceID, request.mode)
+		var result interface{} = DeleteEventsSuccess{
+			ToSequenceID: request.toSequenceID,
+		}
+		if err != nil {
+			result = DeleteEventsFailure{
+				ToSequenceID: request.toSequenceID,
+				Err:          err,
+			}
+		}
+		ed.Lock()
+		stopped := ed.stopped
+		ed.Unlock()
+		if !stopped {
+			ed.self.Send(result)
+		}
+	}
+}
+
+func (ed *eventDeleter) stop() {
+	ed.Lock()
+	defer ed.Unlock()
+	ed.stopped = true
 }
 
 type persistentActorCell struct {
 	inner             PersistentActor
 	persistentContext persistentContextImpl
+	recovery          Recovery
+	failed            bool
 }
 
 func NewPersistentActor(
 	actor PersistentActor,
+) Actor {
+	return NewPersistentActorWithRecovery(actor, Recovery{})
+}
+
+func NewPersistentActorWithRecovery(
+	actor PersistentActor,
+	recovery Recovery,
 ) Actor {
 	return &persistentActorCell{
 		inner:             actor,
 		persistentContext: newPersistentContext(),
+		recovery:          recovery,
 	}
 }
 
@@ -63,28 +177,66 @@ func SpawnPersistentActor(
 	return SpawnActor(cell)
 }
 
+func SpawnPersistentActorWithRecovery(
+	actor PersistentActor,
+	recovery Recovery,
+) ActorRef {
+	cell := NewPersistentActorWithRecovery(actor, recovery)
+	return SpawnActor(cell)
+}
+
 func (pac *persistentActorCell) OnStart(
 	context ActorContext,
 ) {
 	pac.persistentContext.ActorContext = context
 	pac.persistentContext.id = pac.inner.PersistenceID()
-	events, err := pac.persistentContext.Events()
-	if err == nil {
-		for _, event := range events {
-			pac.inner.HandleRecover(event.Event)
-			pac.persistentContext.sequenceID = event.SequenceID + 1
+	pac.persistentContext.deleter = &eventDeleter{
+		pp:   pac.persistentContext.pp,
+		id:   pac.persistentContext.id,
+		self: context.Self(),
+	}
+	pac.persistentContext.tagger, _ = pac.inner.(EventTagger)
+
+	events, nextSequenceID, err := readJournal(
+		pac.persistentContext.pp,
+		pac.persistentContext.id,
+		pac.recovery,
+	)
+	if err != nil {
+		failedHandler, ok := pac.inner.(RecoveryFailedHandler)
+		if !ok {
+			panic(err)
 		}
-	} else if err.Error() == "not found" {
+		failedHandler.RecoveryFailed(err)
+		pac.failed = true
+		// The actor can't wait on its own stop from OnStart.
+		self := context.Self()
+		go self.Stop()
 		return
-	} else {
-		panic(err)
+	}
+	for _, event := range events {
+		pac.inner.HandleRecover(event.Event)
+	}
+	pac.persistentContext.sequenceID = nextSequenceID
+	deliverer, ok := pac.inner.(atLeastOnceDeliverer)
+	if ok {
+		deliverer.atLeastOnceDelivery().start(context.Self())
+	}
+	completedHandler, ok := pac.inner.(RecoveryCompletedHandler)
+	if ok {
+		completedHandler.RecoveryCompleted(&pac.persistentContext)
 	}
 }
 
 func (pac *persistentActorCell) Receive(
 	context ActorContext,
 ) {
+	if pac.failed {
+		return
+	}
 	switch context.Message().(type) {
+	case atLeastOnceRedeliver:
+		pac.inner.(atLeastOnceDeliverer).atLeastOnceDelivery().redeliver()
 	default:
 		pac.inner.Receive(&pac.persistentContext)
 		pac.processEvents()
@@ -101,4 +253,5 @@ func (pac *persistentActorCell) processEvents() {
 func (pac *persistentActorCell) OnStop(
 	context ActorContext,
 ) {
+	pac.persistentContext.deleter.stop()
 }
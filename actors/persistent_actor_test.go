@@ -0,0 +1,151 @@
+package actors_test
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	. "github.com/kphelps/actors/actors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type testDeleteEvents struct {
+	toSequenceID uint64
+	mode         DeletionMode
+}
+
+type testGetState struct{}
+
+type testGetLastSequenceID struct{}
+
+type testLastSequenceID struct {
+	sequenceID uint64
+	persisted  bool
+}
+
+type testPersistentActor struct {
+	id     string
+	state  []string
+	output chan interface{}
+}
+
+func (tpa *testPersistentActor) PersistenceID() string {
+	return tpa.id
+}
+
+func (tpa *testPersistentActor) Receive(context PersistentContext) {
+	switch msg := context.Message().(type) {
+	case string:
+		context.Persist(&wrappers.StringValue{Value: msg})
+	case testDeleteEvents:
+		context.DeleteEvents(msg.toSequenceID, msg.mode)
+	case testGetState:
+		context.Reply(append([]string{}, tpa.state...))
+	case testGetLastSequenceID:
+		sequenceID, persisted := context.LastSequenceID()
+		context.Reply(testLastSequenceID{sequenceID, persisted})
+	default:
+		tpa.output <- msg
+	}
+}
+
+func (tpa *testPersistentActor) HandleEvent(event proto.Message) {
+	tpa.state = append(tpa.state, event.(*wrappers.StringValue).Value)
+}
+
+func (tpa *testPersistentActor) HandleRecover(event proto.Message) {
+	tpa.HandleEvent(event)
+}
+
+var _ = Describe("PersistentActor", func() {
+	var id string
+	var output chan interface{}
+	var ref ActorRef
+
+	spawn := func() ActorRef {
+		return SpawnPersistentActor(&testPersistentActor{
+			id:     id,
+			output: output,
+		})
+	}
+
+	BeforeEach(func() {
+		id = fmt.Sprintf("persistent-actor-%d", time.Now().UnixNano())
+		output = make(chan interface{}, 10)
+		ref = spawn()
+	})
+
+	AfterEach(func() {
+		ref.Stop()
+	})
+
+	It("Recovers persisted events", func() {
+		ref.Send("a")
+		ref.Send("b")
+		Expect(ref.Ask(testGetState{})).To(Equal([]string{"a", "b"}))
+		ref.Stop()
+
+		ref = spawn()
+		Expect(ref.Ask(testGetState{})).To(Equal([]string{"a", "b"}))
+	})
+
+	It("Tells the first event apart from none", func() {
+		Expect(ref.Ask(testGetLastSequenceID{})).To(Equal(testLastSequenceID{}))
+		ref.Send("a")
+		Expect(ref.Ask(testGetLastSequenceID{})).To(Equal(
+			testLastSequenceID{0, true},
+		))
+	})
+
+	Describe("DeleteEvents", func() {
+		BeforeEach(func() {
+			ref.Send("a")
+			ref.Send("b")
+			ref.Send("c")
+		})
+
+		It("Reports success", func() {
+			ref.Send(testDeleteEvents{1, LogicalDeletion})
+			Eventually(output).Should(Receive(Equal(
+				DeleteEventsSuccess{ToSequenceID: 1},
+			)))
+		})
+
+		It("Reports each deletion in order", func() {
+			ref.Send(testDeleteEvents{0, LogicalDeletion})
+			ref.Send(testDeleteEvents{1, PhysicalDeletion})
+			Eventually(output).Should(Receive(Equal(
+				DeleteEventsSuccess{ToSequenceID: 0},
+			)))
+			Eventually(output).Should(Receive(Equal(
+				DeleteEventsSuccess{ToSequenceID: 1},
+			)))
+		})
+
+		It("Does not recover deleted events", func() {
+			ref.Send(testDeleteEvents{1, PhysicalDeletion})
+			Eventually(output).Should(Receive())
+			ref.Stop()
+
+			ref = spawn()
+			Expect(ref.Ask(testGetState{})).To(Equal([]string{"c"}))
+		})
+
+		It("Continues the sequence after deleting everything", func() {
+			ref.Send(testDeleteEvents{2, PhysicalDeletion})
+			Eventually(output).Should(Receive())
+			ref.Stop()
+
+			ref = spawn()
+			ref.Send("d")
+			Expect(ref.Ask(testGetState{})).To(Equal([]string{"d"}))
+			events, err := GetPersistenceProvider().GetEvents(id, 0)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(events).To(HaveLen(1))
+			Expect(events[0].SequenceID).To(Equal(uint64(3)))
+		})
+	})
+})
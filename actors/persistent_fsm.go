@@ -0,0 +1,200 @@
+package actors
+
+import (
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+type FSMState string
+
+// StateTimeout is handled by a state that has been current for longer than
+// the timeout it was declared with.
+type StateTimeout struct {
+	State      FSMState
+	generation int
+}
+
+// FSMTransition is returned by state handlers: the events to persist, and
+// the state to be in once they are. The zero FSMTransition is Stay().
+type FSMTransition struct {
+	events []proto.Message
+	state  FSMState
+	move   bool
+	reply  interface{}
+}
+
+// Goto moves to state after persisting events. Going to any state, even the
+// current one, restarts its timeout.
+func Goto(state FSMState, events ...proto.Message) FSMTransition {
+	return FSMTransition{events: events, state: state, move: true}
+}
+
+// Stay persists events without changing state or restarting its timeout.
+func Stay(events ...proto.Message) FSMTransition {
+	return FSMTransition{events: events}
+}
+
+// Replying sends message to the sender once the events are persisted.
+func (t FSMTransition) Replying(message interface{}) FSMTransition {
+	t.reply = message
+	return t
+}
+
+type FSMHandler[D any] func(context PersistentContext, data D) FSMTransition
+
+type fsmStateChanged struct {
+	State string `protobuf:"bytes,1,opt,name=state" json:"state,omitempty"`
+}
+
+func (m *fsmStateChanged) Reset()         { *m = fsmStateChanged{} }
+func (m *fsmStateChanged) String() string { return proto.CompactTextString(m) }
+func (*fsmStateChanged) ProtoMessage()    {}
+
+func init() {
+	proto.RegisterType((*fsmStateChanged)(nil), "actors.FSMStateChanged")
+}
+
+// PersistentFSM is a PersistentActor made of states, each with a handler
+// for the commands it receives in that state. Handlers return the events to
+// persist and the next state. Events are folded into the FSM's data with
+// apply, both as they are persisted and on recovery, and state changes are
+// persisted alongside them so the state is recovered too.
+//
+// Each spawned actor needs its own PersistentFSM.
+type PersistentFSM[D any] struct {
+	persistenceID string
+	state         FSMState
+	data          D
+	apply         func(event proto.Message, data D) D
+	handlers      map[FSMState]FSMHandler[D]
+	timeouts      map[FSMState]time.Duration
+	onTransition  []func(from FSMState, to FSMState, data D)
+	timer         Cancellable
+	generation    int
+}
+
+func NewPersistentFSM[D any](
+	persistenceID string,
+	initial FSMState,
+	data D,
+	apply func(event proto.Message, data D) D,
+) *PersistentFSM[D] {
+	return &PersistentFSM[D]{
+		persistenceID: persistenceID,
+		state:         initial,
+		data:          data,
+		apply:         apply,
+		handlers:      make(map[FSMState]FSMHandler[D]),
+		timeouts:      make(map[FSMState]time.Duration),
+	}
+}
+
+// When handles commands in state. Commands arriving in a state without a
+// handler are dropped.
+func (fsm *PersistentFSM[D]) When(
+	state FSMState,
+	handler FSMHandler[D],
+) *PersistentFSM[D] {
+	fsm.handlers[state] = handler
+	return fsm
+}
+
+// WhenWithTimeout also sends the handler a StateTimeout once the FSM has
+// been in state for timeout.
+func (fsm *PersistentFSM[D]) WhenWithTimeout(
+	state FSMState,
+	timeout time.Duration,
+	handler FSMHandler[D],
+) *PersistentFSM[D] {
+	fsm.timeouts[state] = timeout
+	return fsm.When(state, handler)
+}
+
+// OnTransition is called after every live state change, not on recovery.
+func (fsm *PersistentFSM[D]) OnTransition(
+	callback func(from FSMState, to FSMState, data D),
+) *PersistentFSM[D] {
+	fsm.onTransition = append(fsm.onTransition, callback)
+	return fsm
+}
+
+func SpawnPersistentFSM[D any](fsm *PersistentFSM[D]) ActorRef {
+	return SpawnPersistentActor(fsm)
+}
+
+func (fsm *PersistentFSM[D]) PersistenceID() string {
+	return fsm.persistenceID
+}
+
+func (fsm *PersistentFSM[D]) Receive(context PersistentContext) {
+	timeout, isTimeout := context.Message().(StateTimeout)
+	if isTimeout && timeout.generation != fsm.generation {
+		return
+	}
+	handler, found := fsm.handlers[fsm.state]
+	if !found {
+		return
+	}
+	transition := handler(context, fsm.data)
+	for _, event := range transition.events {
+		context.Persist(event)
+	}
+	if transition.move {
+		if transition.state != fsm.state {
+			context.Persist(&fsmStateChanged{State: string(transition.state)})
+		}
+		fsm.startTimer(context.Self(), transition.state)
+	}
+	if transition.reply != nil {
+		context.Reply(transition.reply)
+	}
+}
+
+func (fsm *PersistentFSM[D]) HandleEvent(event proto.Message) {
+	changed, isStateChange := event.(*fsmStateChanged)
+	if !isStateChange {
+		fsm.applyEvent(event)
+		return
+	}
+	from := fsm.state
+	fsm.state = FSMState(changed.State)
+	for _, callback := range fsm.onTransition {
+		callback(from, fsm.state, fsm.data)
+	}
+}
+
+func (fsm *PersistentFSM[D]) HandleRecover(event proto.Message) {
+	changed, isStateChange := event.(*fsmStateChanged)
+	if isStateChange {
+		fsm.state = FSMState(changed.State)
+	} else {
+		fsm.applyEvent(event)
+	}
+}
+
+func (fsm *PersistentFSM[D]) applyEvent(event proto.Message) {
+	if fsm.apply != nil {
+		fsm.data = fsm.apply(event, fsm.data)
+	}
+}
+
+func (fsm *PersistentFSM[D]) startTimer(self ActorRef, state FSMState) {
+	if fsm.timer != nil {
+		fsm.timer.Cancel()
+		fsm.timer = nil
+	}
+	fsm.generation++
+	timeout, found := fsm.timeouts[state]
+	if found {
+		fsm.timer = ScheduleOnce(timeout, self, StateTimeout{
+			State:      state,
+			generation: fsm.generation,
+		})
+	}
+}
+
+// RecoveryCompleted starts the timeout of the recovered state.
+func (fsm *PersistentFSM[D]) RecoveryCompleted(context PersistentContext) {
+	fsm.startTimer(context.Self(), fsm.state)
+}
@@ -0,0 +1,162 @@
+package actors_test
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	. "github.com/kphelps/actors/actors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const (
+	orderShopping  FSMState = "shopping"
+	orderPaid      FSMState = "paid"
+	orderCancelled FSMState = "cancelled"
+)
+
+type orderAddItem struct {
+	item string
+}
+
+type orderPay struct{}
+
+type orderGetState struct{}
+
+type orderIgnored struct{}
+
+type orderState struct {
+	state FSMState
+	items []string
+}
+
+var _ = Describe("PersistentFSM", func() {
+	var id string
+	var transitions chan [2]FSMState
+	var ref ActorRef
+
+	spawn := func() ActorRef {
+		getState := func(state FSMState, items []string) FSMTransition {
+			return Stay().Replying(orderState{state, items})
+		}
+		fsm := NewPersistentFSM(
+			id,
+			orderShopping,
+			[]string{},
+			func(event proto.Message, items []string) []string {
+				return append(items, event.(*wrappers.StringValue).Value)
+			},
+		)
+		fsm.WhenWithTimeout(
+			orderShopping,
+			100*time.Millisecond,
+			func(context PersistentContext, items []string) FSMTransition {
+				switch message := context.Message().(type) {
+				case orderAddItem:
+					return Goto(
+						orderShopping,
+						&wrappers.StringValue{Value: message.item},
+					)
+				case orderPay:
+					if len(items) == 0 {
+						return Stay().Replying(false)
+					}
+					return Goto(orderPaid).Replying(true)
+				case StateTimeout:
+					return Goto(orderCancelled)
+				case orderGetState:
+					return getState(orderShopping, items)
+				case orderIgnored:
+					return FSMTransition{}
+				}
+				return Stay()
+			},
+		).When(
+			orderPaid,
+			func(context PersistentContext, items []string) FSMTransition {
+				return getState(orderPaid, items)
+			},
+		).When(
+			orderCancelled,
+			func(context PersistentContext, items []string) FSMTransition {
+				return getState(orderCancelled, items)
+			},
+		).OnTransition(func(from FSMState, to FSMState, items []string) {
+			transitions <- [2]FSMState{from, to}
+		})
+		return SpawnPersistentFSM(fsm)
+	}
+
+	BeforeEach(func() {
+		id = fmt.Sprintf("persistent-fsm-%d", time.Now().UnixNano())
+		transitions = make(chan [2]FSMState, 10)
+		ref = spawn()
+	})
+
+	AfterEach(func() {
+		ref.Stop()
+	})
+
+	It("Moves between states", func() {
+		Expect(ref.Ask(orderPay{})).To(BeFalse())
+		ref.Send(orderAddItem{"book"})
+		Expect(ref.Ask(orderPay{})).To(BeTrue())
+		Expect(ref.Ask(orderGetState{})).To(Equal(orderState{orderPaid, []string{"book"}}))
+		Expect(transitions).To(Receive(Equal([2]FSMState{orderShopping, orderPaid})))
+	})
+
+	It("Stays in its state on a zero transition", func() {
+		ref.Send(orderIgnored{})
+		Expect(ref.Ask(orderGetState{})).To(Equal(orderState{orderShopping, []string{}}))
+		Expect(transitions).NotTo(Receive())
+	})
+
+	It("Recovers its state and data", func() {
+		ref.Send(orderAddItem{"book"})
+		ref.Send(orderAddItem{"pen"})
+		Expect(ref.Ask(orderPay{})).To(BeTrue())
+		Eventually(transitions).Should(Receive())
+		ref.Stop()
+
+		ref = spawn()
+		Expect(ref.Ask(orderGetState{})).To(Equal(
+			orderState{orderPaid, []string{"book", "pen"}},
+		))
+		Consistently(transitions).ShouldNot(Receive())
+	})
+
+	It("Times out of a state", func() {
+		Eventually(transitions).Should(Receive(Equal(
+			[2]FSMState{orderShopping, orderCancelled},
+		)))
+		Expect(ref.Ask(orderGetState{})).To(Equal(
+			orderState{orderCancelled, []string{}},
+		))
+	})
+
+	It("Restarts the timeout when going to the same state", func() {
+		for i := 0; i < 4; i++ {
+			time.Sleep(50 * time.Millisecond)
+			ref.Send(orderAddItem{"book"})
+		}
+		Expect(transitions).NotTo(Receive())
+		Eventually(transitions).Should(Receive())
+	})
+
+	It("Starts the timeout of the recovered state", func() {
+		ref.Send(orderAddItem{"book"})
+		ref.Ask(orderGetState{})
+		ref.Stop()
+
+		ref = spawn()
+		Eventually(transitions).Should(Receive(Equal(
+			[2]FSMState{orderShopping, orderCancelled},
+		)))
+		Expect(ref.Ask(orderGetState{})).To(Equal(
+			orderState{orderCancelled, []string{"book"}},
+		))
+	})
+})
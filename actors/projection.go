@@ -0,0 +1,558 @@
+package actors
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/kphelps/streams/streams"
+)
+
+type ProjectionDeliveryMode int
+
+const (
+	// The offset is committed after the handler runs, every CommitEvery
+	// events or CommitInterval, so events may be handled again after a
+	// restart. This is the default, as it works with any OffsetStore.
+	AtLeastOnce ProjectionDeliveryMode = iota
+	// The handler's effect and the new offset are committed together by a
+	// TransactionalOffsetStore, so each event takes effect exactly once.
+	ExactlyOnce
+	// The offset is committed before the handler runs and failures are
+	// dropped.
+	AtMostOnce
+)
+
+// ProjectionEffect is whatever a handler hands back for its offset store to
+// commit alongside the offset, e.g. a BatchableQuery for Cassandra. Only
+// exactly-once projections use it.
+type ProjectionEffect interface{}
+
+type ProjectionHandler interface {
+	Handle(events []PersistentEvent) (ProjectionEffect, error)
+}
+
+// Handlers implementing ResettableProjectionHandler can drop their read model
+// so that a rebuild replays into a clean slate.
+type ResettableProjectionHandler interface {
+	ProjectionHandler
+	Reset() error
+}
+
+type ProjectionHandlerFunc func(events []PersistentEvent) (ProjectionEffect, error)
+
+func (f ProjectionHandlerFunc) Handle(
+	events []PersistentEvent,
+) (ProjectionEffect, error) {
+	return f(events)
+}
+
+// Offset is where a projection's source resumes. Projections over a single
+// persistence ID use Sequence, the next sequence ID to read. Projections over
+// a tag use TimeUUID, the offset of the last event handled.
+type Offset struct {
+	Sequence uint64
+	TimeUUID gocql.UUID
+}
+
+func SequenceOffset(sequenceID uint64) Offset {
+	return Offset{Sequence: sequenceID}
+}
+
+func TimeUUIDOffset(offset gocql.UUID) Offset {
+	return Offset{TimeUUID: offset}
+}
+
+type OffsetStore interface {
+	GetOffset(name string) (Offset, error)
+	SaveOffset(name string, offset Offset) error
+}
+
+type TransactionalOffsetStore interface {
+	OffsetStore
+	CommitWithOffset(name string, offset Offset, effect ProjectionEffect) error
+}
+
+type ProjectionConfig struct {
+	Name string
+	// Used to report lag against the journal; lag is unknown if empty.
+	PersistenceID string
+	Source        func(offset Offset) streams.Source
+	Handler       ProjectionHandler
+	OffsetStore   OffsetStore
+	Mode          ProjectionDeliveryMode
+	// Set for sources ordered by time-UUID, such as NewTagEventSource, so
+	// that offsets record the TimeUUID of events rather than their sequence
+	// IDs.
+	TimeUUIDOffsets bool
+
+	// Events are handed to the handler in groups of up to GroupSize. A
+	// partial group is flushed after GroupInterval.
+	GroupSize     int
+	GroupInterval time.Duration
+
+	CommitEvery    int
+	CommitInterval time.Duration
+
+	FailurePolicy ProjectionFailurePolicy
+}
+
+type ProjectionState int
+
+const (
+	ProjectionRunning ProjectionState = iota
+	ProjectionPaused
+	// Stopped by its failure policy; ResumeProjection restarts it.
+	ProjectionStopped
+)
+
+type ProjectionStatus struct {
+	Name          string
+	PersistenceID string
+	State         ProjectionState
+	Offset        Offset
+	// Events in the journal past Offset.
+	Lag       uint64
+	LastError error
+}
+
+type PauseProjection struct{}
+
+type ResumeProjection struct{}
+
+type ResetProjectionOffset struct {
+	Offset Offset
+}
+
+// RebuildProjection resets the handler and replays from the beginning.
+type RebuildProjection struct{}
+
+type GetProjectionStatus struct{}
+
+type projectionTick struct{}
+
+type projectionRetry struct {
+	generation int
+}
+
+type projectionStartRetry struct {
+	generation int
+}
+
+// Events are tagged with the stream that produced them so events still in
+// the mailbox from a closed stream can be dropped.
+type projectionEnvelope struct {
+	generation int
+	event      PersistentEvent
+}
+
+type projection struct {
+	config        ProjectionConfig
+	transactional TransactionalOffsetStore
+	stream        streams.RunnableStream
+	generation    int
+	state         ProjectionState
+	lastError     error
+	pending       []PersistentEvent
+	inFlight      []PersistentEvent
+	attempts      int
+	skipping      bool
+	recorded      bool
+	offset        Offset
+	uncommitted   int
+	lastCommit    time.Time
+	tickScheduled bool
+	// Set once the offset has been read and the stream can be opened.
+	started bool
+}
+
+func NewProjection(config ProjectionConfig) Actor {
+	if config.GroupSize <= 0 {
+		config.GroupSize = 1
+	}
+	if config.GroupInterval <= 0 {
+		config.GroupInterval = 100 * time.Millisecond
+	}
+	if config.CommitEvery <= 0 {
+		config.CommitEvery = 100
+	}
+	if config.CommitInterval <= 0 {
+		config.CommitInterval = time.Second
+	}
+	if config.FailurePolicy.MinBackoff <= 0 {
+		config.FailurePolicy.MinBackoff = 100 * time.Millisecond
+	}
+	if config.FailurePolicy.MaxBackoff < config.FailurePolicy.MinBackoff {
+		config.FailurePolicy.MaxBackoff = config.FailurePolicy.MinBackoff
+	}
+	return &projection{
+		config: config,
+	}
+}
+
+func (p *projection) OnStart(context ActorContext) {
+	p.start(context)
+}
+
+// start reads the offset and opens the stream. A projection that cannot
+// start is stopped with the error in its status; failing to read the offset
+// is retried under the failure policy first.
+func (p *projection) start(context ActorContext) {
+	if p.config.Mode == ExactlyOnce && p.transactional == nil {
+		store, ok := p.config.OffsetStore.(TransactionalOffsetStore)
+		if !ok {
+			p.lastError = errors.New(
+				"exactly-once projections need a TransactionalOffsetStore",
+			)
+			p.state = ProjectionStopped
+			return
+		}
+		p.transactional = store
+	}
+
+	offset, err := p.config.OffsetStore.GetOffset(p.config.Name)
+	if err != nil {
+		p.lastError = err
+		p.startFailed(context)
+		return
+	}
+	p.started = true
+	p.attempts = 0
+	p.offset = offset
+	p.lastCommit = time.Now()
+	p.openStream(context)
+}
+
+func (p *projection) startFailed(context ActorContext) {
+	policy := p.config.FailurePolicy
+	p.attempts++
+	if policy.MaxRetries == 0 || p.attempts <= policy.MaxRetries {
+		ScheduleOnce(
+			policy.backoff(p.attempts),
+			context.Self(),
+			projectionStartRetry{p.generation},
+		)
+		return
+	}
+	if policy.OnFailure == EscalateFailure && policy.Supervisor != nil {
+		policy.Supervisor.Send(ProjectionFailed{
+			Name: p.config.Name,
+			Err:  p.lastError,
+		})
+	}
+	p.attempts = 0
+	p.state = ProjectionStopped
+}
+
+// resume opens the stream again, or starts over if it never started.
+func (p *projection) resume(context ActorContext) {
+	if p.started {
+		p.openStream(context)
+	} else {
+		p.start(context)
+	}
+}
+
+func (p *projection) OnStop(context ActorContext) {
+	p.closeStream()
+	if p.uncommitted > 0 {
+		p.commit()
+	}
+}
+
+func (p *projection) openStream(context ActorContext) {
+	generation := p.generation
+	source := p.config.Source(p.offset)
+	sink := streams.NewSink(func(event PersistentEvent) {
+		context.Self().Send(projectionEnvelope{generation, event})
+	})
+	p.stream = source.AttachSink(sink)
+	p.stream.Open()
+}
+
+// closeStream also abandons buffered and in-flight events; they are read
+// again from the offset when the stream is reopened.
+func (p *projection) closeStream() {
+	if p.stream != nil {
+		p.stream.Close()
+		p.stream = nil
+	}
+	p.generation++
+	p.pending = nil
+	p.inFlight = nil
+}
+
+func (p *projection) Receive(context ActorContext) {
+	switch message := context.Message().(type) {
+	case projectionEnvelope:
+		if message.generation == p.generation {
+			p.receiveEvent(context, message.event)
+		}
+	case PersistentEvent:
+		p.receiveEvent(context, message)
+	case projectionRetry:
+		if message.generation == p.generation && p.inFlight != nil {
+			p.process(context)
+		}
+	case projectionStartRetry:
+		if message.generation == p.generation && !p.started {
+			p.start(context)
+		}
+	case PauseProjection:
+		if p.state == ProjectionRunning {
+			p.closeStream()
+			if p.uncommitted > 0 {
+				p.commit()
+			}
+			p.state = ProjectionPaused
+		}
+		p.replyStatus(context)
+	case ResumeProjection:
+		if p.state != ProjectionRunning {
+			p.state = ProjectionRunning
+			p.resume(context)
+		}
+		p.replyStatus(context)
+	case ResetProjectionOffset:
+		p.resetOffset(context, message.Offset)
+		p.replyStatus(context)
+	case RebuildProjection:
+		p.rebuild(context)
+		p.replyStatus(context)
+	case GetProjectionStatus:
+		p.replyStatus(context)
+	case projectionTick:
+		p.tickScheduled = false
+		if p.inFlight == nil && len(p.pending) > 0 {
+			p.startGroup(context)
+		}
+		if p.uncommitted > 0 &&
+			time.Since(p.lastCommit) >= p.config.CommitInterval {
+			p.commit()
+		}
+		if len(p.pending) > 0 || p.uncommitted > 0 {
+			p.scheduleTick(context)
+		}
+	}
+}
+
+func (p *projection) receiveEvent(context ActorContext, event PersistentEvent) {
+	p.pending = append(p.pending, event)
+	if p.inFlight == nil && len(p.pending) >= p.config.GroupSize {
+		p.startGroup(context)
+	} else {
+		p.scheduleTick(context)
+	}
+}
+
+func (p *projection) resetOffset(context ActorContext, offset Offset) {
+	err := p.config.OffsetStore.SaveOffset(p.config.Name, offset)
+	if err != nil {
+		p.lastError = err
+		return
+	}
+	p.closeStream()
+	p.offset = offset
+	p.uncommitted = 0
+	if p.state == ProjectionRunning {
+		p.resume(context)
+	}
+}
+
+func (p *projection) rebuild(context ActorContext) {
+	handler, ok := p.config.Handler.(ResettableProjectionHandler)
+	if !ok {
+		p.lastError = errors.New("projection handler cannot be reset")
+		return
+	}
+	p.closeStream()
+	err := handler.Reset()
+	if err != nil {
+		p.lastError = err
+		if p.state == ProjectionRunning {
+			p.resume(context)
+		}
+		return
+	}
+	p.resetOffset(context, Offset{})
+}
+
+func (p *projection) replyStatus(context ActorContext) {
+	if context.Sender() != nil {
+		context.Reply(p.status())
+	}
+}
+
+func (p *projection) status() ProjectionStatus {
+	status := ProjectionStatus{
+		Name:          p.config.Name,
+		PersistenceID: p.config.PersistenceID,
+		State:         p.state,
+		Offset:        p.offset,
+		LastError:     p.lastError,
+	}
+	if p.config.PersistenceID != "" && pp != nil {
+		max, err := pp.MaxSequenceID(p.config.PersistenceID)
+		if err == nil && max+1 > p.offset.Sequence {
+			status.Lag = max + 1 - p.offset.Sequence
+		}
+	}
+	return status
+}
+
+func (p *projection) startGroup(context ActorContext) {
+	size := p.config.GroupSize
+	if size > len(p.pending) {
+		size = len(p.pending)
+	}
+	p.inFlight = p.pending[:size]
+	p.pending = p.pending[size:]
+	p.attempts = 0
+	p.skipping = false
+	p.recorded = false
+	p.process(context)
+}
+
+func (p *projection) process(context ActorContext) {
+	var err error
+	if p.skipping {
+		err = p.skip()
+	} else {
+		err = p.apply()
+	}
+	if err != nil {
+		p.lastError = err
+		p.failed(context)
+		return
+	}
+
+	group := p.inFlight
+	p.inFlight = nil
+	p.offset = p.nextOffset(group)
+	if p.config.Mode == AtLeastOnce {
+		p.uncommitted += len(group)
+		if p.uncommitted >= p.config.CommitEvery {
+			p.commit()
+		}
+	}
+
+	if len(p.pending) >= p.config.GroupSize {
+		p.startGroup(context)
+	} else if len(p.pending) > 0 || p.uncommitted > 0 {
+		p.scheduleTick(context)
+	}
+}
+
+func (p *projection) apply() error {
+	group := p.inFlight
+	next := p.nextOffset(group)
+
+	switch p.config.Mode {
+	case ExactlyOnce:
+		effect, err := p.config.Handler.Handle(group)
+		if err != nil {
+			return err
+		}
+		return p.transactional.CommitWithOffset(p.config.Name, next, effect)
+
+	case AtLeastOnce:
+		_, err := p.config.Handler.Handle(group)
+		return err
+
+	case AtMostOnce:
+		err := p.config.OffsetStore.SaveOffset(p.config.Name, next)
+		if err != nil {
+			return err
+		}
+		_, err = p.config.Handler.Handle(group)
+		if err != nil {
+			p.lastError = err
+		}
+	}
+	return nil
+}
+
+func (p *projection) skip() error {
+	store := p.config.FailurePolicy.FailedEvents
+	if store != nil && !p.recorded {
+		err := store.RecordFailedEvents(p.config.Name, p.inFlight, p.lastError)
+		if err != nil {
+			return err
+		}
+		p.recorded = true
+	}
+	if p.config.Mode == ExactlyOnce {
+		next := p.nextOffset(p.inFlight)
+		return p.transactional.CommitWithOffset(p.config.Name, next, nil)
+	}
+	return nil
+}
+
+func (p *projection) nextOffset(group []PersistentEvent) Offset {
+	last := group[len(group)-1]
+	if p.config.TimeUUIDOffsets {
+		return TimeUUIDOffset(last.Offset)
+	}
+	return SequenceOffset(last.SequenceID + 1)
+}
+
+func (p *projection) failed(context ActorContext) {
+	policy := p.config.FailurePolicy
+	p.attempts++
+	if p.skipping || policy.MaxRetries == 0 || p.attempts <= policy.MaxRetries {
+		p.scheduleRetry(context, policy.backoff(p.attempts))
+		return
+	}
+
+	switch policy.OnFailure {
+	case SkipFailedEvents:
+		p.skipping = true
+		p.process(context)
+	case StopOnFailure:
+		p.stop()
+	case EscalateFailure:
+		if policy.Supervisor != nil {
+			policy.Supervisor.Send(ProjectionFailed{
+				Name:   p.config.Name,
+				Events: p.inFlight,
+				Err:    p.lastError,
+			})
+		}
+		p.stop()
+	}
+}
+
+func (p *projection) stop() {
+	p.closeStream()
+	if p.uncommitted > 0 {
+		p.commit()
+	}
+	p.state = ProjectionStopped
+}
+
+func (p *projection) commit() {
+	err := p.config.OffsetStore.SaveOffset(p.config.Name, p.offset)
+	if err != nil {
+		// Left uncommitted so the next tick tries again.
+		p.lastError = err
+		return
+	}
+	p.uncommitted = 0
+	p.lastCommit = time.Now()
+}
+
+func (p *projection) scheduleRetry(context ActorContext, delay time.Duration) {
+	ScheduleOnce(delay, context.Self(), projectionRetry{p.generation})
+}
+
+func (p *projection) scheduleTick(context ActorContext) {
+	if p.tickScheduled {
+		return
+	}
+	p.tickScheduled = true
+	interval := p.config.CommitInterval
+	if len(p.pending) > 0 {
+		interval = p.config.GroupInterval
+	}
+	ScheduleOnce(interval, context.Self(), projectionTick{})
+}
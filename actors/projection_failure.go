@@ -0,0 +1,156 @@
+package actors
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/scylladb/gocqlx"
+	"github.com/scylladb/gocqlx/qb"
+)
+
+type ProjectionFailureAction int
+
+const (
+	// Skip the failing events, recording them in FailedEvents if set.
+	SkipFailedEvents ProjectionFailureAction = iota
+	// Stop the projection until it is resumed.
+	StopOnFailure
+	// Stop the projection and report the failure to the Supervisor.
+	EscalateFailure
+)
+
+type ProjectionFailurePolicy struct {
+	// Zero retries forever and a negative value never retries; otherwise
+	// OnFailure applies once MaxRetries retries have failed.
+	MaxRetries int
+	// Defaults to 100ms.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// Each backoff is stretched by a random fraction of up to Jitter.
+	Jitter       float64
+	OnFailure    ProjectionFailureAction
+	FailedEvents FailedEventStore
+	// Receives ProjectionFailed when failures are escalated. Without a
+	// supervisor the projection just stops, as with StopOnFailure.
+	Supervisor ActorRef
+}
+
+type ProjectionFailed struct {
+	Name   string
+	Events []PersistentEvent
+	Err    error
+}
+
+func (fp ProjectionFailurePolicy) backoff(attempt int) time.Duration {
+	backoff := fp.MinBackoff
+	for i := 1; i < attempt && backoff < fp.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > fp.MaxBackoff {
+		backoff = fp.MaxBackoff
+	}
+	if fp.Jitter > 0 {
+		backoff += time.Duration(rand.Float64() * fp.Jitter * float64(backoff))
+	}
+	return backoff
+}
+
+type FailedEventStore interface {
+	RecordFailedEvents(projection string, events []PersistentEvent, err error) error
+}
+
+type FailedEvent struct {
+	ActorID    string
+	SequenceID uint64
+	Error      string
+	FailedAt   time.Time
+}
+
+type InMemoryFailedEventStore struct {
+	sync.Mutex
+	failed map[string][]FailedEvent
+}
+
+func NewInMemoryFailedEventStore() *InMemoryFailedEventStore {
+	return &InMemoryFailedEventStore{
+		failed: make(map[string][]FailedEvent),
+	}
+}
+
+func (s *InMemoryFailedEventStore) RecordFailedEvents(
+	projection string,
+	events []PersistentEvent,
+	err error,
+) error {
+	s.Lock()
+	defer s.Unlock()
+	now := time.Now()
+	for _, event := range events {
+		s.failed[projection] = append(s.failed[projection], FailedEvent{
+			ActorID:    event.ActorID,
+			SequenceID: event.SequenceID,
+			Error:      err.Error(),
+			FailedAt:   now,
+		})
+	}
+	return nil
+}
+
+func (s *InMemoryFailedEventStore) FailedEvents(projection string) []FailedEvent {
+	s.Lock()
+	defer s.Unlock()
+	return append([]FailedEvent(nil), s.failed[projection]...)
+}
+
+// CassandraFailedEventStore writes to the failed_events table created by the
+// Cassandra persistence provider.
+type CassandraFailedEventStore struct {
+	cassandra *gocql.Session
+}
+
+func NewCassandraFailedEventStore(
+	cassandra *gocql.Session,
+) *CassandraFailedEventStore {
+	return &CassandraFailedEventStore{
+		cassandra: cassandra,
+	}
+}
+
+func (s *CassandraFailedEventStore) RecordFailedEvents(
+	projection string,
+	events []PersistentEvent,
+	err error,
+) error {
+	stmt, names := qb.Insert("failed_events").
+		Columns("projection", "actor_id", "sequence_id", "error", "failed_at").
+		ToCql()
+	now := time.Now()
+	queries := make([]BatchableQuery, len(events))
+	for i, event := range events {
+		queries[i] = QueryFromMap(stmt, names, qb.M{
+			"projection":  projection,
+			"actor_id":    event.ActorID,
+			"sequence_id": event.SequenceID,
+			"error":       err.Error(),
+			"failed_at":   now,
+		})
+	}
+	return NewLazyQueryBatch(queries...).Execute(s.cassandra)
+}
+
+func (s *CassandraFailedEventStore) FailedEvents(
+	projection string,
+) ([]FailedEvent, error) {
+	stmt, names := qb.Select("failed_events").
+		Columns("actor_id", "sequence_id", "error", "failed_at").
+		Where(qb.Eq("projection")).
+		ToCql()
+	q := gocqlx.Query(s.cassandra.Query(stmt), names).BindMap(qb.M{
+		"projection": projection,
+	})
+	var failed []FailedEvent
+	err := gocqlx.Select(&failed, q.Query)
+	return failed, err
+}
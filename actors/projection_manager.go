@@ -0,0 +1,115 @@
+package actors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// ProjectionManager keeps track of running projections by name so they can
+// be paused, resumed, rewound and inspected. Each call waits for the
+// projection's reply until ctx is done.
+type ProjectionManager struct {
+	sync.Mutex
+	projections map[string]ActorRef
+}
+
+func NewProjectionManager() *ProjectionManager {
+	return &ProjectionManager{
+		projections: make(map[string]ActorRef),
+	}
+}
+
+func (pm *ProjectionManager) Start(config ProjectionConfig) ActorRef {
+	ref := SpawnActor(NewProjection(config))
+	pm.Register(config.Name, ref)
+	return ref
+}
+
+func (pm *ProjectionManager) Register(name string, ref ActorRef) {
+	pm.Lock()
+	defer pm.Unlock()
+	pm.projections[name] = ref
+}
+
+func (pm *ProjectionManager) Pause(
+	ctx context.Context,
+	name string,
+) (ProjectionStatus, error) {
+	return pm.ask(ctx, name, PauseProjection{})
+}
+
+func (pm *ProjectionManager) Resume(
+	ctx context.Context,
+	name string,
+) (ProjectionStatus, error) {
+	return pm.ask(ctx, name, ResumeProjection{})
+}
+
+func (pm *ProjectionManager) ResetOffset(
+	ctx context.Context,
+	name string,
+	offset Offset,
+) (ProjectionStatus, error) {
+	return pm.ask(ctx, name, ResetProjectionOffset{Offset: offset})
+}
+
+func (pm *ProjectionManager) Rebuild(
+	ctx context.Context,
+	name string,
+) (ProjectionStatus, error) {
+	return pm.ask(ctx, name, RebuildProjection{})
+}
+
+func (pm *ProjectionManager) Status(
+	ctx context.Context,
+	name string,
+) (ProjectionStatus, error) {
+	return pm.ask(ctx, name, GetProjectionStatus{})
+}
+
+func (pm *ProjectionManager) Statuses(
+	ctx context.Context,
+) ([]ProjectionStatus, error) {
+	pm.Lock()
+	names := make([]string, 0, len(pm.projections))
+	for name := range pm.projections {
+		names = append(names, name)
+	}
+	pm.Unlock()
+	sort.Strings(names)
+
+	statuses := make([]ProjectionStatus, 0, len(names))
+	for _, name := range names {
+		status, err := pm.Status(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+func (pm *ProjectionManager) ask(
+	ctx context.Context,
+	name string,
+	message interface{},
+) (ProjectionStatus, error) {
+	pm.Lock()
+	ref, found := pm.projections[name]
+	pm.Unlock()
+	if !found {
+		return ProjectionStatus{}, errors.New("not found")
+	}
+	reply, err := ref.AskContext(ctx, message)
+	if err != nil {
+		return ProjectionStatus{}, err
+	}
+	status, ok := reply.(ProjectionStatus)
+	if !ok {
+		return ProjectionStatus{}, fmt.Errorf("unexpected reply of type %T", reply)
+	}
+	return status, nil
+}
@@ -0,0 +1,168 @@
+package actors_test
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/wrappers"
+	. "github.com/kphelps/actors/actors"
+	"github.com/kphelps/streams/streams"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type resettableHandler struct {
+	handled chan uint64
+	resets  chan struct{}
+}
+
+func (h *resettableHandler) Handle(
+	events []PersistentEvent,
+) (ProjectionEffect, error) {
+	for _, event := range events {
+		h.handled <- event.SequenceID
+	}
+	return nil, nil
+}
+
+func (h *resettableHandler) Reset() error {
+	h.resets <- struct{}{}
+	return nil
+}
+
+var _ = Describe("ProjectionManager", func() {
+	var manager *ProjectionManager
+	var handler *resettableHandler
+	var config ProjectionConfig
+	var actorID string
+	var persisted uint64
+	ctx := context.Background()
+
+	persist := func(n int) {
+		for i := 0; i < n; i++ {
+			event := &wrappers.StringValue{Value: "event"}
+			err := GetPersistenceProvider().PersistEvent(actorID, persisted, event)
+			Expect(err).NotTo(HaveOccurred())
+			persisted++
+		}
+	}
+
+	expectHandled := func(sequenceIDs ...uint64) {
+		for _, sequenceID := range sequenceIDs {
+			EventuallyWithOffset(1, handler.handled).Should(Receive(Equal(sequenceID)))
+		}
+	}
+
+	BeforeEach(func() {
+		manager = NewProjectionManager()
+		handler = &resettableHandler{
+			handled: make(chan uint64, 100),
+			resets:  make(chan struct{}, 1),
+		}
+		actorID = fmt.Sprintf("projection-%d", time.Now().UnixNano())
+		persisted = 0
+		config = ProjectionConfig{
+			Name:          "projection",
+			PersistenceID: actorID,
+			Source: func(offset Offset) streams.Source {
+				return NewActorEventSource(actorID, offset.Sequence)
+			},
+			Handler:     handler,
+			OffsetStore: NewInMemoryOffsetStore(),
+			Mode:        ExactlyOnce,
+		}
+	})
+
+	It("Reports an unknown projection as not found", func() {
+		_, err := manager.Status(ctx, "unknown")
+		Expect(err).To(MatchError("not found"))
+	})
+
+	It("Gives up on a projection that does not answer in time", func() {
+		manager.Register("silent", SpawnActor(&ChannelActor{make(chan interface{}, 1)}))
+		timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+		_, err := manager.Status(timeout, "silent")
+		Expect(err).To(Equal(context.DeadlineExceeded))
+	})
+
+	It("Reports offset and lag", func() {
+		persist(3)
+		manager.Start(config)
+		expectHandled(0, 1, 2)
+
+		status, err := manager.Pause(ctx, "projection")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(status.State).To(Equal(ProjectionPaused))
+		Expect(status.Offset).To(Equal(SequenceOffset(3)))
+		Expect(status.Lag).To(BeZero())
+
+		persist(2)
+		status, err = manager.Status(ctx, "projection")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(status.Lag).To(Equal(uint64(2)))
+	})
+
+	It("Pauses and resumes", func() {
+		persist(2)
+		manager.Start(config)
+		expectHandled(0, 1)
+
+		_, err := manager.Pause(ctx, "projection")
+		Expect(err).NotTo(HaveOccurred())
+		persist(2)
+		Consistently(handler.handled).ShouldNot(Receive())
+
+		status, err := manager.Resume(ctx, "projection")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(status.State).To(Equal(ProjectionRunning))
+		expectHandled(2, 3)
+	})
+
+	It("Replays from a reset offset", func() {
+		persist(3)
+		manager.Start(config)
+		expectHandled(0, 1, 2)
+
+		status, err := manager.ResetOffset(ctx, "projection", SequenceOffset(1))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(status.Offset).To(Equal(SequenceOffset(1)))
+		expectHandled(1, 2)
+	})
+
+	It("Rebuilds from scratch", func() {
+		persist(2)
+		manager.Start(config)
+		expectHandled(0, 1)
+
+		_, err := manager.Rebuild(ctx, "projection")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(handler.resets).To(Receive())
+		expectHandled(0, 1)
+	})
+
+	It("Refuses to rebuild a handler without Reset", func() {
+		config.Handler = ProjectionHandlerFunc(
+			func(events []PersistentEvent) (ProjectionEffect, error) {
+				return nil, nil
+			},
+		)
+		manager.Start(config)
+		status, err := manager.Rebuild(ctx, "projection")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(status.LastError).To(HaveOccurred())
+	})
+
+	It("Lists every projection", func() {
+		manager.Start(config)
+		config.Name = "another"
+		manager.Start(config)
+		statuses, err := manager.Statuses(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(statuses).To(HaveLen(2))
+		Expect(statuses[0].Name).To(Equal("another"))
+		Expect(statuses[1].Name).To(Equal("projection"))
+	})
+})
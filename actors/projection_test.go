@@ -0,0 +1,415 @@
+package actors_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	. "github.com/kphelps/actors/actors"
+	"github.com/kphelps/streams/streams"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// flakyOffsetStore fails the first failures reads of an offset.
+type flakyOffsetStore struct {
+	OffsetStore
+	failures int32
+}
+
+func (fos *flakyOffsetStore) GetOffset(name string) (Offset, error) {
+	if atomic.AddInt32(&fos.failures, -1) >= 0 {
+		return Offset{}, errors.New("unavailable")
+	}
+	return fos.OffsetStore.GetOffset(name)
+}
+
+var _ = Describe("Projection", func() {
+	var events chan PersistentEvent
+	var startOffset chan Offset
+	var handled chan []PersistentEvent
+	var store *InMemoryOffsetStore
+	var config ProjectionConfig
+	var refs []ActorRef
+
+	// Projections are stopped after each spec so none of them is still
+	// reading the previous spec's config and channels.
+	spawn := func() ActorRef {
+		ref := SpawnActor(NewProjection(config))
+		refs = append(refs, ref)
+		return ref
+	}
+
+	startManaged := func(manager *ProjectionManager) {
+		refs = append(refs, manager.Start(config))
+	}
+
+	source := func(offset Offset) streams.Source {
+		startOffset <- offset
+		// A closed stream can still be blocked on the channel it was
+		// opened with.
+		events := events
+		return streams.NewSource(func() PersistentEvent {
+			return <-events
+		})
+	}
+
+	emit := func(from uint64, to uint64) {
+		for i := from; i < to; i++ {
+			events <- PersistentEvent{ActorID: "a", SequenceID: i}
+		}
+	}
+
+	offset := func() uint64 {
+		offset, err := store.GetOffset("projection")
+		Expect(err).NotTo(HaveOccurred())
+		return offset.Sequence
+	}
+
+	recordGroups := ProjectionHandlerFunc(
+		func(group []PersistentEvent) (ProjectionEffect, error) {
+			handled <- group
+			return nil, nil
+		},
+	)
+
+	BeforeEach(func() {
+		events = make(chan PersistentEvent, 100)
+		startOffset = make(chan Offset, 1)
+		handled = make(chan []PersistentEvent, 100)
+		store = NewInMemoryOffsetStore()
+		config = ProjectionConfig{
+			Name:        "projection",
+			Source:      source,
+			Handler:     recordGroups,
+			OffsetStore: store,
+			Mode:        ExactlyOnce,
+		}
+		refs = nil
+	})
+
+	AfterEach(func() {
+		for _, ref := range refs {
+			ref.StopAndWait()
+		}
+	})
+
+	Describe("Exactly once", func() {
+		It("Commits each effect with its offset", func() {
+			applied := make([]uint64, 0)
+			config.Handler = ProjectionHandlerFunc(
+				func(group []PersistentEvent) (ProjectionEffect, error) {
+					return func() error {
+						applied = append(applied, group[0].SequenceID)
+						return nil
+					}, nil
+				},
+			)
+			spawn()
+			emit(0, 3)
+			Eventually(offset).Should(Equal(uint64(3)))
+			Expect(applied).To(Equal([]uint64{0, 1, 2}))
+		})
+
+		It("Resumes from the stored offset", func() {
+			Expect(store.SaveOffset("projection", SequenceOffset(7))).To(Succeed())
+			spawn()
+			Eventually(startOffset).Should(Receive(Equal(SequenceOffset(7))))
+		})
+
+		It("Retries a failed effect without moving the offset", func() {
+			var attempts int32
+			config.Handler = ProjectionHandlerFunc(
+				func(group []PersistentEvent) (ProjectionEffect, error) {
+					return func() error {
+						atomic.AddInt32(&attempts, 1)
+						if atomic.LoadInt32(&attempts) < 3 {
+							return errors.New("err")
+						}
+						return nil
+					}, nil
+				},
+			)
+			spawn()
+			emit(0, 1)
+			Eventually(offset).Should(Equal(uint64(1)))
+			Expect(atomic.LoadInt32(&attempts)).To(Equal(int32(3)))
+		})
+
+		It("Requires a transactional offset store", func() {
+			config.OffsetStore = struct{ OffsetStore }{store}
+			status := spawn().Ask(GetProjectionStatus{}).(ProjectionStatus)
+			Expect(status.State).To(Equal(ProjectionStopped))
+			Expect(status.LastError).To(HaveOccurred())
+		})
+	})
+
+	Describe("Time-UUID offsets", func() {
+		It("Resumes a tag projection after the last handled event", func() {
+			tag := fmt.Sprintf("projection-%d", time.Now().UnixNano())
+			persistTagged := func(actorID string, sequenceID uint64) {
+				err := GetPersistenceProvider().PersistEvent(
+					actorID,
+					sequenceID,
+					&Tagged{Event: &wrappers.StringValue{Value: "e"}, Tags: []string{tag}},
+				)
+				Expect(err).NotTo(HaveOccurred())
+			}
+			config.TimeUUIDOffsets = true
+			config.Source = func(offset Offset) streams.Source {
+				return NewTagEventSource(tag, offset.TimeUUID, 0)
+			}
+			persistTagged(tag+"-a", 0)
+			persistTagged(tag+"-b", 0)
+
+			ref := spawn()
+			Eventually(handled).Should(HaveLen(2))
+			ref.Stop()
+			stored, err := store.GetOffset("projection")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stored.TimeUUID).NotTo(Equal(gocql.UUID{}))
+
+			persistTagged(tag+"-a", 1)
+			ref = spawn()
+			defer ref.Stop()
+			<-handled
+			<-handled
+			var group []PersistentEvent
+			Eventually(handled).Should(Receive(&group))
+			Expect(group[0].ActorID).To(Equal(tag + "-a"))
+			Expect(group[0].SequenceID).To(Equal(uint64(1)))
+			Consistently(handled).ShouldNot(Receive())
+		})
+	})
+
+	Describe("Grouping", func() {
+		It("Hands the handler groups of events", func() {
+			config.GroupSize = 3
+			config.GroupInterval = 10 * time.Millisecond
+			spawn()
+			emit(0, 7)
+
+			var group []PersistentEvent
+			Eventually(handled).Should(Receive(&group))
+			Expect(group).To(HaveLen(3))
+			Eventually(handled).Should(Receive(&group))
+			Expect(group).To(HaveLen(3))
+			Eventually(handled).Should(Receive(&group))
+			Expect(group).To(HaveLen(1))
+			Expect(group[0].SequenceID).To(Equal(uint64(6)))
+			Eventually(offset).Should(Equal(uint64(7)))
+		})
+	})
+
+	Describe("At least once", func() {
+		BeforeEach(func() {
+			config.Mode = AtLeastOnce
+			config.CommitEvery = 2
+		})
+
+		It("Commits every CommitEvery events", func() {
+			config.CommitInterval = time.Hour
+			spawn()
+			emit(0, 5)
+			Eventually(handled).Should(HaveLen(5))
+			Eventually(offset).Should(Equal(uint64(4)))
+			Consistently(offset).Should(Equal(uint64(4)))
+		})
+
+		It("Commits stragglers after CommitInterval", func() {
+			config.CommitInterval = 20 * time.Millisecond
+			spawn()
+			emit(0, 3)
+			Eventually(offset).Should(Equal(uint64(3)))
+		})
+
+		It("Retries a failed handler", func() {
+			var attempts int32
+			config.CommitEvery = 1
+			config.Handler = ProjectionHandlerFunc(
+				func(group []PersistentEvent) (ProjectionEffect, error) {
+					atomic.AddInt32(&attempts, 1)
+					if atomic.LoadInt32(&attempts) < 3 {
+						return nil, errors.New("err")
+					}
+					return nil, nil
+				},
+			)
+			spawn()
+			emit(0, 1)
+			Eventually(offset).Should(Equal(uint64(1)))
+			Expect(atomic.LoadInt32(&attempts)).To(Equal(int32(3)))
+		})
+	})
+
+	Describe("At most once", func() {
+		It("Drops events the handler fails on", func() {
+			config.Mode = AtMostOnce
+			var attempts int32
+			config.Handler = ProjectionHandlerFunc(
+				func(group []PersistentEvent) (ProjectionEffect, error) {
+					atomic.AddInt32(&attempts, 1)
+					handled <- group
+					return nil, errors.New("err")
+				},
+			)
+			spawn()
+			emit(0, 2)
+			Eventually(handled).Should(HaveLen(2))
+			Expect(offset()).To(Equal(uint64(2)))
+			Expect(atomic.LoadInt32(&attempts)).To(Equal(int32(2)))
+		})
+	})
+
+	Describe("Starting", func() {
+		status := func(ref ActorRef) ProjectionStatus {
+			return ref.Ask(GetProjectionStatus{}).(ProjectionStatus)
+		}
+
+		It("Defaults to at least once", func() {
+			Expect(ProjectionConfig{}.Mode).To(Equal(AtLeastOnce))
+		})
+
+		It("Retries reading its offset", func() {
+			config.OffsetStore = &flakyOffsetStore{OffsetStore: store, failures: 2}
+			config.Mode = AtLeastOnce
+			config.FailurePolicy.MinBackoff = time.Millisecond
+			spawn()
+			emit(0, 1)
+			Eventually(handled).Should(Receive())
+		})
+
+		It("Stops once reading its offset keeps failing", func() {
+			config.OffsetStore = &flakyOffsetStore{OffsetStore: store, failures: 10}
+			config.Mode = AtLeastOnce
+			config.FailurePolicy = ProjectionFailurePolicy{
+				MaxRetries: 1,
+				MinBackoff: time.Millisecond,
+			}
+			ref := spawn()
+			Eventually(func() ProjectionState {
+				return status(ref).State
+			}).Should(Equal(ProjectionStopped))
+			Expect(status(ref).LastError).To(MatchError("unavailable"))
+		})
+	})
+
+	Describe("Failure policy", func() {
+		var failures int32
+
+		BeforeEach(func() {
+			atomic.StoreInt32(&failures, 0)
+			config.Mode = AtLeastOnce
+			config.CommitEvery = 1
+			config.Handler = ProjectionHandlerFunc(
+				func(group []PersistentEvent) (ProjectionEffect, error) {
+					if group[0].SequenceID == 1 {
+						atomic.AddInt32(&failures, 1)
+						return nil, errors.New("poison")
+					}
+					handled <- group
+					return nil, nil
+				},
+			)
+			config.FailurePolicy = ProjectionFailurePolicy{
+				MaxRetries: 2,
+				MinBackoff: time.Millisecond,
+				MaxBackoff: 5 * time.Millisecond,
+				Jitter:     0.5,
+			}
+		})
+
+		It("Skips and records events that keep failing", func() {
+			failed := NewInMemoryFailedEventStore()
+			config.FailurePolicy.FailedEvents = failed
+			spawn()
+			emit(0, 3)
+
+			Eventually(offset).Should(Equal(uint64(3)))
+			Expect(atomic.LoadInt32(&failures)).To(Equal(int32(3)))
+			Expect(handled).To(HaveLen(2))
+			records := failed.FailedEvents("projection")
+			Expect(records).To(HaveLen(1))
+			Expect(records[0].SequenceID).To(Equal(uint64(1)))
+			Expect(records[0].Error).To(Equal("poison"))
+		})
+
+		It("Stops on failure", func() {
+			config.FailurePolicy.OnFailure = StopOnFailure
+			manager := NewProjectionManager()
+			startManaged(manager)
+			emit(0, 3)
+
+			Eventually(func() ProjectionState {
+				status, err := manager.Status(context.Background(), "projection")
+				Expect(err).NotTo(HaveOccurred())
+				return status.State
+			}).Should(Equal(ProjectionStopped))
+			status, _ := manager.Status(context.Background(), "projection")
+			Expect(status.Offset).To(Equal(SequenceOffset(1)))
+			Expect(status.LastError).To(MatchError("poison"))
+		})
+
+		It("Escalates to the supervisor", func() {
+			supervisor := make(chan interface{}, 1)
+			config.FailurePolicy.OnFailure = EscalateFailure
+			config.FailurePolicy.Supervisor = SpawnActor(&ChannelActor{supervisor})
+			spawn()
+			emit(0, 3)
+
+			var message interface{}
+			Eventually(supervisor).Should(Receive(&message))
+			failure := message.(ProjectionFailed)
+			Expect(failure.Name).To(Equal("projection"))
+			Expect(failure.Events[0].SequenceID).To(Equal(uint64(1)))
+			Expect(failure.Err).To(MatchError("poison"))
+		})
+
+		It("Stops when escalating without a supervisor", func() {
+			config.FailurePolicy.OnFailure = EscalateFailure
+			manager := NewProjectionManager()
+			startManaged(manager)
+			emit(0, 3)
+
+			Eventually(func() ProjectionState {
+				status, err := manager.Status(context.Background(), "projection")
+				Expect(err).NotTo(HaveOccurred())
+				return status.State
+			}).Should(Equal(ProjectionStopped))
+		})
+
+		It("Backs off by default", func() {
+			config.FailurePolicy = ProjectionFailurePolicy{}
+			spawn()
+			emit(0, 3)
+
+			Eventually(func() int32 {
+				return atomic.LoadInt32(&failures)
+			}).Should(BeNumerically(">=", 1))
+			Consistently(func() int32 {
+				return atomic.LoadInt32(&failures)
+			}, 150*time.Millisecond).Should(BeNumerically("<=", 3))
+		})
+
+		It("Stays responsive while backing off", func() {
+			config.FailurePolicy.MaxRetries = 0
+			config.FailurePolicy.MinBackoff = time.Hour
+			manager := NewProjectionManager()
+			startManaged(manager)
+			emit(0, 3)
+
+			Eventually(func() error {
+				status, _ := manager.Status(context.Background(), "projection")
+				return status.LastError
+			}).Should(MatchError("poison"))
+			status, err := manager.Pause(context.Background(), "projection")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(status.State).To(Equal(ProjectionPaused))
+		})
+	})
+})
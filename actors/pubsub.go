@@ -0,0 +1,414 @@
+package actors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+type RoutingLogic int
+
+const (
+	RandomRouting RoutingLogic = iota
+	RoundRobinRouting
+)
+
+type PubSubConfig struct {
+	Cluster *Cluster
+	// Every member sends its registrations to the others this often, as
+	// well as whenever they change.
+	GossipInterval time.Duration
+	// Picks the actor a Send goes to and the subscriber that receives a
+	// publish for each group.
+	RoutingLogic RoutingLogic
+}
+
+const pubSubMediatorName = "pubsub/mediator"
+
+// DistributedPubSub lets actors anywhere in a cluster subscribe to topics
+// and register under paths. Each member runs a mediator that owns the
+// registrations made on it and gossips them to the mediators of the other
+// members.
+//
+// Registrations of a local actor are dropped once it stops, at the latest
+// by the next gossip. Refs to actors on other systems can't be watched, so
+// those stay registered until they unsubscribe or unregister.
+type DistributedPubSub struct {
+	remote   *RemoteSystem
+	mediator ActorRef
+}
+
+// A registration is either a subscription to Topic, optionally as part of
+// Group, or a registration under Path. Ref is the path of the actor.
+type pubSubEntry struct {
+	Topic string
+	Group string
+	Path  string
+	Ref   string
+}
+
+// pubSubBucket holds the registrations made on one member.
+type pubSubBucket struct {
+	Owner   string
+	Version int64
+	Entries []pubSubEntry
+}
+
+type pubSubAdd struct {
+	entry pubSubEntry
+	ref   ActorRef
+}
+
+type pubSubRemove struct {
+	entry pubSubEntry
+}
+
+type pubSubPublish struct {
+	topic   string
+	message interface{}
+}
+
+type pubSubSend struct {
+	path    string
+	message interface{}
+}
+
+type getPubSubSubscribers struct {
+	topic string
+}
+
+type pubSubTick struct{}
+
+func init() {
+	defaultSerializers.RegisterJSON("actors.pubSubBucket", pubSubBucket{})
+}
+
+func NewDistributedPubSub(config PubSubConfig) *DistributedPubSub {
+	if config.GossipInterval <= 0 {
+		config.GossipInterval = time.Second
+	}
+	remote := config.Cluster.Remote()
+	mediator := SpawnActor(&pubSubMediator{
+		config:     config,
+		self:       remote.Address(),
+		members:    make(map[string]MemberStatus),
+		local:      make(map[pubSubEntry]ActorRef),
+		version:    time.Now().UnixNano(),
+		buckets:    make(map[string]pubSubBucket),
+		roundRobin: make(map[string]int),
+	})
+	remote.Register(pubSubMediatorName, mediator)
+	return &DistributedPubSub{
+		remote:   remote,
+		mediator: mediator,
+	}
+}
+
+func (ps *DistributedPubSub) Mediator() ActorRef {
+	return ps.mediator
+}
+
+// Subscribe has ref receive every message published to topic.
+func (ps *DistributedPubSub) Subscribe(topic string, ref ActorRef) {
+	ps.SubscribeGroup(topic, "", ref)
+}
+
+// SubscribeGroup has ref share the messages published to topic with the
+// other subscribers in group. Each message goes to one of them.
+func (ps *DistributedPubSub) SubscribeGroup(
+	topic string,
+	group string,
+	ref ActorRef,
+) {
+	ps.mediator.Send(pubSubAdd{pubSubEntry{
+		Topic: topic,
+		Group: group,
+		Ref:   ps.remote.PathOf(ref),
+	}, ref})
+}
+
+func (ps *DistributedPubSub) Unsubscribe(topic string, ref ActorRef) {
+	ps.UnsubscribeGroup(topic, "", ref)
+}
+
+func (ps *DistributedPubSub) UnsubscribeGroup(
+	topic string,
+	group string,
+	ref ActorRef,
+) {
+	ps.mediator.Send(pubSubRemove{pubSubEntry{
+		Topic: topic,
+		Group: group,
+		Ref:   ps.remote.PathOf(ref),
+	}})
+}
+
+func (ps *DistributedPubSub) Publish(topic string, message interface{}) {
+	ps.PublishFrom(topic, message, nil)
+}
+
+func (ps *DistributedPubSub) PublishFrom(
+	topic string,
+	message interface{},
+	sender ActorRef,
+) {
+	ps.mediator.SendFrom(pubSubPublish{topic, message}, sender)
+}
+
+// Register makes ref one of the actors that messages sent to path can go
+// to.
+func (ps *DistributedPubSub) Register(path string, ref ActorRef) {
+	ps.mediator.Send(pubSubAdd{pubSubEntry{
+		Path: path,
+		Ref:  ps.remote.PathOf(ref),
+	}, ref})
+}
+
+func (ps *DistributedPubSub) Unregister(path string, ref ActorRef) {
+	ps.mediator.Send(pubSubRemove{pubSubEntry{
+		Path: path,
+		Ref:  ps.remote.PathOf(ref),
+	}})
+}
+
+func (ps *DistributedPubSub) Send(path string, message interface{}) {
+	ps.SendFrom(path, message, nil)
+}
+
+// SendFrom delivers message to one of the actors registered under path,
+// chosen by the RoutingLogic.
+func (ps *DistributedPubSub) SendFrom(
+	path string,
+	message interface{},
+	sender ActorRef,
+) {
+	ps.mediator.SendFrom(pubSubSend{path, message}, sender)
+}
+
+// Subscribers returns the paths of the actors subscribed to topic that this
+// member knows about.
+func (ps *DistributedPubSub) Subscribers(
+	ctx context.Context,
+	topic string,
+) ([]string, error) {
+	reply, err := ps.mediator.AskContext(ctx, getPubSubSubscribers{topic})
+	if err != nil {
+		return nil, err
+	}
+	subscribers, ok := reply.([]string)
+	if !ok {
+		return nil, fmt.Errorf("unexpected reply of type %T", reply)
+	}
+	return subscribers, nil
+}
+
+func (ps *DistributedPubSub) Close() {
+	ps.remote.Unregister(pubSubMediatorName)
+	ps.mediator.Stop()
+}
+
+type pubSubMediator struct {
+	config     PubSubConfig
+	self       string
+	members    map[string]MemberStatus
+	local      map[pubSubEntry]ActorRef
+	version    int64
+	buckets    map[string]pubSubBucket
+	roundRobin map[string]int
+}
+
+func (m *pubSubMediator) OnStart(context ActorContext) {
+	m.config.Cluster.Subscribe(context.Self())
+	ScheduleOnce(m.config.GossipInterval, context.Self(), pubSubTick{})
+}
+
+func (m *pubSubMediator) OnStop(context ActorContext) {
+	m.config.Cluster.Unsubscribe(context.Self())
+}
+
+func (m *pubSubMediator) Receive(context ActorContext) {
+	switch message := context.Message().(type) {
+	case CurrentClusterState:
+		for _, member := range message.Members {
+			m.members[member.Address] = member.Status
+		}
+		m.gossip()
+	case MemberJoined, MemberUp, MemberLeft, MemberExited, MemberDowned, MemberRemoved:
+		member := eventMember(message)
+		if member.Status >= MemberStatusDown {
+			delete(m.members, member.Address)
+			delete(m.buckets, member.Address)
+			return
+		}
+		_, known := m.members[member.Address]
+		m.members[member.Address] = member.Status
+		if !known {
+			m.gossip()
+		}
+	case pubSubAdd:
+		_, found := m.local[message.entry]
+		if !found {
+			m.local[message.entry] = message.ref
+			m.changed()
+		}
+	case pubSubRemove:
+		_, found := m.local[message.entry]
+		if found {
+			delete(m.local, message.entry)
+			m.changed()
+		}
+	case pubSubBucket:
+		_, member := m.members[message.Owner]
+		known, found := m.buckets[message.Owner]
+		if member && message.Owner != m.self && (!found || message.Version > known.Version) {
+			m.buckets[message.Owner] = message
+		}
+	case pubSubPublish:
+		m.publish(message, context.Sender())
+	case pubSubSend:
+		var refs []string
+		for _, entry := range m.entries() {
+			if entry.Path == message.path {
+				refs = append(refs, entry.Ref)
+			}
+		}
+		if len(refs) == 0 {
+			m.deadLetter(message.path, message.message, context.Sender())
+			return
+		}
+		m.deliver(m.route("path:"+message.path, refs), message.message, context.Sender())
+	case getPubSubSubscribers:
+		if m.prune() {
+			m.changed()
+		}
+		refs := []string{}
+		for _, entry := range m.entries() {
+			if entry.Topic == message.topic {
+				refs = append(refs, entry.Ref)
+			}
+		}
+		sort.Strings(refs)
+		context.Reply(refs)
+	case pubSubTick:
+		m.gossip()
+		ScheduleOnce(m.config.GossipInterval, context.Self(), pubSubTick{})
+	}
+}
+
+// publish sends message to every subscriber outside a group and to one
+// subscriber of each group.
+func (m *pubSubMediator) publish(message pubSubPublish, sender ActorRef) {
+	groups := make(map[string][]string)
+	delivered := false
+	for _, entry := range m.entries() {
+		if entry.Topic != message.topic {
+			continue
+		}
+		if entry.Group == "" {
+			m.deliver(entry.Ref, message.message, sender)
+		} else {
+			groups[entry.Group] = append(groups[entry.Group], entry.Ref)
+		}
+		delivered = true
+	}
+	for group, refs := range groups {
+		key := "group:" + message.topic + "/" + group
+		m.deliver(m.route(key, refs), message.message, sender)
+	}
+	if !delivered {
+		m.deadLetter(message.topic, message.message, sender)
+	}
+}
+
+func (m *pubSubMediator) route(key string, refs []string) string {
+	sort.Strings(refs)
+	if m.config.RoutingLogic == RoundRobinRouting {
+		next := m.roundRobin[key]
+		m.roundRobin[key] = next + 1
+		return refs[next%len(refs)]
+	}
+	return refs[rand.Intn(len(refs))]
+}
+
+func (m *pubSubMediator) deliver(
+	path string,
+	message interface{},
+	sender ActorRef,
+) {
+	ref, err := m.config.Cluster.Remote().ActorFor(path)
+	if err == nil {
+		ref.SendFrom(message, sender)
+	}
+}
+
+func (m *pubSubMediator) deadLetter(
+	recipient string,
+	message interface{},
+	sender ActorRef,
+) {
+	m.config.Cluster.Remote().deadLetter(
+		recipient,
+		message,
+		sender,
+		errors.New("no subscribers"),
+	)
+}
+
+// entries returns the registrations of every member.
+func (m *pubSubMediator) entries() []pubSubEntry {
+	entries := make([]pubSubEntry, 0, len(m.local))
+	for entry := range m.local {
+		entries = append(entries, entry)
+	}
+	for _, bucket := range m.buckets {
+		entries = append(entries, bucket.Entries...)
+	}
+	return entries
+}
+
+func (m *pubSubMediator) changed() {
+	m.version++
+	m.gossip()
+}
+
+// prune drops the registrations of local actors that have stopped, along
+// with the names PathOf gave them.
+func (m *pubSubMediator) prune() bool {
+	pruned := false
+	for entry, ref := range m.local {
+		if isStoppedRef(ref) {
+			delete(m.local, entry)
+			m.config.Cluster.Remote().forget(ref)
+			pruned = true
+		}
+	}
+	return pruned
+}
+
+func (m *pubSubMediator) gossip() {
+	if m.prune() {
+		m.version++
+	}
+	bucket := pubSubBucket{
+		Owner:   m.self,
+		Version: m.version,
+		Entries: make([]pubSubEntry, 0, len(m.local)),
+	}
+	for entry := range m.local {
+		bucket.Entries = append(bucket.Entries, entry)
+	}
+	for address, status := range m.members {
+		if address == m.self || status >= MemberStatusDown {
+			continue
+		}
+		mediator, err := m.config.Cluster.Remote().ActorFor(
+			address + "/" + pubSubMediatorName,
+		)
+		if err == nil {
+			mediator.Send(bucket)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package actors_test
+
+import (
+	"context"
+	"time"
+
+	. "github.com/kphelps/actors/actors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type pubSubNews struct {
+	Text string
+}
+
+func init() {
+	DefaultSerializers().RegisterJSON("actors_test.pubSubNews", pubSubNews{})
+}
+
+var _ = Describe("DistributedPubSub", func() {
+	var nodes []*clusterNode
+	var pubSubs []*DistributedPubSub
+	var receivers []chan interface{}
+	var refs []ActorRef
+
+	BeforeEach(func() {
+		seed := startClusterNode()
+		nodes = []*clusterNode{
+			seed,
+			startClusterNode(seed.remote.Address()),
+			startClusterNode(seed.remote.Address()),
+		}
+		pubSubs, receivers, refs = nil, nil, nil
+		for _, node := range nodes {
+			Eventually(memberStatuses(node)).Should(Equal(allUp(nodes...)))
+			pubSubs = append(pubSubs, NewDistributedPubSub(PubSubConfig{
+				Cluster:        node.cluster,
+				GossipInterval: 20 * time.Millisecond,
+				RoutingLogic:   RoundRobinRouting,
+			}))
+			receiver := make(chan interface{}, 100)
+			receivers = append(receivers, receiver)
+			refs = append(refs, SpawnActor(&ChannelActor{receiver}))
+		}
+	})
+
+	subscribers := func(pubSub *DistributedPubSub, topic string) []string {
+		refs, err := pubSub.Subscribers(context.Background(), topic)
+		Expect(err).NotTo(HaveOccurred())
+		return refs
+	}
+
+	AfterEach(func() {
+		for i, node := range nodes {
+			refs[i].Stop()
+			pubSubs[i].Close()
+			node.stop()
+		}
+	})
+
+	It("Publishes to subscribers on every member", func() {
+		pubSubs[1].Subscribe("news", refs[1])
+		pubSubs[2].Subscribe("news", refs[2])
+		Eventually(func() []string {
+			return subscribers(pubSubs[0], "news")
+		}).Should(HaveLen(2))
+
+		pubSubs[0].Publish("news", pubSubNews{"hello"})
+		Eventually(receivers[1]).Should(Receive(Equal(pubSubNews{"hello"})))
+		Eventually(receivers[2]).Should(Receive(Equal(pubSubNews{"hello"})))
+		Consistently(receivers[0], 50*time.Millisecond).ShouldNot(Receive())
+	})
+
+	It("Stops publishing to actors that unsubscribe", func() {
+		pubSubs[1].Subscribe("news", refs[1])
+		pubSubs[2].Subscribe("news", refs[2])
+		Eventually(func() []string {
+			return subscribers(pubSubs[0], "news")
+		}).Should(HaveLen(2))
+
+		pubSubs[2].Unsubscribe("news", refs[2])
+		Eventually(func() []string {
+			return subscribers(pubSubs[0], "news")
+		}).Should(HaveLen(1))
+		pubSubs[0].Publish("news", pubSubNews{"hello"})
+		Eventually(receivers[1]).Should(Receive())
+		Consistently(receivers[2], 50*time.Millisecond).ShouldNot(Receive())
+	})
+
+	It("Forgets local subscribers that stop", func() {
+		pubSubs[1].Subscribe("news", refs[1])
+		Eventually(func() []string {
+			return subscribers(pubSubs[0], "news")
+		}).Should(HaveLen(1))
+
+		refs[1].StopAndWait()
+		Eventually(func() []string {
+			return subscribers(pubSubs[1], "news")
+		}).Should(BeEmpty())
+		Eventually(func() []string {
+			return subscribers(pubSubs[0], "news")
+		}).Should(BeEmpty())
+	})
+
+	It("Delivers to one subscriber of each group", func() {
+		pubSubs[0].Subscribe("jobs", refs[0])
+		pubSubs[1].SubscribeGroup("jobs", "workers", refs[1])
+		pubSubs[2].SubscribeGroup("jobs", "workers", refs[2])
+		Eventually(func() []string {
+			return subscribers(pubSubs[0], "jobs")
+		}).Should(HaveLen(3))
+
+		for i := 0; i < 4; i++ {
+			pubSubs[0].Publish("jobs", pubSubNews{"job"})
+		}
+		for i := 0; i < 4; i++ {
+			Eventually(receivers[0]).Should(Receive())
+		}
+		for _, receiver := range receivers[1:] {
+			Eventually(receiver).Should(Receive())
+			Eventually(receiver).Should(Receive())
+			Consistently(receiver, 50*time.Millisecond).ShouldNot(Receive())
+		}
+	})
+
+	It("Sends to one of the actors registered on a path", func() {
+		pubSubs[1].Register("service", refs[1])
+		pubSubs[2].Register("service", refs[2])
+		reached := make(map[int]bool)
+		Eventually(func() map[int]bool {
+			pubSubs[0].Send("service", pubSubNews{"ping"})
+			time.Sleep(20 * time.Millisecond)
+			for i, receiver := range receivers {
+				for len(receiver) > 0 {
+					<-receiver
+					reached[i] = true
+				}
+			}
+			return reached
+		}).Should(Equal(map[int]bool{1: true, 2: true}))
+
+		for i := 0; i < 4; i++ {
+			pubSubs[0].Send("service", pubSubNews{"ping"})
+		}
+		for _, receiver := range receivers[1:] {
+			Eventually(receiver).Should(Receive())
+			Eventually(receiver).Should(Receive())
+			Consistently(receiver, 50*time.Millisecond).ShouldNot(Receive())
+		}
+	})
+
+	It("Forgets the registrations of members that are gone", func() {
+		pubSubs[1].Subscribe("news", refs[1])
+		pubSubs[2].Subscribe("news", refs[2])
+		Eventually(func() []string {
+			return subscribers(pubSubs[0], "news")
+		}).Should(HaveLen(2))
+
+		crashed := nodes[2]
+		refs[2].Stop()
+		pubSubs[2].Close()
+		crashed.stop()
+		nodes, pubSubs, refs = nodes[:2], pubSubs[:2], refs[:2]
+
+		Eventually(func() []string {
+			return subscribers(pubSubs[0], "news")
+		}, 2*time.Second).Should(HaveLen(1))
+	})
+})
@@ -7,85 +7,66 @@ import (
 	"github.com/kphelps/streams/streams"
 )
 
-type readSideActor struct {
-	cassandra            *gocql.Session
-	handler              ReadSideHandler
-	stream               streams.RunnableStream
-	currentSequenceID    uint64
-	sequenceTracker      SequenceTracker
-	failureSleepDuration time.Duration
+type ReadSideHandler interface {
+	EventSource(startSequenceID uint64) streams.Source
+	OffsetName() string
+	ReadEvent(event PersistentEvent) (BatchableQuery, error)
 }
 
+// NewReadSideActor runs handler as an exactly-once projection whose queries
+// are committed with its offset in a single Cassandra batch.
 func NewReadSideActor(
 	cassandra *gocql.Session,
 	handler ReadSideHandler,
 	sequenceTracker SequenceTracker,
 	failureSleepDuration time.Duration,
 ) Actor {
-	return &readSideActor{
-		cassandra:            cassandra,
-		handler:              handler,
-		sequenceTracker:      sequenceTracker,
-		failureSleepDuration: failureSleepDuration,
-	}
+	return NewProjection(readSideProjectionConfig(
+		cassandra,
+		handler,
+		sequenceTracker,
+		failureSleepDuration,
+	))
 }
 
-type ReadSideHandler interface {
-	EventSource(startSequenceID uint64) streams.Source
-	OffsetName() string
-	ReadEvent(event PersistentEvent) (BatchableQuery, error)
-}
-
-func (rsa *readSideActor) OnStart(context ActorContext) {
-	offset, err := rsa.getCurrentSequenceID()
-	if err != nil {
-		panic("TODO")
+func readSideProjectionConfig(
+	cassandra *gocql.Session,
+	handler ReadSideHandler,
+	sequenceTracker SequenceTracker,
+	failureSleepDuration time.Duration,
+) ProjectionConfig {
+	return ProjectionConfig{
+		Name: handler.OffsetName(),
+		Source: func(offset Offset) streams.Source {
+			return handler.EventSource(offset.Sequence)
+		},
+		Handler:     &readSideProjectionHandler{handler},
+		OffsetStore: NewCassandraOffsetStore(cassandra, sequenceTracker),
+		Mode:        ExactlyOnce,
+		FailurePolicy: ProjectionFailurePolicy{
+			MinBackoff: failureSleepDuration,
+			MaxBackoff: failureSleepDuration,
+		},
 	}
-	source := rsa.handler.EventSource(offset)
-	sink := streams.NewSink(func(event PersistentEvent) {
-		context.Self().Send(event)
-	})
-	rsa.stream = source.AttachSink(sink)
-	rsa.stream.Open()
 }
 
-func (rsa *readSideActor) OnStop(context ActorContext) {
-	if rsa.stream != nil {
-		rsa.stream.Close()
-	}
+type readSideProjectionHandler struct {
+	handler ReadSideHandler
 }
 
-func (rsa *readSideActor) Receive(context ActorContext) {
-	switch event := context.Message().(type) {
-	case PersistentEvent:
-		for {
-			err := rsa.handle(event)
-			if err == nil {
-				break
-			}
-			time.Sleep(rsa.failureSleepDuration)
+func (h *readSideProjectionHandler) Handle(
+	events []PersistentEvent,
+) (ProjectionEffect, error) {
+	queries := make([]BatchableQuery, 0, len(events))
+	for _, event := range events {
+		q, err := h.handler.ReadEvent(event)
+		if err != nil {
+			return nil, err
 		}
+		queries = append(queries, q)
 	}
-}
-
-func (rsa *readSideActor) handle(actorEvent PersistentEvent) error {
-	q, err := rsa.handler.ReadEvent(actorEvent)
-	if err != nil {
-		return err
+	if len(queries) == 1 {
+		return queries[0], nil
 	}
-
-	q = q.Merge(rsa.updateSequenceID(actorEvent.SequenceID + 1))
-	return q.Execute(rsa.cassandra)
-}
-
-func (rsa *readSideActor) SequenceName() string {
-	return rsa.handler.OffsetName()
-}
-
-func (rsa *readSideActor) getCurrentSequenceID() (uint64, error) {
-	return rsa.sequenceTracker.GetSequenceID(rsa.SequenceName())
-}
-
-func (rsa *readSideActor) updateSequenceID(sequenceID uint64) BatchableQuery {
-	return rsa.sequenceTracker.UpdateSequence(rsa.SequenceName(), sequenceID)
+	return NewLazyQueryBatch(queries...), nil
 }
@@ -118,6 +118,12 @@ var _ = Describe("ReadSideActor", func() {
 						Return(NewLazyQueryBatch(), nil),
 				)
 				actor.Receive(context)
+				for i := 0; i < 3; i++ {
+					var retry interface{}
+					Eventually(receiveChannel).Should(Receive(&retry))
+					context.EXPECT().Message().Return(retry)
+					actor.Receive(context)
+				}
 				id, err := sequenceTracker.GetSequenceID("offset")
 				Expect(err).NotTo(HaveOccurred())
 				Expect(id).To(Equal(uint64(1)))
@@ -0,0 +1,186 @@
+package actors
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Recovery controls how a persistent actor replays its events when it
+// starts. The zero value replays every event.
+type Recovery struct {
+	// Replay starts at FromSequenceID and stops after ToSequenceID, or at the
+	// last event when ToSequenceID is zero.
+	FromSequenceID uint64
+	ToSequenceID   uint64
+	// Zero replays any number of events.
+	MaxEvents int
+	// Disabled skips the replay. New events still continue the sequence.
+	Disabled bool
+	// Recovery fails when reading the journal takes longer than Timeout.
+	// Zero waits as long as it takes.
+	Timeout      time.Duration
+	ReplayFilter ReplayFilter
+}
+
+// A PersistentActor implementing RecoveryCompletedHandler is told when it
+// has replayed its events, before it receives any messages.
+type RecoveryCompletedHandler interface {
+	RecoveryCompleted(context PersistentContext)
+}
+
+// A PersistentActor or DurableStateActor implementing RecoveryFailedHandler
+// stops without receiving any messages when its events or state cannot be
+// loaded. Other actors panic.
+type RecoveryFailedHandler interface {
+	RecoveryFailed(err error)
+}
+
+// ReplayFilter checks the events read from the journal before they are
+// replayed. An error fails recovery.
+type ReplayFilter interface {
+	Filter(events []PersistentEvent) ([]PersistentEvent, error)
+}
+
+type ReplayFilterMode int
+
+const (
+	// Recovery fails on the first corrupted event.
+	ReplayFilterFail ReplayFilterMode = iota
+	// Corrupted events are dropped and the rest are replayed.
+	ReplayFilterRepair
+)
+
+// NewReplayFilter returns a filter that treats events as corrupted when
+// they are missing, when their sequence ID repeats or goes backwards, or,
+// when failing, when the sequence skips IDs.
+func NewReplayFilter(mode ReplayFilterMode) ReplayFilter {
+	return sequenceReplayFilter{mode}
+}
+
+type sequenceReplayFilter struct {
+	mode ReplayFilterMode
+}
+
+func (srf sequenceReplayFilter) Filter(
+	events []PersistentEvent,
+) ([]PersistentEvent, error) {
+	filtered := make([]PersistentEvent, 0, len(events))
+	for _, event := range events {
+		err := srf.check(filtered, event)
+		if err == nil {
+			filtered = append(filtered, event)
+		} else if srf.mode == ReplayFilterFail {
+			return nil, err
+		}
+	}
+	return filtered, nil
+}
+
+func (srf sequenceReplayFilter) check(
+	replayed []PersistentEvent,
+	event PersistentEvent,
+) error {
+	if event.Event == nil {
+		return fmt.Errorf("missing event at sequence ID %d", event.SequenceID)
+	}
+	if len(replayed) == 0 {
+		return nil
+	}
+	previous := replayed[len(replayed)-1].SequenceID
+	if event.SequenceID <= previous {
+		return fmt.Errorf(
+			"sequence ID %d replayed after %d",
+			event.SequenceID,
+			previous,
+		)
+	}
+	if event.SequenceID != previous+1 && srf.mode == ReplayFilterFail {
+		return fmt.Errorf(
+			"sequence IDs %d to %d are missing",
+			previous+1,
+			event.SequenceID-1,
+		)
+	}
+	return nil
+}
+
+// readJournal returns the events to replay and the next sequence ID.
+func readJournal(
+	provider PersistenceProvider,
+	id string,
+	recovery Recovery,
+) ([]PersistentEvent, uint64, error) {
+	ctx := context.Background()
+	if recovery.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, recovery.Timeout)
+		defer cancel()
+	}
+
+	type journal struct {
+		events         []PersistentEvent
+		nextSequenceID uint64
+		err            error
+	}
+	done := make(chan journal, 1)
+	go func() {
+		events, nextSequenceID, err := readJournalContext(
+			ctx,
+			NewContextPersistenceProvider(provider),
+			id,
+			recovery,
+		)
+		done <- journal{events, nextSequenceID, err}
+	}()
+
+	select {
+	case result := <-done:
+		return result.events, result.nextSequenceID, result.err
+	case <-ctx.Done():
+		return nil, 0, ctx.Err()
+	}
+}
+
+func readJournalContext(
+	ctx context.Context,
+	provider ContextPersistenceProvider,
+	id string,
+	recovery Recovery,
+) ([]PersistentEvent, uint64, error) {
+	var events []PersistentEvent
+	var err error
+	if !recovery.Disabled {
+		events, err = provider.GetEventsContext(ctx, id, recovery.FromSequenceID)
+		if err != nil && err.Error() != "not found" {
+			return nil, 0, err
+		}
+	}
+	if recovery.ReplayFilter != nil {
+		events, err = recovery.ReplayFilter.Filter(events)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	replay := make([]PersistentEvent, 0, len(events))
+	for _, event := range events {
+		if recovery.ToSequenceID > 0 && event.SequenceID > recovery.ToSequenceID {
+			break
+		}
+		if recovery.MaxEvents > 0 && len(replay) == recovery.MaxEvents {
+			break
+		}
+		replay = append(replay, event)
+	}
+
+	// Deleted and skipped events are not replayed, so the next sequence ID
+	// has to come from the journal rather than the last replayed event.
+	var nextSequenceID uint64
+	maxSequenceID, err := provider.MaxSequenceIDContext(ctx, id)
+	if err == nil {
+		nextSequenceID = maxSequenceID + 1
+	} else if err.Error() != "not found" {
+		return nil, 0, err
+	}
+	return replay, nextSequenceID, nil
+}
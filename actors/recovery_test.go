@@ -0,0 +1,157 @@
+package actors_test
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/wrappers"
+	. "github.com/kphelps/actors/actors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type recoveryCompleted struct {
+	state          []string
+	lastSequenceID uint64
+}
+
+type recoveringActor struct {
+	*testPersistentActor
+}
+
+func (ra recoveringActor) RecoveryCompleted(context PersistentContext) {
+	lastSequenceID, _ := context.LastSequenceID()
+	ra.output <- recoveryCompleted{
+		state:          append([]string{}, ra.state...),
+		lastSequenceID: lastSequenceID,
+	}
+}
+
+func (ra recoveringActor) RecoveryFailed(err error) {
+	ra.output <- err
+}
+
+var _ = Describe("Recovery", func() {
+	var id string
+	var output chan interface{}
+
+	spawn := func(recovery Recovery) ActorRef {
+		return SpawnPersistentActorWithRecovery(
+			recoveringActor{&testPersistentActor{id: id, output: output}},
+			recovery,
+		)
+	}
+
+	BeforeEach(func() {
+		id = fmt.Sprintf("recovery-%d", time.Now().UnixNano())
+		output = make(chan interface{}, 10)
+		ref := spawn(Recovery{})
+		Eventually(output).Should(Receive())
+		for _, event := range []string{"a", "b", "c", "d"} {
+			ref.Send(event)
+		}
+		Expect(ref.Ask(testGetState{})).To(HaveLen(4))
+		ref.Stop()
+	})
+
+	replay := func(recovery Recovery) recoveryCompleted {
+		ref := spawn(recovery)
+		defer ref.Stop()
+		var completed interface{}
+		Eventually(output).Should(Receive(&completed))
+		return completed.(recoveryCompleted)
+	}
+
+	It("Completes after replaying every event", func() {
+		Expect(replay(Recovery{})).To(Equal(recoveryCompleted{
+			state:          []string{"a", "b", "c", "d"},
+			lastSequenceID: 3,
+		}))
+	})
+
+	It("Replays a range of sequence IDs", func() {
+		completed := replay(Recovery{FromSequenceID: 1, ToSequenceID: 2})
+		Expect(completed.state).To(Equal([]string{"b", "c"}))
+		Expect(completed.lastSequenceID).To(Equal(uint64(3)))
+	})
+
+	It("Replays at most MaxEvents", func() {
+		completed := replay(Recovery{MaxEvents: 2})
+		Expect(completed.state).To(Equal([]string{"a", "b"}))
+	})
+
+	It("Continues the sequence when disabled", func() {
+		ref := spawn(Recovery{Disabled: true})
+		defer ref.Stop()
+		Eventually(output).Should(Receive(Equal(recoveryCompleted{
+			state:          []string{},
+			lastSequenceID: 3,
+		})))
+		ref.Send("e")
+		Expect(ref.Ask(testGetState{})).To(Equal([]string{"e"}))
+		events, err := GetPersistenceProvider().GetEvents(id, 0)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(events).To(HaveLen(5))
+	})
+
+	It("Fails when it times out", func() {
+		ref := spawn(Recovery{Timeout: time.Nanosecond})
+		Eventually(output).Should(Receive(Equal(context.DeadlineExceeded)))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, err := ref.AskContext(ctx, testGetState{})
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("ReplayFilter", func() {
+	events := func(sequenceIDs ...uint64) []PersistentEvent {
+		events := make([]PersistentEvent, len(sequenceIDs))
+		for i, sequenceID := range sequenceIDs {
+			events[i] = PersistentEvent{
+				SequenceID: sequenceID,
+				Event:      &wrappers.UInt64Value{Value: sequenceID},
+			}
+		}
+		return events
+	}
+
+	It("Passes an intact sequence", func() {
+		for _, mode := range []ReplayFilterMode{ReplayFilterFail, ReplayFilterRepair} {
+			filtered, err := NewReplayFilter(mode).Filter(events(3, 4, 5))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(filtered).To(Equal(events(3, 4, 5)))
+		}
+	})
+
+	It("Fails on corrupted sequences", func() {
+		missing := events(0, 1, 2)
+		missing[1].Event = nil
+		for _, corrupted := range [][]PersistentEvent{
+			events(0, 1, 1, 2),
+			events(0, 2, 1),
+			events(0, 1, 3),
+			missing,
+		} {
+			_, err := NewReplayFilter(ReplayFilterFail).Filter(corrupted)
+			Expect(err).To(HaveOccurred())
+		}
+	})
+
+	It("Repairs corrupted sequences", func() {
+		missing := events(0, 1, 2)
+		missing[1].Event = nil
+		filter := NewReplayFilter(ReplayFilterRepair)
+
+		filtered, err := filter.Filter(events(0, 1, 1, 2, 0, 4))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(filtered).To(Equal(events(0, 1, 2, 4)))
+
+		filtered, err = filter.Filter(missing)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(filtered).To(Equal(events(0, 2)))
+	})
+})
@@ -0,0 +1,207 @@
+package actors
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// With RememberEntities on, shards record which entities are running and
+// restart them as soon as the shard starts again instead of waiting for a
+// message to arrive.
+type RememberEntitiesConfig struct {
+	Enabled bool
+	// Remembered entities are restarted BatchSize at a time, every
+	// BatchInterval, so a large shard doesn't start everything at once.
+	BatchSize     int
+	BatchInterval time.Duration
+	// The journal of a shard's entities is compacted once it holds this
+	// many more events than there are running entities. Defaults to 100.
+	CompactAfter int
+}
+
+// StopEntity stops an entity of a sharded actor. A remembered entity is
+// forgotten and won't be restarted with its shard. Custom
+// GetActorIDFromMessage and GetShardFromMessage functions have to handle it.
+type StopEntity struct {
+	ID string
+}
+
+func (se StopEntity) ActorID() string {
+	return se.ID
+}
+
+type restartEntities struct{}
+
+// Retries recovering or writing a shard's entity journal.
+type recoverEntities struct{}
+
+type flushEntities struct{}
+
+type entityStarted struct {
+	ID string `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+}
+
+func (m *entityStarted) Reset()         { *m = entityStarted{} }
+func (m *entityStarted) String() string { return proto.CompactTextString(m) }
+func (*entityStarted) ProtoMessage()    {}
+
+type entityStopped struct {
+	ID string `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+}
+
+func (m *entityStopped) Reset()         { *m = entityStopped{} }
+func (m *entityStopped) String() string { return proto.CompactTextString(m) }
+func (*entityStopped) ProtoMessage()    {}
+
+func init() {
+	proto.RegisterType((*entityStarted)(nil), "actors.EntityStarted")
+	proto.RegisterType((*entityStopped)(nil), "actors.EntityStopped")
+	defaultSerializers.RegisterJSON("actors.StopEntity", StopEntity{})
+}
+
+func (config RememberEntitiesConfig) withDefaults() RememberEntitiesConfig {
+	if config.BatchSize <= 0 {
+		config.BatchSize = 10
+	}
+	if config.BatchInterval <= 0 {
+		config.BatchInterval = 100 * time.Millisecond
+	}
+	if config.CompactAfter <= 0 {
+		config.CompactAfter = 100
+	}
+	return config
+}
+
+// entityJournal keeps a shard's started and stopped entities in the
+// persistence provider. Events are written in order, and ones that fail stay
+// pending until flush succeeds. Once the journal holds CompactAfter more
+// events than there are running entities, the running ones are written out
+// afresh and everything before them is deleted.
+type entityJournal struct {
+	persistenceID string
+	compactAfter  int
+	sequenceID    uint64
+	recovered     bool
+	running       map[string]struct{}
+	events        int
+	pending       []proto.Message
+}
+
+func newEntityJournal(
+	typeName string,
+	shardID int,
+	config RememberEntitiesConfig,
+) *entityJournal {
+	return &entityJournal{
+		persistenceID: fmt.Sprintf("sharding-entities-%s-%d", typeName, shardID),
+		compactAfter:  config.CompactAfter,
+		running:       make(map[string]struct{}),
+	}
+}
+
+// recover returns the IDs of entities that were started and not stopped,
+// counting the events still pending, which the next flush writes.
+func (ej *entityJournal) recover() ([]string, error) {
+	events, err := pp.GetEvents(ej.persistenceID, 0)
+	if err != nil && err.Error() != "not found" {
+		return nil, err
+	}
+	maxSequenceID, err := pp.MaxSequenceID(ej.persistenceID)
+	if err != nil && err.Error() != "not found" {
+		return nil, err
+	}
+	if err == nil {
+		ej.sequenceID = maxSequenceID + 1
+	}
+
+	ej.running = make(map[string]struct{})
+	for _, event := range events {
+		applyEntityEvent(ej.running, event.Event)
+	}
+	ej.events = len(events)
+	ej.recovered = true
+
+	running := make(map[string]struct{}, len(ej.running))
+	for id := range ej.running {
+		running[id] = struct{}{}
+	}
+	for _, event := range ej.pending {
+		applyEntityEvent(running, event)
+	}
+	return sortedEntityIDs(running), nil
+}
+
+func (ej *entityJournal) record(event proto.Message) error {
+	ej.pending = append(ej.pending, event)
+	return ej.flush()
+}
+
+// flush writes the pending events, which wait until the journal has been
+// recovered since only then is the next sequence ID known.
+func (ej *entityJournal) flush() error {
+	if !ej.recovered {
+		return nil
+	}
+	for len(ej.pending) > 0 {
+		err := ej.persist(ej.pending[0])
+		if err != nil {
+			return err
+		}
+		applyEntityEvent(ej.running, ej.pending[0])
+		ej.pending = ej.pending[1:]
+	}
+	if ej.events > len(ej.running)+ej.compactAfter {
+		return ej.compact()
+	}
+	return nil
+}
+
+func (ej *entityJournal) compact() error {
+	if ej.sequenceID == 0 {
+		return nil
+	}
+	compactTo := ej.sequenceID - 1
+	for _, id := range sortedEntityIDs(ej.running) {
+		err := ej.persist(&entityStarted{ID: id})
+		if err != nil {
+			return err
+		}
+	}
+	err := pp.DeleteEvents(ej.persistenceID, compactTo, PhysicalDeletion)
+	if err != nil {
+		return err
+	}
+	ej.events = len(ej.running)
+	return nil
+}
+
+func (ej *entityJournal) persist(event proto.Message) error {
+	err := pp.PersistEvent(ej.persistenceID, ej.sequenceID, event)
+	if err != nil {
+		return err
+	}
+	ej.sequenceID++
+	ej.events++
+	return nil
+}
+
+func applyEntityEvent(running map[string]struct{}, event proto.Message) {
+	switch event := event.(type) {
+	case *entityStarted:
+		running[event.ID] = struct{}{}
+	case *entityStopped:
+		delete(running, event.ID)
+	}
+}
+
+func sortedEntityIDs(running map[string]struct{}) []string {
+	ids := make([]string, 0, len(running))
+	for id := range running {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
@@ -0,0 +1,587 @@
+package actors
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+type RemoteConfig struct {
+	// Address to listen on; port 0 picks a free port.
+	ListenAddress string
+	Serializers   *SerializerRegistry
+	DialTimeout   time.Duration
+	MaxFrameSize  int
+	// Messages waiting to be written to each remote address; more are
+	// dead-lettered. Defaults to 1000.
+	OutboxSize int
+	// Receives a DeadLetter for every message that could not be delivered.
+	DeadLetters ActorRef
+}
+
+type DeadLetter struct {
+	Recipient string
+	Sender    ActorRef
+	Message   interface{}
+	Err       error
+}
+
+// RemoteSystem makes registered actors reachable from other processes at
+// "host:port/name" paths and hands out refs for actors registered on other
+// systems.
+type RemoteSystem struct {
+	sync.Mutex
+	config      RemoteConfig
+	listener    net.Listener
+	address     string
+	actors      map[string]ActorRef
+	names       map[interface{}]string
+	endpoints   map[string]*remoteEndpoint
+	connections map[string]*remoteConnection
+	inbound     map[net.Conn]struct{}
+	nextTempID  uint64
+	closed      bool
+	closing     context.Context
+	cancel      context.CancelFunc
+	done        sync.WaitGroup
+}
+
+// Every remote address gets an endpoint whose goroutine serializes, dials
+// and writes, so a slow or unreachable peer only holds up its own messages.
+type remoteEndpoint struct {
+	address   string
+	outbox    chan remoteOutbound
+	conn      *remoteConnection
+	dialErr   error
+	dialAfter time.Time
+}
+
+type remoteOutbound struct {
+	name     string
+	message  interface{}
+	sender   ActorRef
+	deadline time.Time
+}
+
+type remoteStop struct{}
+
+// Names PathOf generates for unregistered refs, which are the only actors
+// another system may stop.
+const remoteTempPrefix = "temp/"
+
+func init() {
+	defaultSerializers.RegisterJSON("actors.remoteStop", remoteStop{})
+}
+
+func NewRemoteSystem(config RemoteConfig) (*RemoteSystem, error) {
+	if config.ListenAddress == "" {
+		config.ListenAddress = "127.0.0.1:0"
+	}
+	if config.Serializers == nil {
+		config.Serializers = defaultSerializers
+	}
+	if config.DialTimeout <= 0 {
+		config.DialTimeout = 5 * time.Second
+	}
+	if config.MaxFrameSize <= 0 {
+		config.MaxFrameSize = defaultMaxFrameSize
+	}
+	if config.OutboxSize <= 0 {
+		config.OutboxSize = 1000
+	}
+	listener, err := net.Listen("tcp", config.ListenAddress)
+	if err != nil {
+		return nil, err
+	}
+	closing, cancel := context.WithCancel(context.Background())
+	rs := &RemoteSystem{
+		config:      config,
+		listener:    listener,
+		address:     listener.Addr().String(),
+		actors:      make(map[string]ActorRef),
+		names:       make(map[interface{}]string),
+		endpoints:   make(map[string]*remoteEndpoint),
+		connections: make(map[string]*remoteConnection),
+		inbound:     make(map[net.Conn]struct{}),
+		closing:     closing,
+		cancel:      cancel,
+	}
+	rs.done.Add(1)
+	go rs.accept()
+	return rs, nil
+}
+
+func (rs *RemoteSystem) Address() string {
+	return rs.address
+}
+
+// Register makes ref reachable as name. Local actors are unregistered when
+// they stop.
+func (rs *RemoteSystem) Register(name string, ref ActorRef) {
+	rs.Lock()
+	defer rs.Unlock()
+	rs.register(name, ref)
+}
+
+func (rs *RemoteSystem) register(name string, ref ActorRef) {
+	previous, found := rs.actors[name]
+	if found {
+		delete(rs.names, actorKey(previous))
+	}
+	rs.actors[name] = ref
+	rs.names[actorKey(ref)] = name
+
+	local, ok := ref.(*LocalActorRef)
+	if ok {
+		go rs.forgetOnStop(local)
+	}
+}
+
+func (rs *RemoteSystem) forgetOnStop(ref *LocalActorRef) {
+	select {
+	case <-ref.actorCell.stopped:
+		rs.forget(ref)
+	case <-rs.closing.Done():
+	}
+}
+
+// A local actor has a ref from SpawnActor and another from context.Self();
+// both name the same actor cell.
+func actorKey(ref ActorRef) interface{} {
+	local, ok := ref.(*LocalActorRef)
+	if ok {
+		return local.actorCell
+	}
+	return ref
+}
+
+func (rs *RemoteSystem) Unregister(name string) {
+	rs.Lock()
+	defer rs.Unlock()
+	ref, found := rs.actors[name]
+	if found {
+		delete(rs.actors, name)
+		delete(rs.names, actorKey(ref))
+	}
+}
+
+// ActorFor resolves a path. Paths on this system resolve to the registered
+// local ref when there is one.
+func (rs *RemoteSystem) ActorFor(path string) (ActorRef, error) {
+	address, name, err := splitActorPath(path)
+	if err != nil {
+		return nil, err
+	}
+	if address == rs.address {
+		ref := rs.lookup(name)
+		if ref != nil {
+			return ref, nil
+		}
+	}
+	return &RemoteActorRef{
+		system:  rs,
+		address: address,
+		name:    name,
+	}, nil
+}
+
+// PathOf returns the path other systems use to reach ref. Unregistered
+// local refs are registered under a generated name until they stop.
+func (rs *RemoteSystem) PathOf(ref ActorRef) string {
+	remote, ok := ref.(*RemoteActorRef)
+	if ok {
+		return remote.Path()
+	}
+	rs.Lock()
+	defer rs.Unlock()
+	name, found := rs.names[actorKey(ref)]
+	if !found {
+		rs.nextTempID++
+		name = fmt.Sprintf("%s%d", remoteTempPrefix, rs.nextTempID)
+		rs.register(name, ref)
+	}
+	return rs.address + "/" + name
+}
+
+// Close drops messages still waiting to be sent.
+func (rs *RemoteSystem) Close() error {
+	rs.Lock()
+	rs.closed = true
+	rs.cancel()
+	err := rs.listener.Close()
+	for _, conn := range rs.connections {
+		conn.close()
+	}
+	for conn := range rs.inbound {
+		conn.Close()
+	}
+	rs.Unlock()
+	rs.done.Wait()
+	return err
+}
+
+func splitActorPath(path string) (string, string, error) {
+	index := strings.Index(path, "/")
+	if index <= 0 || index == len(path)-1 {
+		return "", "", fmt.Errorf("invalid actor path: %s", path)
+	}
+	return path[:index], path[index+1:], nil
+}
+
+func (rs *RemoteSystem) lookup(name string) ActorRef {
+	rs.Lock()
+	defer rs.Unlock()
+	return rs.actors[name]
+}
+
+func (rs *RemoteSystem) forget(ref ActorRef) {
+	rs.Lock()
+	defer rs.Unlock()
+	key := actorKey(ref)
+	name, found := rs.names[key]
+	if found {
+		delete(rs.actors, name)
+		delete(rs.names, key)
+	}
+}
+
+// send only queues messages for other systems; their endpoint writes them.
+// Only ctx's deadline reaches another system, not its cancellation.
+func (rs *RemoteSystem) send(
+	ctx context.Context,
+	address string,
+	name string,
+	message interface{},
+	sender ActorRef,
+) {
+	recipient := address + "/" + name
+	if address == rs.address {
+		rs.deliverLocal(ctx, recipient, name, message, sender)
+		return
+	}
+
+	endpoint, err := rs.endpoint(address)
+	if err != nil {
+		rs.deadLetter(recipient, message, sender, err)
+		return
+	}
+	deadline, _ := ctx.Deadline()
+	select {
+	case endpoint.outbox <- remoteOutbound{name, message, sender, deadline}:
+	default:
+		rs.deadLetter(recipient, message, sender, errors.New("outbox full"))
+	}
+}
+
+func (rs *RemoteSystem) endpoint(address string) (*remoteEndpoint, error) {
+	rs.Lock()
+	defer rs.Unlock()
+	if rs.closed {
+		return nil, errors.New("remote system closed")
+	}
+	endpoint, found := rs.endpoints[address]
+	if !found {
+		endpoint = &remoteEndpoint{
+			address: address,
+			outbox:  make(chan remoteOutbound, rs.config.OutboxSize),
+		}
+		rs.endpoints[address] = endpoint
+		rs.done.Add(1)
+		go rs.runEndpoint(endpoint)
+	}
+	return endpoint, nil
+}
+
+func (rs *RemoteSystem) runEndpoint(endpoint *remoteEndpoint) {
+	defer rs.done.Done()
+	for {
+		select {
+		case outbound := <-endpoint.outbox:
+			rs.write(endpoint, outbound)
+		case <-rs.closing.Done():
+			return
+		}
+	}
+}
+
+func (rs *RemoteSystem) write(endpoint *remoteEndpoint, outbound remoteOutbound) {
+	recipient := endpoint.address + "/" + outbound.name
+	manifest, payload, err := rs.config.Serializers.Serialize(outbound.message)
+	if err != nil {
+		rs.deadLetter(recipient, outbound.message, outbound.sender, err)
+		return
+	}
+	envelope := remoteEnvelope{
+		recipient: outbound.name,
+		manifest:  manifest,
+		payload:   payload,
+	}
+	if !outbound.deadline.IsZero() {
+		envelope.deadline = outbound.deadline.UnixNano()
+	}
+	if outbound.sender != nil {
+		envelope.sender = rs.PathOf(outbound.sender)
+	}
+
+	conn, err := rs.connection(endpoint)
+	if err == nil {
+		err = conn.send(envelope)
+		if err != nil {
+			rs.dropConnection(endpoint)
+		}
+	}
+	if err != nil {
+		rs.deadLetter(recipient, outbound.message, outbound.sender, err)
+	}
+}
+
+func (rs *RemoteSystem) deliverLocal(
+	ctx context.Context,
+	recipient string,
+	name string,
+	message interface{},
+	sender ActorRef,
+) {
+	_, stop := message.(remoteStop)
+	ref := rs.lookup(name)
+	if ref == nil {
+		rs.deadLetter(recipient, message, sender, errors.New("not found"))
+	} else if stop && !strings.HasPrefix(name, remoteTempPrefix) {
+		rs.deadLetter(
+			recipient,
+			message,
+			sender,
+			errors.New("only temporary registrations can be stopped remotely"),
+		)
+	} else if stop {
+		rs.forget(ref)
+		ref.Stop()
+	} else {
+		ref.SendFromContext(ctx, message, sender)
+	}
+}
+
+func (rs *RemoteSystem) deadLetter(
+	recipient string,
+	message interface{},
+	sender ActorRef,
+	err error,
+) {
+	if rs.config.DeadLetters == nil {
+		return
+	}
+	rs.config.DeadLetters.Send(DeadLetter{
+		Recipient: recipient,
+		Sender:    sender,
+		Message:   message,
+		Err:       err,
+	})
+}
+
+// connection dials from the endpoint's goroutine without holding the
+// system's lock. After a failed dial, messages are dead-lettered without
+// dialing again until DialTimeout has passed.
+func (rs *RemoteSystem) connection(
+	endpoint *remoteEndpoint,
+) (*remoteConnection, error) {
+	if endpoint.conn != nil {
+		return endpoint.conn, nil
+	}
+	if time.Now().Before(endpoint.dialAfter) {
+		return nil, endpoint.dialErr
+	}
+	conn, err := dialRemote(
+		rs.closing,
+		endpoint.address,
+		rs.config.DialTimeout,
+	)
+	if err != nil {
+		endpoint.dialErr = err
+		endpoint.dialAfter = time.Now().Add(rs.config.DialTimeout)
+		return nil, err
+	}
+
+	rs.Lock()
+	defer rs.Unlock()
+	if rs.closed {
+		conn.close()
+		return nil, errors.New("remote system closed")
+	}
+	rs.connections[endpoint.address] = conn
+	endpoint.conn = conn
+	return conn, nil
+}
+
+// A broken connection is redialed by the next send.
+func (rs *RemoteSystem) dropConnection(endpoint *remoteEndpoint) {
+	rs.Lock()
+	defer rs.Unlock()
+	delete(rs.connections, endpoint.address)
+	endpoint.conn.close()
+	endpoint.conn = nil
+}
+
+func (rs *RemoteSystem) accept() {
+	defer rs.done.Done()
+	for {
+		conn, err := rs.listener.Accept()
+		if err != nil {
+			return
+		}
+		rs.Lock()
+		if rs.closed {
+			rs.Unlock()
+			conn.Close()
+			return
+		}
+		rs.inbound[conn] = struct{}{}
+		rs.done.Add(1)
+		rs.Unlock()
+		go rs.read(conn)
+	}
+}
+
+func (rs *RemoteSystem) read(conn net.Conn) {
+	defer rs.done.Done()
+	defer func() {
+		rs.Lock()
+		delete(rs.inbound, conn)
+		rs.Unlock()
+		conn.Close()
+	}()
+
+	reader := bufio.NewReader(conn)
+	for {
+		frame, err := readFrame(reader, rs.config.MaxFrameSize)
+		if err != nil {
+			return
+		}
+		envelope, err := decodeRemoteEnvelope(frame)
+		if err != nil {
+			return
+		}
+		rs.receive(envelope)
+	}
+}
+
+func (rs *RemoteSystem) receive(envelope remoteEnvelope) {
+	recipient := rs.address + "/" + envelope.recipient
+	var sender ActorRef
+	if envelope.sender != "" {
+		sender, _ = rs.ActorFor(envelope.sender)
+	}
+	message, err := rs.config.Serializers.Deserialize(
+		envelope.manifest,
+		envelope.payload,
+	)
+	if err != nil {
+		rs.deadLetter(recipient, nil, sender, err)
+		return
+	}
+	ctx := context.Background()
+	if envelope.deadline != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, time.Unix(0, envelope.deadline))
+		// The context's own timer releases it at the deadline.
+		_ = cancel
+	}
+	rs.deliverLocal(ctx, recipient, envelope.recipient, message, sender)
+}
+
+// RemoteActorRef reaches an actor registered on another RemoteSystem.
+// Messages and senders cross the wire but context values do not; the
+// receiver sees context.Background().
+type RemoteActorRef struct {
+	system  *RemoteSystem
+	address string
+	name    string
+}
+
+func (rar *RemoteActorRef) Path() string {
+	return rar.address + "/" + rar.name
+}
+
+func (rar *RemoteActorRef) Send(message interface{}) {
+	rar.SendFrom(message, nil)
+}
+
+func (rar *RemoteActorRef) SendFrom(message interface{}, sender ActorRef) {
+	rar.SendFromContext(context.Background(), message, sender)
+}
+
+func (rar *RemoteActorRef) SendContext(
+	ctx context.Context,
+	message interface{},
+) {
+	rar.SendFromContext(ctx, message, nil)
+}
+
+// The receiving actor's context carries ctx's deadline but is not canceled
+// with ctx, since cancellation does not cross the wire.
+func (rar *RemoteActorRef) SendFromContext(
+	ctx context.Context,
+	message interface{},
+	sender ActorRef,
+) {
+	rar.system.send(ctx, rar.address, rar.name, message, sender)
+}
+
+func (rar *RemoteActorRef) Ask(message interface{}) interface{} {
+	return rar.AskWithTimeout(message, 3*time.Second)
+}
+
+func (rar *RemoteActorRef) AskWithTimeout(
+	message interface{},
+	timeout time.Duration,
+) interface{} {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	response, err := rar.AskContext(ctx, message)
+	if err != nil {
+		panic("Ask timed out")
+	}
+	return response
+}
+
+func (rar *RemoteActorRef) AskContext(
+	ctx context.Context,
+	message interface{},
+) (interface{}, error) {
+	receiver := make(chan interface{}, 1)
+	askActor := SpawnActor(&ChannelActor{receiver})
+	defer askActor.Stop()
+	defer rar.system.forget(askActor)
+
+	rar.SendFromContext(ctx, message, askActor)
+
+	select {
+	case response := <-receiver:
+		return response, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Stop stops a remote actor that its system registered through PathOf, and
+// unregisters it. Actors registered by name are only stopped by their own
+// system, so stopping one remotely dead-letters the request.
+func (rar *RemoteActorRef) Stop() {
+	rar.system.send(
+		context.Background(),
+		rar.address,
+		rar.name,
+		remoteStop{},
+		nil,
+	)
+}
+
+// StopAndWait can't wait on another node, so it is the same as Stop.
+func (rar *RemoteActorRef) StopAndWait() {
+	rar.Stop()
+}
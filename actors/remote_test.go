@@ -0,0 +1,161 @@
+package actors_test
+
+import (
+	"context"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/wrappers"
+	. "github.com/kphelps/actors/actors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type remoteGreeting struct {
+	Name  string
+	Count int
+}
+
+func init() {
+	DefaultSerializers().RegisterJSON("actors_test.remoteGreeting", remoteGreeting{})
+}
+
+var _ = Describe("Remote actors", func() {
+	var systemA *RemoteSystem
+	var systemB *RemoteSystem
+	var deadLetters chan interface{}
+
+	startSystem := func(config RemoteConfig) *RemoteSystem {
+		system, err := NewRemoteSystem(config)
+		Expect(err).NotTo(HaveOccurred())
+		return system
+	}
+
+	remoteRef := func(name string) ActorRef {
+		ref, err := systemA.ActorFor(systemB.Address() + "/" + name)
+		Expect(err).NotTo(HaveOccurred())
+		return ref
+	}
+
+	BeforeEach(func() {
+		deadLetters = make(chan interface{}, 10)
+		systemA = startSystem(RemoteConfig{
+			DeadLetters: SpawnActor(&ChannelActor{deadLetters}),
+		})
+		systemB = startSystem(RemoteConfig{})
+		systemB.Register("echo", SpawnActor(NewFunctionActor(func(context ActorContext) {
+			context.Reply(context.Message())
+		})))
+	})
+
+	AfterEach(func() {
+		Expect(systemA.Close()).To(Succeed())
+		Expect(systemB.Close()).To(Succeed())
+	})
+
+	It("Asks a remote actor", func() {
+		echo := remoteRef("echo")
+		greeting := remoteGreeting{Name: "a", Count: 2}
+		Expect(echo.Ask(greeting)).To(Equal(greeting))
+		Expect(echo.Ask(&wrappers.StringValue{Value: "b"})).To(
+			Equal(&wrappers.StringValue{Value: "b"}),
+		)
+	})
+
+	It("Sends the sender as an address", func() {
+		received := make(chan interface{}, 1)
+		replies := SpawnActor(&ChannelActor{received})
+		remoteRef("echo").SendFrom(remoteGreeting{Name: "c"}, replies)
+		Eventually(received).Should(Receive(Equal(remoteGreeting{Name: "c"})))
+		Expect(systemA.PathOf(replies)).To(HavePrefix(systemA.Address() + "/"))
+	})
+
+	It("Resolves local paths to the local ref", func() {
+		echo, err := systemB.ActorFor(systemB.Address() + "/echo")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(echo).To(BeAssignableToTypeOf(&LocalActorRef{}))
+	})
+
+	It("Sends messages for unknown types to dead letters", func() {
+		type unregistered struct{}
+		remoteRef("echo").Send(unregistered{})
+		Eventually(deadLetters).Should(Receive(BeAssignableToTypeOf(DeadLetter{})))
+	})
+
+	It("Sends messages for unreachable systems to dead letters", func() {
+		address := systemB.Address()
+		Expect(systemB.Close()).To(Succeed())
+		systemB = startSystem(RemoteConfig{})
+		ref, err := systemA.ActorFor(address + "/echo")
+		Expect(err).NotTo(HaveOccurred())
+		ref.Send(remoteGreeting{})
+		var letter interface{}
+		Eventually(deadLetters).Should(Receive(&letter))
+		Expect(letter.(DeadLetter).Recipient).To(Equal(address + "/echo"))
+	})
+
+	It("Stops and unregisters a temporary remote actor", func() {
+		path := systemB.PathOf(SpawnActor(&ChannelActor{make(chan interface{}, 1)}))
+		ref, err := systemA.ActorFor(path)
+		Expect(err).NotTo(HaveOccurred())
+		ref.Stop()
+		Eventually(func() ActorRef {
+			ref, _ := systemB.ActorFor(path)
+			return ref
+		}).Should(BeAssignableToTypeOf(&RemoteActorRef{}))
+	})
+
+	It("Does not stop a named actor from another system", func() {
+		echo := remoteRef("echo")
+		echo.Stop()
+		greeting := remoteGreeting{Name: "e"}
+		Expect(echo.Ask(greeting)).To(Equal(greeting))
+	})
+
+	It("Carries the deadline of a message", func() {
+		systemB.Register("deadline", SpawnActor(NewFunctionActor(func(context ActorContext) {
+			deadline, _ := context.Context().Deadline()
+			context.Reply(remoteGreeting{Count: int(deadline.UnixNano())})
+		})))
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		deadline, _ := ctx.Deadline()
+		reply, err := remoteRef("deadline").AskContext(ctx, remoteGreeting{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(reply).To(Equal(remoteGreeting{Count: int(deadline.UnixNano())}))
+	})
+
+	It("Gives each local actor one path until it stops", func() {
+		var self ActorRef
+		ref := SpawnActor(NewFunctionActor(func(context ActorContext) {
+			self = context.Self()
+			context.Reply(nil)
+		}))
+		ref.Ask(nil)
+		path := systemA.PathOf(ref)
+		Expect(systemA.PathOf(self)).To(Equal(path))
+
+		ref.Stop()
+		Eventually(func() ActorRef {
+			ref, _ := systemA.ActorFor(path)
+			return ref
+		}).Should(BeAssignableToTypeOf(&RemoteActorRef{}))
+	})
+
+	It("Doesn't hold up other sends while dialing", func() {
+		// A non-routable address, so the dial hangs until DialTimeout.
+		unreachable, err := systemA.ActorFor("10.255.255.1:9/echo")
+		Expect(err).NotTo(HaveOccurred())
+		unreachable.Send(remoteGreeting{})
+
+		greeting := remoteGreeting{Name: "d"}
+		Expect(remoteRef("echo").AskWithTimeout(greeting, time.Second)).To(
+			Equal(greeting),
+		)
+	})
+
+	It("Rejects invalid paths", func() {
+		_, err := systemA.ActorFor("no-name")
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,29 @@
+package actors
+
+import "time"
+
+type Cancellable interface {
+	// Cancel reports whether the message was stopped before being sent.
+	Cancel() bool
+}
+
+type scheduledMessage struct {
+	timer *time.Timer
+}
+
+func (sm *scheduledMessage) Cancel() bool {
+	return sm.timer.Stop()
+}
+
+// ScheduleOnce sends message to target after delay without blocking the
+// caller, which lets an actor wait on itself while staying responsive.
+func ScheduleOnce(
+	delay time.Duration,
+	target ActorRef,
+	message interface{},
+) Cancellable {
+	timer := time.AfterFunc(delay, func() {
+		target.Send(message)
+	})
+	return &scheduledMessage{timer}
+}
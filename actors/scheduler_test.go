@@ -0,0 +1,33 @@
+package actors_test
+
+import (
+	"time"
+
+	. "github.com/kphelps/actors/actors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ScheduleOnce", func() {
+	var received chan interface{}
+	var ref ActorRef
+
+	BeforeEach(func() {
+		received = make(chan interface{}, 1)
+		ref = SpawnActor(&ChannelActor{received})
+	})
+
+	It("Sends the message after the delay", func() {
+		start := time.Now()
+		ScheduleOnce(20*time.Millisecond, ref, "tick")
+		Eventually(received).Should(Receive(Equal("tick")))
+		Expect(time.Since(start)).To(BeNumerically(">=", 20*time.Millisecond))
+	})
+
+	It("Can be cancelled", func() {
+		scheduled := ScheduleOnce(20*time.Millisecond, ref, "tick")
+		Expect(scheduled.Cancel()).To(BeTrue())
+		Consistently(received).ShouldNot(Receive())
+	})
+})
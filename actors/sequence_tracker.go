@@ -1,6 +1,8 @@
 package actors
 
 import (
+	"context"
+
 	"github.com/gocql/gocql"
 	"github.com/scylladb/gocqlx"
 	"github.com/scylladb/gocqlx/qb"
@@ -9,6 +11,7 @@ import (
 type SequenceTracker interface {
 	UpdateSequence(sequenceName string, sequenceID uint64) BatchableQuery
 	GetSequenceID(sequenceName string) (uint64, error)
+	GetSequenceIDContext(ctx context.Context, sequenceName string) (uint64, error)
 }
 
 type sequenceTrackerImpl struct {
@@ -37,13 +40,21 @@ func (sti *sequenceTrackerImpl) UpdateSequence(
 
 func (sti *sequenceTrackerImpl) GetSequenceID(
 	sequenceName string,
+) (uint64, error) {
+	return sti.GetSequenceIDContext(context.Background(), sequenceName)
+}
+
+func (sti *sequenceTrackerImpl) GetSequenceIDContext(
+	ctx context.Context,
+	sequenceName string,
 ) (uint64, error) {
 	stmt, names := qb.Select("sequence_ids").
 		Columns("sequence_id").
 		Where(qb.Eq("name")).
 		ToCql()
 
-	q := gocqlx.Query(sti.cassandra.Query(stmt), names).BindMap(qb.M{
+	query := sti.cassandra.Query(stmt).WithContext(ctx)
+	q := gocqlx.Query(query, names).BindMap(qb.M{
 		"name": sequenceName,
 	})
 	var sequenceID uint64
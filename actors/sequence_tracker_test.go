@@ -1,6 +1,8 @@
 package actors_test
 
 import (
+	"context"
+
 	. "github.com/kphelps/actors/actors"
 
 	. "github.com/onsi/ginkgo"
@@ -48,4 +50,13 @@ var _ = Describe("SequenceTracker", func() {
 			})
 		})
 	})
+
+	Context("Getting a sequence with a cancelled context", func() {
+		It("Fails", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			_, err := st.GetSequenceIDContext(ctx, "name")
+			Expect(err).To(HaveOccurred())
+		})
+	})
 })
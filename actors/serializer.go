@@ -0,0 +1,134 @@
+package actors
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+)
+
+const protoManifestPrefix = "proto:"
+
+type Serializer interface {
+	Serialize(message interface{}) ([]byte, error)
+	Deserialize(manifest string, data []byte) (interface{}, error)
+}
+
+// SerializerRegistry picks a serializer for a message by its Go type and
+// records a manifest so the other side knows how to read it back. Protobuf
+// messages need no registration.
+type SerializerRegistry struct {
+	sync.RWMutex
+	manifests   map[reflect.Type]string
+	serializers map[string]Serializer
+}
+
+var defaultSerializers = NewSerializerRegistry()
+
+func NewSerializerRegistry() *SerializerRegistry {
+	return &SerializerRegistry{
+		manifests:   make(map[reflect.Type]string),
+		serializers: make(map[string]Serializer),
+	}
+}
+
+// DefaultSerializers is used by remoting unless configured otherwise, and is
+// consulted by every other registry for types they don't know.
+func DefaultSerializers() *SerializerRegistry {
+	return defaultSerializers
+}
+
+func (sr *SerializerRegistry) Register(
+	manifest string,
+	example interface{},
+	serializer Serializer,
+) {
+	sr.Lock()
+	defer sr.Unlock()
+	sr.manifests[reflect.TypeOf(example)] = manifest
+	sr.serializers[manifest] = serializer
+}
+
+// RegisterJSON registers example's type to be sent as JSON, so only its
+// exported fields survive the trip.
+func (sr *SerializerRegistry) RegisterJSON(manifest string, example interface{}) {
+	sr.Register(manifest, example, &jsonSerializer{reflect.TypeOf(example)})
+}
+
+func (sr *SerializerRegistry) Serialize(
+	message interface{},
+) (string, []byte, error) {
+	manifest, serializer, found := sr.lookupType(reflect.TypeOf(message))
+	if found {
+		data, err := serializer.Serialize(message)
+		return manifest, data, err
+	}
+	protoMessage, ok := message.(proto.Message)
+	if ok {
+		data, err := serializeEvent(protoMessage)
+		return protoManifestPrefix + proto.MessageName(protoMessage), data, err
+	}
+	return "", nil, fmt.Errorf("no serializer for %T", message)
+}
+
+func (sr *SerializerRegistry) Deserialize(
+	manifest string,
+	data []byte,
+) (interface{}, error) {
+	if strings.HasPrefix(manifest, protoManifestPrefix) {
+		return deserializeEvent(strings.TrimPrefix(manifest, protoManifestPrefix), data)
+	}
+	serializer, found := sr.lookupManifest(manifest)
+	if !found {
+		return nil, fmt.Errorf("unknown manifest: %s", manifest)
+	}
+	return serializer.Deserialize(manifest, data)
+}
+
+func (sr *SerializerRegistry) lookupType(
+	messageType reflect.Type,
+) (string, Serializer, bool) {
+	sr.RLock()
+	manifest, found := sr.manifests[messageType]
+	serializer := sr.serializers[manifest]
+	sr.RUnlock()
+	if !found && sr != defaultSerializers {
+		return defaultSerializers.lookupType(messageType)
+	}
+	return manifest, serializer, found
+}
+
+func (sr *SerializerRegistry) lookupManifest(manifest string) (Serializer, bool) {
+	sr.RLock()
+	serializer, found := sr.serializers[manifest]
+	sr.RUnlock()
+	if !found && sr != defaultSerializers {
+		return defaultSerializers.lookupManifest(manifest)
+	}
+	return serializer, found
+}
+
+type jsonSerializer struct {
+	messageType reflect.Type
+}
+
+func (js *jsonSerializer) Serialize(message interface{}) ([]byte, error) {
+	return json.Marshal(message)
+}
+
+func (js *jsonSerializer) Deserialize(
+	manifest string,
+	data []byte,
+) (interface{}, error) {
+	if js.messageType.Kind() == reflect.Ptr {
+		value := reflect.New(js.messageType.Elem())
+		err := json.Unmarshal(data, value.Interface())
+		return value.Interface(), err
+	}
+	value := reflect.New(js.messageType)
+	err := json.Unmarshal(data, value.Interface())
+	return value.Elem().Interface(), err
+}
@@ -0,0 +1,447 @@
+package actors
+
+import (
+	"sort"
+
+	"github.com/golang/protobuf/proto"
+)
+
+type shardingRebalance struct{}
+
+// Ends whichever phase of hand off id was in when it was scheduled.
+type shardingHandOffTimeout struct {
+	Shard    int
+	ID       int
+	Stopping bool
+}
+
+type shardHandOff struct {
+	id int
+	// Regions that still have to stop routing to the shard.
+	awaiting map[string]struct{}
+	// Regions waiting to hear the shard's new home.
+	requesters map[string]struct{}
+	stopping   bool
+}
+
+type shardCoordinator struct {
+	config      ClusterShardingConfig
+	pp          PersistenceProvider
+	sequenceID  uint64
+	members     map[string]MemberStatus
+	regions     map[string]struct{}
+	homes       map[int]string
+	handOffs    map[int]*shardHandOff
+	handOffIDs  int
+	rebalancing bool
+}
+
+func newShardCoordinator(config ClusterShardingConfig) PersistentActor {
+	return &shardCoordinator{
+		config:   config,
+		pp:       GetPersistenceProvider(),
+		members:  make(map[string]MemberStatus),
+		regions:  make(map[string]struct{}),
+		homes:    make(map[int]string),
+		handOffs: make(map[int]*shardHandOff),
+	}
+}
+
+func (sc *shardCoordinator) PersistenceID() string {
+	return "sharding-coordinator-" + sc.config.TypeName
+}
+
+func (sc *shardCoordinator) HandleRecover(event proto.Message) {
+	sc.apply(event)
+}
+
+func (sc *shardCoordinator) RecoveryCompleted(context PersistentContext) {
+	last, found := context.LastSequenceID()
+	if found {
+		sc.sequenceID = last + 1
+	}
+}
+
+// Events are written by persist rather than the context, so there are no
+// live events.
+func (sc *shardCoordinator) HandleEvent(event proto.Message) {}
+
+// persist applies event straight away so that later allocations in the
+// same Receive see it. A stale coordinator that is still running during a
+// fail over gets a sequence conflict here; it catches up with the journal
+// and the caller gives up on the change, to be retried.
+func (sc *shardCoordinator) persist(event proto.Message) error {
+	err := sc.pp.PersistEvent(sc.PersistenceID(), sc.sequenceID, event)
+	if err != nil {
+		sc.catchUp()
+		return err
+	}
+	sc.sequenceID++
+	sc.apply(event)
+	return nil
+}
+
+// catchUp applies the events written since the coordinator last wrote. A
+// failure here shows up as another failed persist, which tries again.
+func (sc *shardCoordinator) catchUp() {
+	events, err := sc.pp.GetEvents(sc.PersistenceID(), sc.sequenceID)
+	if err != nil {
+		return
+	}
+	for _, event := range events {
+		sc.apply(event.Event)
+	}
+	maxSequenceID, err := sc.pp.MaxSequenceID(sc.PersistenceID())
+	if err == nil && maxSequenceID >= sc.sequenceID {
+		sc.sequenceID = maxSequenceID + 1
+	}
+}
+
+func (sc *shardCoordinator) apply(event proto.Message) {
+	switch event := event.(type) {
+	case *shardHomeAllocated:
+		sc.homes[int(event.Shard)] = event.Region
+	case *shardHomeDeallocated:
+		delete(sc.homes, int(event.Shard))
+	}
+}
+
+func (sc *shardCoordinator) Receive(context PersistentContext) {
+	switch message := context.Message().(type) {
+	case CurrentClusterState:
+		for _, member := range message.Members {
+			sc.members[member.Address] = member.Status
+		}
+		for _, region := range sc.allocatedRegions() {
+			status, found := sc.members[region]
+			if !found || status >= MemberStatusDown {
+				sc.regionGone(context, region)
+			} else if status >= MemberStatusLeaving {
+				sc.handOffRegion(context, region)
+			}
+		}
+		if !sc.rebalancing {
+			sc.rebalancing = true
+			ScheduleOnce(sc.config.RebalanceInterval, context.Self(), shardingRebalance{})
+		}
+	case MemberJoined, MemberUp:
+		member := eventMember(message)
+		sc.members[member.Address] = member.Status
+	case MemberLeft, MemberExited:
+		member := eventMember(message)
+		sc.members[member.Address] = member.Status
+		sc.handOffRegion(context, member.Address)
+	case MemberDowned, MemberRemoved:
+		sc.regionGone(context, eventMember(message).Address)
+	case shardingRegister:
+		_, known := sc.regions[message.Region]
+		sc.regions[message.Region] = struct{}{}
+		if !known && sc.config.RememberEntities.Enabled {
+			sc.startShards(message.Region)
+		}
+	case shardingGetHome:
+		sc.getHome(context, message)
+	case shardingBeginHandOffAck:
+		handOff, found := sc.handOffs[message.Shard]
+		if found {
+			delete(handOff.awaiting, message.Region)
+			sc.continueHandOff(context, message.Shard)
+		}
+	case shardingShardStopped:
+		_, found := sc.handOffs[message.Shard]
+		if found {
+			sc.finishHandOff(context, message.Shard)
+		}
+	case shardingHandOffTimeout:
+		sc.handOffTimedOut(context, message)
+	case shardingRebalance:
+		sc.rebalance(context)
+		ScheduleOnce(sc.config.RebalanceInterval, context.Self(), shardingRebalance{})
+	}
+}
+
+func (sc *shardCoordinator) getHome(
+	context PersistentContext,
+	message shardingGetHome,
+) {
+	handOff, found := sc.handOffs[message.Shard]
+	if found {
+		handOff.requesters[message.Region] = struct{}{}
+		return
+	}
+	home, found := sc.homes[message.Shard]
+	if !found {
+		home = sc.allocate(message.Shard)
+		if home == "" {
+			// The region asks again until some region is available.
+			return
+		}
+	}
+	sc.sendToRegion(message.Region, shardingHome{
+		Shard:  message.Shard,
+		Region: home,
+	})
+}
+
+// allocate gives shard to the region with the fewest shards.
+func (sc *shardCoordinator) allocate(shard int) string {
+	loads := sc.loads(shard)
+	region := ""
+	for _, candidate := range sortedKeys(loads) {
+		if region == "" || loads[candidate] < loads[region] {
+			region = candidate
+		}
+	}
+	if region == "" {
+		return ""
+	}
+	err := sc.persist(&shardHomeAllocated{
+		Shard:  int32(shard),
+		Region: region,
+	})
+	if err != nil {
+		// Regions ask again for a home they did not get.
+		return ""
+	}
+	return region
+}
+
+// loads counts the shards of every region that can take new ones, leaving
+// out the shard being placed.
+func (sc *shardCoordinator) loads(except int) map[string]int {
+	loads := make(map[string]int)
+	for region := range sc.regions {
+		if sc.members[region] == MemberStatusUp {
+			loads[region] = 0
+		}
+	}
+	for shard, region := range sc.homes {
+		_, available := loads[region]
+		if available && shard != except {
+			loads[region]++
+		}
+	}
+	return loads
+}
+
+func (sc *shardCoordinator) rebalance(context PersistentContext) {
+	if len(sc.handOffs) > 0 {
+		return
+	}
+	loads := sc.loads(-1)
+	busiest, idlest := "", ""
+	for _, region := range sortedKeys(loads) {
+		if busiest == "" || loads[region] > loads[busiest] {
+			busiest = region
+		}
+		if idlest == "" || loads[region] < loads[idlest] {
+			idlest = region
+		}
+	}
+	if busiest == "" || loads[busiest]-loads[idlest] <= sc.config.RebalanceThreshold {
+		return
+	}
+	for _, shard := range sc.sortedShards() {
+		if sc.homes[shard] == busiest {
+			sc.startHandOff(context, shard)
+			return
+		}
+	}
+}
+
+func (sc *shardCoordinator) handOffRegion(context PersistentContext, region string) {
+	for _, shard := range sc.sortedShards() {
+		_, handingOff := sc.handOffs[shard]
+		if sc.homes[shard] == region && !handingOff {
+			sc.startHandOff(context, shard)
+		}
+	}
+}
+
+// A hand off first has every region stop routing to the shard and buffer
+// its messages instead, then has the owner stop the shard's entities, and
+// only then gives the shard a new home.
+func (sc *shardCoordinator) startHandOff(context PersistentContext, shard int) {
+	sc.handOffIDs++
+	handOff := &shardHandOff{
+		id:         sc.handOffIDs,
+		awaiting:   make(map[string]struct{}),
+		requesters: make(map[string]struct{}),
+	}
+	for region := range sc.regions {
+		handOff.awaiting[region] = struct{}{}
+		sc.sendToRegion(region, shardingBeginHandOff{shard})
+	}
+	sc.handOffs[shard] = handOff
+	sc.scheduleHandOffTimeout(context, shard)
+	sc.continueHandOff(context, shard)
+}
+
+func (sc *shardCoordinator) continueHandOff(context PersistentContext, shard int) {
+	handOff := sc.handOffs[shard]
+	if len(handOff.awaiting) > 0 || handOff.stopping {
+		return
+	}
+	handOff.stopping = true
+	owner := sc.homes[shard]
+	_, alive := sc.regions[owner]
+	if alive {
+		sc.sendToRegion(owner, shardingHandOff{shard})
+		sc.scheduleHandOffTimeout(context, shard)
+	} else {
+		sc.finishHandOff(context, shard)
+	}
+}
+
+func (sc *shardCoordinator) scheduleHandOffTimeout(context PersistentContext, shard int) {
+	handOff := sc.handOffs[shard]
+	ScheduleOnce(sc.config.HandOffTimeout, context.Self(), shardingHandOffTimeout{
+		Shard:    shard,
+		ID:       handOff.id,
+		Stopping: handOff.stopping,
+	})
+}
+
+// A region that never acknowledges a hand off, or an owner that never
+// reports its shard stopped, is unreachable without having been downed. The
+// hand off goes ahead without it rather than holding up the shard forever.
+func (sc *shardCoordinator) handOffTimedOut(
+	context PersistentContext,
+	message shardingHandOffTimeout,
+) {
+	handOff, found := sc.handOffs[message.Shard]
+	if !found || handOff.id != message.ID || handOff.stopping != message.Stopping {
+		return
+	}
+	if handOff.stopping {
+		sc.finishHandOff(context, message.Shard)
+		return
+	}
+	handOff.awaiting = make(map[string]struct{})
+	sc.continueHandOff(context, message.Shard)
+}
+
+func (sc *shardCoordinator) finishHandOff(context PersistentContext, shard int) {
+	handOff := sc.handOffs[shard]
+	err := sc.persist(&shardHomeDeallocated{Shard: int32(shard)})
+	if err != nil {
+		sc.retryFinishHandOff(context, shard, handOff)
+		return
+	}
+	delete(sc.handOffs, shard)
+	remember := sc.config.RememberEntities.Enabled
+	if len(handOff.requesters) == 0 && !remember {
+		return
+	}
+	home := sc.allocate(shard)
+	if home == "" {
+		return
+	}
+	if remember {
+		handOff.requesters[home] = struct{}{}
+	}
+	for region := range handOff.requesters {
+		sc.sendToRegion(region, shardingHome{Shard: shard, Region: home})
+	}
+}
+
+// retryFinishHandOff holds a shard whose deallocation could not be
+// persisted in a hand off that only has the deallocation left, which its
+// timeout tries again. Regions asking for the shard wait for it meanwhile.
+func (sc *shardCoordinator) retryFinishHandOff(
+	context PersistentContext,
+	shard int,
+	handOff *shardHandOff,
+) {
+	handOff.stopping = true
+	sc.handOffs[shard] = handOff
+	sc.scheduleHandOffTimeout(context, shard)
+}
+
+func (sc *shardCoordinator) regionGone(context PersistentContext, region string) {
+	delete(sc.members, region)
+	delete(sc.regions, region)
+	for _, shard := range sc.sortedShards() {
+		if sc.homes[shard] != region {
+			continue
+		}
+		handOff, found := sc.handOffs[shard]
+		if !found {
+			err := sc.persist(&shardHomeDeallocated{Shard: int32(shard)})
+			if err != nil {
+				sc.handOffIDs++
+				sc.retryFinishHandOff(context, shard, &shardHandOff{
+					id:         sc.handOffIDs,
+					awaiting:   make(map[string]struct{}),
+					requesters: make(map[string]struct{}),
+				})
+				continue
+			}
+			sc.rememberShard(context, shard)
+		} else if handOff.stopping {
+			sc.finishHandOff(context, shard)
+		}
+	}
+	for shard, handOff := range sc.handOffs {
+		delete(handOff.awaiting, region)
+		sc.continueHandOff(context, shard)
+	}
+}
+
+// rememberShard gives a shard that lost its home a new one straight away
+// so that its remembered entities are restarted.
+func (sc *shardCoordinator) rememberShard(context PersistentContext, shard int) {
+	if !sc.config.RememberEntities.Enabled {
+		return
+	}
+	home := sc.allocate(shard)
+	if home != "" {
+		sc.sendToRegion(home, shardingHome{Shard: shard, Region: home})
+	}
+}
+
+// startShards tells a region about the shards it owns, which happens when a
+// new coordinator has recovered them from the journal.
+func (sc *shardCoordinator) startShards(region string) {
+	for _, shard := range sc.sortedShards() {
+		_, handingOff := sc.handOffs[shard]
+		if sc.homes[shard] == region && !handingOff {
+			sc.sendToRegion(region, shardingHome{Shard: shard, Region: region})
+		}
+	}
+}
+
+func (sc *shardCoordinator) allocatedRegions() []string {
+	regions := make(map[string]int)
+	for _, region := range sc.homes {
+		regions[region]++
+	}
+	return sortedKeys(regions)
+}
+
+func (sc *shardCoordinator) sortedShards() []int {
+	shards := make([]int, 0, len(sc.homes))
+	for shard := range sc.homes {
+		shards = append(shards, shard)
+	}
+	sort.Ints(shards)
+	return shards
+}
+
+func (sc *shardCoordinator) sendToRegion(region string, message interface{}) {
+	ref, err := sc.config.Cluster.Remote().ActorFor(
+		region + "/" + shardRegionName(sc.config.TypeName),
+	)
+	if err == nil {
+		ref.Send(message)
+	}
+}
+
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
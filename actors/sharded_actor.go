@@ -1,14 +1,57 @@
 package actors
 
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+
+	"github.com/golang/protobuf/proto"
+)
+
 type ActorConstructor func(string) Actor
 type GetShardFromMessage func(interface{}) int
 type GetActorIDFromMessage func(interface{}) string
 
+// Messages implementing ShardingMessage can be sent to sharded actors that
+// use the default extractors.
+type ShardingMessage interface {
+	ActorID() string
+}
+
+// EntityEnvelope addresses any message to an entity. The entity receives
+// Message rather than the envelope.
+type EntityEnvelope struct {
+	ID      string
+	Message interface{}
+}
+
+func (ee EntityEnvelope) ActorID() string {
+	return ee.ID
+}
+
+type ShardedActorConfig struct {
+	ActorConstructor ActorConstructor
+	ShardCount       int
+	// Default to DefaultActorIDFromMessage and hashing the actor ID that
+	// GetActorIDFromMessage returns.
+	GetActorIDFromMessage GetActorIDFromMessage
+	GetShardFromMessage   GetShardFromMessage
+	// Receives a DeadLetter for every message without an actor ID or with a
+	// shard outside [0, ShardCount).
+	DeadLetters ActorRef
+	// Names the journals of remembered entities.
+	TypeName         string
+	RememberEntities RememberEntitiesConfig
+}
+
 type shardedActor struct {
 	actorFactory          ActorConstructor
 	shardCount            int
 	getActorIDFromMessage GetActorIDFromMessage
 	getShardFromMessage   GetShardFromMessage
+	deadLetters           ActorRef
+	typeName              string
+	rememberEntities      RememberEntitiesConfig
 	shards                []ActorRef
 }
 
@@ -23,34 +66,135 @@ func MakeShardedActor(
 	getActorIDFromMessage GetActorIDFromMessage,
 	getShardFromMessage GetShardFromMessage,
 ) Actor {
+	return MakeShardedActorWithConfig(ShardedActorConfig{
+		ActorConstructor:      actorConstructor,
+		ShardCount:            shardCount,
+		GetActorIDFromMessage: getActorIDFromMessage,
+		GetShardFromMessage:   getShardFromMessage,
+	})
+}
+
+func MakeShardedActorWithConfig(config ShardedActorConfig) Actor {
+	if config.GetActorIDFromMessage == nil {
+		config.GetActorIDFromMessage = DefaultActorIDFromMessage
+	}
+	if config.GetShardFromMessage == nil {
+		config.GetShardFromMessage = HashShardFromActorID(
+			config.GetActorIDFromMessage,
+			config.ShardCount,
+		)
+	}
 	return &shardedActor{
-		actorFactory:          actorConstructor,
-		shardCount:            shardCount,
-		getActorIDFromMessage: getActorIDFromMessage,
-		getShardFromMessage:   getShardFromMessage,
-		shards:                make([]ActorRef, shardCount),
+		actorFactory:          config.ActorConstructor,
+		shardCount:            config.ShardCount,
+		getActorIDFromMessage: config.GetActorIDFromMessage,
+		getShardFromMessage:   config.GetShardFromMessage,
+		deadLetters:           config.DeadLetters,
+		typeName:              config.TypeName,
+		rememberEntities:      config.RememberEntities.withDefaults(),
+		shards:                make([]ActorRef, config.ShardCount),
+	}
+}
+
+// DefaultActorIDFromMessage returns "" for messages that aren't a
+// ShardingMessage, which sharded actors treat as undeliverable.
+func DefaultActorIDFromMessage(message interface{}) string {
+	shardingMessage, ok := message.(ShardingMessage)
+	if !ok {
+		return ""
+	}
+	return shardingMessage.ActorID()
+}
+
+// HashShardFromMessage places each actor ID with ShardForActorID.
+func HashShardFromMessage(shardCount int) GetShardFromMessage {
+	return HashShardFromActorID(DefaultActorIDFromMessage, shardCount)
+}
+
+// HashShardFromActorID places the actor ID getActorID returns with
+// ShardForActorID.
+func HashShardFromActorID(
+	getActorID GetActorIDFromMessage,
+	shardCount int,
+) GetShardFromMessage {
+	return func(message interface{}) int {
+		return ShardForActorID(getActorID(message), shardCount)
+	}
+}
+
+// ShardForActorID maps an actor ID to a shard with jump consistent hashing,
+// so an ID always lands on the same shard and growing the shard count only
+// moves IDs onto the new shards.
+func ShardForActorID(actorID string, shardCount int) int {
+	hash := fnv.New64a()
+	hash.Write([]byte(actorID))
+	key := hash.Sum64()
+	shard, next := int64(-1), int64(0)
+	for next < int64(shardCount) {
+		shard = next
+		key = key*2862933555777941757 + 1
+		next = int64(float64(shard+1) * (float64(1<<31) / float64((key>>33)+1)))
+	}
+	return int(shard)
+}
+
+func validateShard(actorID string, shard int, shardCount int) error {
+	if actorID == "" {
+		return errors.New("message has no actor ID")
+	}
+	if shard < 0 || shard >= shardCount {
+		return fmt.Errorf("shard %d out of range [0, %d)", shard, shardCount)
 	}
+	return nil
 }
 
+// Shards are started lazily unless they may have entities to remember.
 func (sa *shardedActor) OnStart(context ActorContext) {
+	if sa.rememberEntities.Enabled {
+		for shardID := range sa.shards {
+			sa.getShard(shardID)
+		}
+	}
 }
 
 func (sa *shardedActor) OnStop(context ActorContext) {
+	for _, shard := range sa.shards {
+		if shard != nil {
+			shard.Stop()
+		}
+	}
 }
 
 func (sa *shardedActor) Receive(context ActorContext) {
+	if isShardQuery(context.Message()) {
+		shards := make(map[int]ActorRef)
+		for shardID, shard := range sa.shards {
+			if shard != nil {
+				shards[shardID] = shard
+			}
+		}
+		answerShardQuery(context, shards)
+		return
+	}
 	shardID := sa.getShardFromMessage(context.Message())
-	shard := sa.getShard(shardID)
 	actorID := sa.getActorIDFromMessage(context.Message())
+	err := validateShard(actorID, shardID, sa.shardCount)
+	if err != nil {
+		if sa.deadLetters != nil {
+			sa.deadLetters.Send(DeadLetter{
+				Recipient: actorID,
+				Sender:    context.Sender(),
+				Message:   context.Message(),
+				Err:       err,
+			})
+		}
+		return
+	}
 	envelope := shardEnvelope{
 		actorID: actorID,
 		message: context.Message(),
 	}
-	context.Forward(envelope, shard)
-}
-
-func (sa *shardedActor) receiveUserMessage(context ActorContext) {
-
+	context.Forward(envelope, sa.getShard(shardID))
 }
 
 func (sa *shardedActor) getShard(shardID int) ActorRef {
@@ -63,6 +207,14 @@ func (sa *shardedActor) getShard(shardID int) ActorRef {
 
 func (sa *shardedActor) spawnShard(shardID int) ActorRef {
 	actor := newActorShard(shardID, sa.actorFactory)
+	if sa.rememberEntities.Enabled {
+		actor = newRememberingActorShard(
+			shardID,
+			sa.actorFactory,
+			sa.typeName,
+			sa.rememberEntities,
+		)
+	}
 	ref := SpawnActor(actor)
 	sa.shards[shardID] = ref
 	return ref
@@ -72,6 +224,10 @@ type actorShard struct {
 	shardID      int
 	actorFactory ActorConstructor
 	actors       map[string]ActorRef
+	journal      *entityJournal
+	remember     RememberEntitiesConfig
+	restarting   []string
+	flushing     bool
 }
 
 func newActorShard(shardID int, actorFactory ActorConstructor) Actor {
@@ -82,29 +238,138 @@ func newActorShard(shardID int, actorFactory ActorConstructor) Actor {
 	}
 }
 
+func newRememberingActorShard(
+	shardID int,
+	actorFactory ActorConstructor,
+	typeName string,
+	config RememberEntitiesConfig,
+) Actor {
+	config = config.withDefaults()
+	return &actorShard{
+		shardID:      shardID,
+		actorFactory: actorFactory,
+		actors:       make(map[string]ActorRef),
+		journal:      newEntityJournal(typeName, shardID, config),
+		remember:     config,
+	}
+}
+
 func (as *actorShard) OnStart(context ActorContext) {
+	if as.journal != nil {
+		as.recoverEntities(context)
+	}
+}
+
+// Journal errors leave the shard running its entities; recovery and writes
+// are retried every BatchInterval until they succeed.
+func (as *actorShard) recoverEntities(context ActorContext) {
+	restarting, err := as.journal.recover()
+	if err != nil {
+		ScheduleOnce(as.remember.BatchInterval, context.Self(), recoverEntities{})
+		return
+	}
+	as.restarting = restarting
+	if len(as.restarting) > 0 {
+		context.Self().Send(restartEntities{})
+	}
+	as.flushEntities(context)
+}
+
+func (as *actorShard) flushEntities(context ActorContext) {
+	as.flushing = false
+	as.retryFlush(context, as.journal.flush())
+}
+
+func (as *actorShard) record(context ActorContext, event proto.Message) {
+	as.retryFlush(context, as.journal.record(event))
+}
+
+func (as *actorShard) retryFlush(context ActorContext, err error) {
+	if err != nil && !as.flushing {
+		as.flushing = true
+		ScheduleOnce(as.remember.BatchInterval, context.Self(), flushEntities{})
+	}
 }
 
 func (as *actorShard) OnStop(context ActorContext) {
+	for _, ref := range as.actors {
+		ref.Stop()
+	}
 }
 
 func (as *actorShard) Receive(context ActorContext) {
-	message := context.Message().(shardEnvelope)
-	ref := as.getActor(message.actorID)
-	context.Forward(message.message, ref)
+	switch message := context.Message().(type) {
+	case restartEntities:
+		as.restartBatch(context)
+	case recoverEntities:
+		as.recoverEntities(context)
+	case flushEntities:
+		as.flushEntities(context)
+	case getShardState:
+		context.Reply(as.state())
+	case shardEnvelope:
+		_, stop := message.message.(StopEntity)
+		if stop {
+			as.stopActor(context, message.actorID)
+			return
+		}
+		ref := as.getActor(context, message.actorID)
+		entityEnvelope, ok := message.message.(EntityEnvelope)
+		if ok {
+			message.message = entityEnvelope.Message
+		}
+		context.Forward(message.message, ref)
+	}
+}
+
+func (as *actorShard) restartBatch(context ActorContext) {
+	count := as.remember.BatchSize
+	if count > len(as.restarting) {
+		count = len(as.restarting)
+	}
+	for _, actorID := range as.restarting[:count] {
+		_, running := as.actors[actorID]
+		if !running {
+			as.actors[actorID] = SpawnActor(as.actorFactory(actorID))
+		}
+	}
+	as.restarting = as.restarting[count:]
+	if len(as.restarting) > 0 {
+		ScheduleOnce(as.remember.BatchInterval, context.Self(), restartEntities{})
+	}
 }
 
-func (as *actorShard) getActor(actorID string) ActorRef {
+func (as *actorShard) getActor(context ActorContext, actorID string) ActorRef {
 	foundRef, found := as.actors[actorID]
 	if found {
 		return foundRef
 	}
-	return as.spawnActor(actorID)
+	return as.spawnActor(context, actorID)
 }
 
-func (as *actorShard) spawnActor(actorID string) ActorRef {
+func (as *actorShard) spawnActor(context ActorContext, actorID string) ActorRef {
 	actor := as.actorFactory(actorID)
 	ref := SpawnActor(actor)
 	as.actors[actorID] = ref
+	if as.journal != nil {
+		as.record(context, &entityStarted{ID: actorID})
+	}
 	return ref
 }
+
+func (as *actorShard) stopActor(context ActorContext, actorID string) {
+	ref, found := as.actors[actorID]
+	if found {
+		ref.Stop()
+		delete(as.actors, actorID)
+	}
+	for i, restarting := range as.restarting {
+		if restarting == actorID {
+			as.restarting = append(as.restarting[:i:i], as.restarting[i+1:]...)
+			break
+		}
+	}
+	if as.journal != nil {
+		as.record(context, &entityStopped{ID: actorID})
+	}
+}
@@ -1,8 +1,12 @@
 package actors_test
 
 import (
+	"fmt"
 	"sync/atomic"
+	"testing/quick"
+	"time"
 
+	"github.com/golang/protobuf/ptypes/wrappers"
 	. "github.com/kphelps/actors/actors"
 	"github.com/kphelps/actors/mocks/actors"
 
@@ -90,4 +94,284 @@ var _ = Describe("ShardedActor", func() {
 			Expect(constructedCount).To(Equal(uint64(2)))
 		})
 	})
+
+	Describe("Default extractors", func() {
+		var deadLetters chan interface{}
+
+		BeforeEach(func() {
+			ref.Stop()
+			deadLetters = make(chan interface{}, 1)
+			ref = SpawnActor(MakeShardedActorWithConfig(ShardedActorConfig{
+				ActorConstructor: func(actorID string) Actor {
+					atomic.AddUint64(&constructedCount, 1)
+					return &ChannelActor{receiveChan}
+				},
+				ShardCount:  10,
+				DeadLetters: SpawnActor(&ChannelActor{deadLetters}),
+			}))
+		})
+
+		It("Unwraps entity envelopes", func() {
+			ref.Send(EntityEnvelope{ID: "a", Message: "hello"})
+			ref.Send(EntityEnvelope{ID: "a", Message: "again"})
+			Eventually(receiveChan).Should(Receive(Equal("hello")))
+			Eventually(receiveChan).Should(Receive(Equal("again")))
+			Expect(atomic.LoadUint64(&constructedCount)).To(Equal(uint64(1)))
+		})
+
+		It("Sends messages without an actor ID to dead letters", func() {
+			ref.Send("hello")
+			var letter interface{}
+			Eventually(deadLetters).Should(Receive(&letter))
+			Expect(letter.(DeadLetter).Message).To(Equal("hello"))
+			Expect(letter.(DeadLetter).Err).To(HaveOccurred())
+		})
+	})
+
+	Describe("Custom actor IDs", func() {
+		It("Hash to shards by default", func() {
+			ref.Stop()
+			ref = SpawnActor(MakeShardedActorWithConfig(ShardedActorConfig{
+				ActorConstructor: func(actorID string) Actor {
+					return NewFunctionActor(func(ActorContext) {})
+				},
+				ShardCount: 10,
+				GetActorIDFromMessage: func(message interface{}) string {
+					return message.(testShardMessage).actorID
+				},
+			}))
+			actorIDs := []string{"a", "b", "c", "d", "e", "f"}
+			shards := make(map[int]bool)
+			for _, actorID := range actorIDs {
+				ref.Send(testShardMessage{actorID: actorID})
+				shards[ShardForActorID(actorID, 10)] = true
+			}
+			Expect(len(shards)).To(BeNumerically(">", 1))
+
+			Eventually(func() map[int]bool {
+				stats := ref.Ask(GetShardStats{}).(ShardStats)
+				started := make(map[int]bool)
+				for shardID := range stats.EntityCounts {
+					started[shardID] = true
+				}
+				return started
+			}).Should(Equal(shards))
+		})
+	})
+
+	Describe("Invalid shards", func() {
+		It("Go to dead letters", func() {
+			ref.Stop()
+			deadLetters := make(chan interface{}, 1)
+			ref = SpawnActor(MakeShardedActorWithConfig(ShardedActorConfig{
+				ActorConstructor: func(actorID string) Actor {
+					return &ChannelActor{receiveChan}
+				},
+				ShardCount: 10,
+				GetActorIDFromMessage: func(message interface{}) string {
+					return message.(testShardMessage).actorID
+				},
+				GetShardFromMessage: func(message interface{}) int {
+					return message.(testShardMessage).shard
+				},
+				DeadLetters: SpawnActor(&ChannelActor{deadLetters}),
+			}))
+			ref.Send(testShardMessage{"hello", 10})
+			ref.Send(testShardMessage{"hello", -1})
+			Eventually(deadLetters).Should(Receive(BeAssignableToTypeOf(DeadLetter{})))
+			Eventually(deadLetters).Should(Receive(BeAssignableToTypeOf(DeadLetter{})))
+		})
+	})
+
+	Describe("ShardForActorID", func() {
+		It("Maps an actor ID to the same valid shard every time", func() {
+			stable := func(actorID string, count uint8) bool {
+				shardCount := int(count) + 1
+				shard := ShardForActorID(actorID, shardCount)
+				return shard >= 0 &&
+					shard < shardCount &&
+					shard == ShardForActorID(actorID, shardCount)
+			}
+			Expect(quick.Check(stable, nil)).To(Succeed())
+		})
+
+		It("Only moves actor IDs onto new shards when the count grows", func() {
+			consistent := func(actorID string, count uint8) bool {
+				shardCount := int(count) + 1
+				before := ShardForActorID(actorID, shardCount)
+				after := ShardForActorID(actorID, shardCount+1)
+				return after == before || after == shardCount
+			}
+			Expect(quick.Check(consistent, nil)).To(Succeed())
+		})
+	})
+
+	Describe("Remembering entities", func() {
+		var typeName string
+		var started chan string
+
+		startRemembering := func() ActorRef {
+			return SpawnActor(MakeShardedActorWithConfig(ShardedActorConfig{
+				ActorConstructor: func(actorID string) Actor {
+					started <- actorID
+					return NewFunctionActor(func(ActorContext) {})
+				},
+				ShardCount: 2,
+				TypeName:   typeName,
+				RememberEntities: RememberEntitiesConfig{
+					Enabled:       true,
+					BatchSize:     1,
+					BatchInterval: 50 * time.Millisecond,
+				},
+			}))
+		}
+
+		BeforeEach(func() {
+			typeName = fmt.Sprintf("remembered-%d", time.Now().UnixNano())
+			started = make(chan string, 10)
+		})
+
+		It("Restarts entities that were running, a batch at a time", func() {
+			remembering := startRemembering()
+			for _, actorID := range []string{"a", "b", "c"} {
+				remembering.Send(EntityEnvelope{ID: actorID, Message: "hello"})
+				Eventually(started).Should(Receive(Equal(actorID)))
+			}
+			remembering.Stop()
+
+			begin := time.Now()
+			remembering = startRemembering()
+			defer remembering.Stop()
+			restarted := make([]string, 0)
+			for i := 0; i < 3; i++ {
+				var actorID string
+				Eventually(started).Should(Receive(&actorID))
+				restarted = append(restarted, actorID)
+			}
+			Expect(restarted).To(ConsistOf("a", "b", "c"))
+			Expect(time.Since(begin)).To(BeNumerically(">=", 50*time.Millisecond))
+		})
+
+		// A single shard whose entities reply with their ID.
+		startReplying := func() ActorRef {
+			return SpawnActor(MakeShardedActorWithConfig(ShardedActorConfig{
+				ActorConstructor: func(actorID string) Actor {
+					return NewFunctionActor(func(context ActorContext) {
+						context.Reply(actorID)
+					})
+				},
+				ShardCount: 1,
+				TypeName:   typeName,
+				RememberEntities: RememberEntitiesConfig{
+					Enabled:      true,
+					CompactAfter: 4,
+				},
+			}))
+		}
+
+		It("Compacts the journal of started and stopped entities", func() {
+			remembering := startReplying()
+			defer remembering.Stop()
+			remembering.Ask(EntityEnvelope{ID: "kept", Message: "hello"})
+			for i := 0; i < 20; i++ {
+				actorID := fmt.Sprintf("entity-%d", i)
+				remembering.Ask(EntityEnvelope{ID: actorID, Message: "hello"})
+				remembering.Send(StopEntity{ID: actorID})
+			}
+			remembering.Ask(EntityEnvelope{ID: "kept", Message: "sync"})
+
+			events, err := GetPersistenceProvider().GetEvents(
+				fmt.Sprintf("sharding-entities-%s-0", typeName),
+				0,
+			)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(len(events)).To(BeNumerically("<=", 6))
+		})
+
+		It("Keeps running when the journal can't be written", func() {
+			remembering := startReplying()
+			defer remembering.Stop()
+			Expect(remembering.Ask(EntityEnvelope{ID: "a"})).To(Equal("a"))
+			err := GetPersistenceProvider().PersistEvent(
+				fmt.Sprintf("sharding-entities-%s-0", typeName),
+				1,
+				&wrappers.StringValue{Value: "conflict"},
+			)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(remembering.Ask(EntityEnvelope{ID: "b"})).To(Equal("b"))
+			Expect(remembering.Ask(EntityEnvelope{ID: "c"})).To(Equal("c"))
+		})
+
+		It("Forgets stopped entities", func() {
+			remembering := startRemembering()
+			remembering.Send(EntityEnvelope{ID: "a", Message: "hello"})
+			remembering.Send(EntityEnvelope{ID: "b", Message: "hello"})
+			Eventually(started).Should(Receive())
+			Eventually(started).Should(Receive())
+			remembering.Send(StopEntity{ID: "b"})
+			remembering.Send(EntityEnvelope{ID: "c", Message: "sync"})
+			Eventually(started).Should(Receive(Equal("c")))
+			remembering.Send(StopEntity{ID: "c"})
+			time.Sleep(20 * time.Millisecond)
+			remembering.Stop()
+
+			remembering = startRemembering()
+			defer remembering.Stop()
+			Eventually(started).Should(Receive(Equal("a")))
+			Consistently(started, 200*time.Millisecond).ShouldNot(Receive())
+		})
+	})
+
+	Describe("State queries", func() {
+		BeforeEach(func() {
+			ref.Stop()
+			ref = SpawnActor(MakeShardedActorWithConfig(ShardedActorConfig{
+				ActorConstructor: func(actorID string) Actor {
+					return NewFunctionActor(func(ActorContext) {})
+				},
+				ShardCount: 10,
+			}))
+			for _, actorID := range []string{"a", "b", "c", "d"} {
+				ref.Send(EntityEnvelope{ID: actorID, Message: "hello"})
+			}
+		})
+
+		It("Lists shards and their entities", func() {
+			var state ShardRegionState
+			Eventually(func() []string {
+				state = ref.Ask(GetShardRegionState{}).(ShardRegionState)
+				ids := make([]string, 0)
+				for _, shard := range state.Shards {
+					ids = append(ids, shard.EntityIDs...)
+				}
+				return ids
+			}).Should(ConsistOf("a", "b", "c", "d"))
+
+			shard := state.Shards[0]
+			ids := ref.Ask(GetEntityIDs{ShardID: shard.ShardID}).(EntityIDs)
+			Expect(ids.IDs).To(Equal(shard.EntityIDs))
+		})
+
+		It("Counts entities by shard", func() {
+			stats := ref.Ask(GetShardStats{}).(ShardStats)
+			total := 0
+			for shardID, count := range stats.EntityCounts {
+				Expect(shardID).To(BeNumerically("<", 10))
+				total += count
+			}
+			Expect(total).To(Equal(4))
+			Expect(stats.EntityCounts[ShardForActorID("a", 10)]).To(BeNumerically(">=", 1))
+		})
+
+		It("Has no entities for a shard that hasn't started", func() {
+			for shardID := 0; shardID < 10; shardID++ {
+				ids := ref.Ask(GetEntityIDs{ShardID: shardID}).(EntityIDs)
+				if len(ids.IDs) == 0 {
+					return
+				}
+			}
+			Fail("every shard has entities")
+		})
+	})
 })
@@ -0,0 +1,201 @@
+package actors
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// Queries answered by sharded actors and cluster sharding regions about the
+// shards running locally.
+type GetShardRegionState struct{}
+
+type ShardRegionState struct {
+	Shards []ShardState
+}
+
+type ShardState struct {
+	ShardID   int
+	EntityIDs []string
+}
+
+type GetShardStats struct{}
+
+type ShardStats struct {
+	// Number of running entities by shard.
+	EntityCounts map[int]int
+}
+
+type GetEntityIDs struct {
+	ShardID int
+}
+
+type EntityIDs struct {
+	ShardID int
+	IDs     []string
+}
+
+// GetClusterShardingStats asks a cluster sharding region for the stats of
+// every region of its type. Regions that don't answer within Timeout are
+// listed in Failed.
+type GetClusterShardingStats struct {
+	Timeout time.Duration
+}
+
+type ClusterShardingStats struct {
+	Regions map[string]ShardStats
+	Failed  []string
+}
+
+type getShardState struct{}
+
+const shardQueryTimeout = 3 * time.Second
+
+func init() {
+	defaultSerializers.RegisterJSON("actors.GetShardRegionState", GetShardRegionState{})
+	defaultSerializers.RegisterJSON("actors.ShardRegionState", ShardRegionState{})
+	defaultSerializers.RegisterJSON("actors.GetShardStats", GetShardStats{})
+	defaultSerializers.RegisterJSON("actors.ShardStats", ShardStats{})
+	defaultSerializers.RegisterJSON("actors.GetEntityIDs", GetEntityIDs{})
+	defaultSerializers.RegisterJSON("actors.EntityIDs", EntityIDs{})
+}
+
+func isShardQuery(message interface{}) bool {
+	switch message.(type) {
+	case GetShardRegionState, GetShardStats, GetEntityIDs:
+		return true
+	}
+	return false
+}
+
+// answerShardQuery asks the shards from another goroutine so that the
+// region keeps routing messages in the meantime.
+func answerShardQuery(context ActorContext, shards map[int]ActorRef) {
+	sender := context.Sender()
+	if sender == nil {
+		return
+	}
+	ctx := context.Context()
+	self := context.Self()
+	query := context.Message()
+	go func() {
+		var reply interface{}
+		switch query := query.(type) {
+		case GetShardRegionState:
+			reply = ShardRegionState{Shards: collectShardStates(ctx, shards)}
+		case GetShardStats:
+			stats := ShardStats{EntityCounts: make(map[int]int)}
+			for _, state := range collectShardStates(ctx, shards) {
+				stats.EntityCounts[state.ShardID] = len(state.EntityIDs)
+			}
+			reply = stats
+		case GetEntityIDs:
+			ids := EntityIDs{ShardID: query.ShardID, IDs: []string{}}
+			shard, found := shards[query.ShardID]
+			if found {
+				state, err := askShardState(ctx, shard)
+				if err == nil {
+					ids.IDs = state.EntityIDs
+				}
+			}
+			reply = ids
+		}
+		sender.SendFromContext(ctx, reply, self)
+	}()
+}
+
+func collectShardStates(
+	ctx context.Context,
+	shards map[int]ActorRef,
+) []ShardState {
+	states := make([]ShardState, 0, len(shards))
+	for _, shard := range shards {
+		state, err := askShardState(ctx, shard)
+		if err == nil {
+			states = append(states, state)
+		}
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].ShardID < states[j].ShardID
+	})
+	return states
+}
+
+func askShardState(ctx context.Context, shard ActorRef) (ShardState, error) {
+	ctx, cancel := context.WithTimeout(ctx, shardQueryTimeout)
+	defer cancel()
+	response, err := shard.AskContext(ctx, getShardState{})
+	if err != nil {
+		return ShardState{}, err
+	}
+	return response.(ShardState), nil
+}
+
+func (as *actorShard) state() ShardState {
+	ids := make([]string, 0, len(as.actors))
+	for actorID := range as.actors {
+		ids = append(ids, actorID)
+	}
+	sort.Strings(ids)
+	return ShardState{
+		ShardID:   as.shardID,
+		EntityIDs: ids,
+	}
+}
+
+// answerClusterStats asks the region of every member for its stats.
+func (sr *shardRegion) answerClusterStats(
+	context ActorContext,
+	query GetClusterShardingStats,
+) {
+	sender := context.Sender()
+	if sender == nil {
+		return
+	}
+	if query.Timeout <= 0 {
+		query.Timeout = shardQueryTimeout
+	}
+	self := context.Self()
+	remote := sr.config.Cluster.Remote()
+	addresses := make([]string, 0, len(sr.members))
+	for address, member := range sr.members {
+		if member.Status == MemberStatusUp {
+			addresses = append(addresses, address)
+		}
+	}
+	sort.Strings(addresses)
+	regionName := shardRegionName(sr.config.TypeName)
+
+	go func() {
+		stats := gatherClusterStats(remote, addresses, regionName, query.Timeout)
+		sender.SendFrom(stats, self)
+	}()
+}
+
+func gatherClusterStats(
+	remote *RemoteSystem,
+	addresses []string,
+	regionName string,
+	timeout time.Duration,
+) ClusterShardingStats {
+	stats := ClusterShardingStats{
+		Regions: make(map[string]ShardStats),
+		Failed:  []string{},
+	}
+	for _, address := range addresses {
+		var response interface{}
+		region, err := remote.ActorFor(address + "/" + regionName)
+		if err == nil {
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			response, err = region.AskContext(ctx, GetShardStats{})
+			cancel()
+		}
+		regionStats, ok := response.(ShardStats)
+		if err != nil || !ok {
+			stats.Failed = append(stats.Failed, address)
+			continue
+		}
+		stats.Regions[address] = regionStats
+	}
+	return stats
+}
@@ -0,0 +1,369 @@
+package actors
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+type SingletonConfig struct {
+	Name        string
+	Constructor func() Actor
+	// Without a cluster the singleton runs once per process.
+	Cluster *Cluster
+	// How often managers retry a hand over and proxies look for the
+	// singleton while it is moving.
+	RetryInterval time.Duration
+	// Proxies drop messages beyond this many while the singleton is
+	// unavailable.
+	BufferSize int
+}
+
+type singletonHandOverRequest struct{}
+
+type singletonHandOverDone struct{}
+
+type singletonIdentify struct{}
+
+type singletonIdentity struct {
+	Path string
+}
+
+type singletonRetry struct{}
+
+func init() {
+	defaultSerializers.RegisterJSON("actors.singletonHandOverRequest", singletonHandOverRequest{})
+	defaultSerializers.RegisterJSON("actors.singletonHandOverDone", singletonHandOverDone{})
+	defaultSerializers.RegisterJSON("actors.singletonIdentify", singletonIdentify{})
+	defaultSerializers.RegisterJSON("actors.singletonIdentity", singletonIdentity{})
+}
+
+// Singletons started without a cluster, by name.
+var localSingletons = struct {
+	sync.Mutex
+	instances map[string]ActorRef
+}{instances: make(map[string]ActorRef)}
+
+// localSingleton returns the running instance of the named singleton, or
+// nil. The caller holds localSingletons.
+func localSingleton(name string) ActorRef {
+	instance, found := localSingletons.instances[name]
+	if !found || isStoppedRef(instance) {
+		return nil
+	}
+	return instance
+}
+
+func singletonName(name string) string {
+	return "singleton/" + name
+}
+
+func singletonManagerName(name string) string {
+	return "singleton/" + name + "/manager"
+}
+
+func (config SingletonConfig) withDefaults() SingletonConfig {
+	if config.RetryInterval <= 0 {
+		config.RetryInterval = time.Second
+	}
+	if config.BufferSize <= 0 {
+		config.BufferSize = 1000
+	}
+	return config
+}
+
+// StartSingletonManager runs on every member that may host the singleton.
+// The manager on the oldest member starts it; when that member leaves, the
+// next oldest asks it to stop the singleton before starting its own, so
+// there is never more than one running.
+func StartSingletonManager(config SingletonConfig) ActorRef {
+	config = config.withDefaults()
+	ref := SpawnActor(&singletonManager{
+		config:  config,
+		members: make(map[string]Member),
+	})
+	if config.Cluster != nil {
+		config.Cluster.Remote().Register(singletonManagerName(config.Name), ref)
+	}
+	return ref
+}
+
+type singletonManager struct {
+	config   SingletonConfig
+	self     string
+	members  map[string]Member
+	oldest   string
+	previous string
+	instance ActorRef
+	waiting  bool
+	stopped  bool
+}
+
+func (sm *singletonManager) OnStart(context ActorContext) {
+	if sm.config.Cluster == nil {
+		sm.startLocal(context)
+		return
+	}
+	sm.self = sm.config.Cluster.SelfAddress()
+	sm.config.Cluster.Subscribe(context.Self())
+}
+
+func (sm *singletonManager) OnStop(context ActorContext) {
+	if sm.config.Cluster == nil {
+		localSingletons.Lock()
+		defer localSingletons.Unlock()
+		if sm.instance != nil {
+			if localSingletons.instances[sm.config.Name] == sm.instance {
+				delete(localSingletons.instances, sm.config.Name)
+			}
+			sm.instance.Stop()
+		}
+		return
+	}
+	sm.config.Cluster.Unsubscribe(context.Self())
+	sm.config.Cluster.Remote().Unregister(singletonManagerName(sm.config.Name))
+	sm.stopInstance()
+}
+
+func (sm *singletonManager) Receive(context ActorContext) {
+	switch message := context.Message().(type) {
+	case CurrentClusterState:
+		for _, member := range message.Members {
+			sm.members[member.Address] = member
+		}
+		sm.evaluate(context)
+	case MemberJoined, MemberUp, MemberLeft, MemberExited, MemberDowned, MemberRemoved:
+		member := eventMember(message)
+		if member.Status == MemberStatusRemoved {
+			delete(sm.members, member.Address)
+		} else {
+			sm.members[member.Address] = member
+		}
+		if member.Address == sm.self && member.Status >= MemberStatusExiting {
+			sm.stopInstance()
+			sm.stopped = true
+		}
+		sm.evaluate(context)
+	case singletonHandOverRequest:
+		sm.stopInstance()
+		context.Reply(singletonHandOverDone{})
+	case singletonHandOverDone:
+		if sm.waiting {
+			sm.startInstance()
+		}
+	case singletonIdentify:
+		if sm.instance != nil {
+			path := sm.config.Cluster.Remote().PathOf(sm.instance)
+			context.Reply(singletonIdentity{path})
+		}
+	case singletonRetry:
+		if sm.config.Cluster == nil {
+			sm.startLocal(context)
+		} else if sm.waiting {
+			sm.requestHandOver(context)
+		}
+	}
+}
+
+// startLocal starts the singleton unless another manager in the process
+// runs it. Until then it checks again every RetryInterval, so it takes over
+// once the other manager or its instance stops.
+func (sm *singletonManager) startLocal(context ActorContext) {
+	if sm.instance != nil {
+		return
+	}
+	localSingletons.Lock()
+	defer localSingletons.Unlock()
+	if localSingleton(sm.config.Name) != nil {
+		ScheduleOnce(sm.config.RetryInterval, context.Self(), singletonRetry{})
+		return
+	}
+	sm.instance = SpawnActor(sm.config.Constructor())
+	localSingletons.instances[sm.config.Name] = sm.instance
+}
+
+func (sm *singletonManager) evaluate(context ActorContext) {
+	if sm.stopped {
+		return
+	}
+	oldest := oldestMember(sm.members)
+	if oldest != sm.oldest {
+		sm.previous = sm.oldest
+		sm.oldest = oldest
+	}
+	if oldest != sm.self || sm.instance != nil {
+		return
+	}
+	if !sm.waiting && sm.previousAlive() {
+		sm.waiting = true
+		sm.requestHandOver(context)
+	} else if !sm.previousAlive() {
+		sm.startInstance()
+	}
+}
+
+// previousAlive reports whether the last oldest member could still be
+// running the singleton.
+func (sm *singletonManager) previousAlive() bool {
+	if sm.previous == "" || sm.previous == sm.self {
+		return false
+	}
+	member, found := sm.members[sm.previous]
+	return found && member.Status < MemberStatusDown
+}
+
+func (sm *singletonManager) requestHandOver(context ActorContext) {
+	if !sm.previousAlive() {
+		sm.startInstance()
+		return
+	}
+	manager, err := sm.config.Cluster.Remote().ActorFor(
+		sm.previous + "/" + singletonManagerName(sm.config.Name),
+	)
+	if err == nil {
+		manager.SendFrom(singletonHandOverRequest{}, context.Self())
+	}
+	ScheduleOnce(sm.config.RetryInterval, context.Self(), singletonRetry{})
+}
+
+func (sm *singletonManager) startInstance() {
+	sm.waiting = false
+	if sm.instance != nil {
+		return
+	}
+	sm.instance = SpawnActor(sm.config.Constructor())
+	sm.config.Cluster.Remote().Register(singletonName(sm.config.Name), sm.instance)
+}
+
+func (sm *singletonManager) stopInstance() {
+	if sm.instance == nil {
+		return
+	}
+	sm.config.Cluster.Remote().Unregister(singletonName(sm.config.Name))
+	sm.instance.Stop()
+	sm.instance = nil
+}
+
+// NewSingletonProxy returns a ref that reaches the singleton wherever it is
+// running, holding on to messages while it is being handed over.
+func NewSingletonProxy(config SingletonConfig) ActorRef {
+	return SpawnActor(&singletonProxy{
+		config:  config.withDefaults(),
+		members: make(map[string]Member),
+	})
+}
+
+type singletonProxy struct {
+	config    SingletonConfig
+	members   map[string]Member
+	oldest    string
+	singleton ActorRef
+	buffer    []bufferedMessage
+	retrying  bool
+}
+
+func (sp *singletonProxy) OnStart(context ActorContext) {
+	if sp.config.Cluster != nil {
+		sp.config.Cluster.Subscribe(context.Self())
+	}
+	sp.identify(context)
+}
+
+func (sp *singletonProxy) OnStop(context ActorContext) {
+	if sp.config.Cluster != nil {
+		sp.config.Cluster.Unsubscribe(context.Self())
+	}
+}
+
+func (sp *singletonProxy) Receive(context ActorContext) {
+	switch message := context.Message().(type) {
+	case CurrentClusterState:
+		for _, member := range message.Members {
+			sp.members[member.Address] = member
+		}
+		sp.updateOldest(context)
+	case MemberJoined, MemberUp, MemberLeft, MemberExited, MemberDowned, MemberRemoved:
+		member := eventMember(message)
+		if member.Status == MemberStatusRemoved {
+			delete(sp.members, member.Address)
+		} else {
+			sp.members[member.Address] = member
+		}
+		sp.updateOldest(context)
+	case UnreachableMember, ReachableMember:
+	case singletonIdentity:
+		// Answers from a member that is no longer the oldest are stale.
+		if !strings.HasPrefix(message.Path, sp.oldest+"/") {
+			return
+		}
+		singleton, err := sp.config.Cluster.Remote().ActorFor(message.Path)
+		if err == nil {
+			sp.singleton = singleton
+			sp.flush()
+		}
+	case singletonRetry:
+		sp.retrying = false
+		if sp.singleton == nil {
+			sp.identify(context)
+		}
+	default:
+		// Without a cluster the instance is looked up on every send, so a
+		// replacement started by another manager is picked up.
+		if sp.config.Cluster == nil {
+			sp.singleton = sp.lookupLocal()
+		}
+		if sp.singleton != nil {
+			sp.singleton.SendFrom(message, context.Sender())
+		} else if len(sp.buffer) < sp.config.BufferSize {
+			sp.buffer = append(sp.buffer, bufferedMessage{message, context.Sender()})
+		}
+	}
+}
+
+func (sp *singletonProxy) updateOldest(context ActorContext) {
+	oldest := oldestMember(sp.members)
+	if oldest == sp.oldest {
+		return
+	}
+	sp.oldest = oldest
+	sp.singleton = nil
+	sp.identify(context)
+}
+
+// identify looks for the singleton until it is found.
+func (sp *singletonProxy) identify(context ActorContext) {
+	if !sp.retrying {
+		sp.retrying = true
+		ScheduleOnce(sp.config.RetryInterval, context.Self(), singletonRetry{})
+	}
+	if sp.config.Cluster == nil {
+		sp.singleton = sp.lookupLocal()
+		sp.flush()
+		return
+	}
+	if sp.oldest == "" {
+		return
+	}
+	manager, err := sp.config.Cluster.Remote().ActorFor(
+		sp.oldest + "/" + singletonManagerName(sp.config.Name),
+	)
+	if err == nil {
+		manager.SendFrom(singletonIdentify{}, context.Self())
+	}
+}
+
+func (sp *singletonProxy) lookupLocal() ActorRef {
+	localSingletons.Lock()
+	defer localSingletons.Unlock()
+	return localSingleton(sp.config.Name)
+}
+
+func (sp *singletonProxy) flush() {
+	if sp.singleton == nil {
+		return
+	}
+	for _, m := range sp.buffer {
+		sp.singleton.SendFrom(m.message, m.sender)
+	}
+	sp.buffer = nil
+}
@@ -0,0 +1,174 @@
+package actors_test
+
+import (
+	"fmt"
+	"time"
+
+	. "github.com/kphelps/actors/actors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type singletonWhere struct {
+	Address string
+}
+
+func init() {
+	DefaultSerializers().RegisterJSON("actors_test.singletonWhere", singletonWhere{})
+}
+
+type singletonLifecycle struct {
+	address string
+	started bool
+}
+
+type singletonTestActor struct {
+	address string
+	events  chan singletonLifecycle
+}
+
+func (sta *singletonTestActor) OnStart(context ActorContext) {
+	sta.events <- singletonLifecycle{sta.address, true}
+}
+
+func (sta *singletonTestActor) OnStop(context ActorContext) {
+	sta.events <- singletonLifecycle{sta.address, false}
+}
+
+func (sta *singletonTestActor) Receive(context ActorContext) {
+	context.Reply(singletonWhere{sta.address})
+}
+
+var _ = Describe("Singleton", func() {
+	var name string
+	var events chan singletonLifecycle
+
+	config := func(address string, cluster *Cluster) SingletonConfig {
+		return SingletonConfig{
+			Name: name,
+			Constructor: func() Actor {
+				return &singletonTestActor{address, events}
+			},
+			Cluster:       cluster,
+			RetryInterval: 20 * time.Millisecond,
+		}
+	}
+
+	BeforeEach(func() {
+		name = fmt.Sprintf("singleton-%d", time.Now().UnixNano())
+		events = make(chan singletonLifecycle, 100)
+	})
+
+	Context("Without a cluster", func() {
+		It("Starts one instance per process", func() {
+			first := StartSingletonManager(config("first", nil))
+			defer first.Stop()
+			second := StartSingletonManager(config("second", nil))
+			defer second.Stop()
+
+			Eventually(events).Should(Receive(
+				WithTransform(func(event singletonLifecycle) bool {
+					return event.started
+				}, BeTrue()),
+			))
+			Consistently(events, 100*time.Millisecond).ShouldNot(Receive())
+		})
+
+		It("Routes through a proxy", func() {
+			proxy := NewSingletonProxy(config("", nil))
+			defer proxy.Stop()
+			manager := StartSingletonManager(config("local", nil))
+			defer manager.Stop()
+
+			Expect(proxy.Ask(singletonWhere{})).To(Equal(singletonWhere{"local"}))
+		})
+
+		It("Moves to another manager when the running one stops", func() {
+			proxy := NewSingletonProxy(config("", nil))
+			defer proxy.Stop()
+			first := StartSingletonManager(config("first", nil))
+			defer first.Stop()
+			Eventually(events).Should(Receive(Equal(singletonLifecycle{"first", true})))
+			Expect(proxy.Ask(singletonWhere{})).To(Equal(singletonWhere{"first"}))
+
+			second := StartSingletonManager(config("second", nil))
+			defer second.Stop()
+			first.Stop()
+			Eventually(events).Should(Receive(Equal(singletonLifecycle{"first", false})))
+			Eventually(events).Should(Receive(Equal(singletonLifecycle{"second", true})))
+			Expect(proxy.Ask(singletonWhere{})).To(Equal(singletonWhere{"second"}))
+		})
+	})
+
+	Context("In a cluster", func() {
+		var nodes []*clusterNode
+		var managers, proxies []ActorRef
+
+		BeforeEach(func() {
+			seed := startClusterNode()
+			nodes = []*clusterNode{
+				seed,
+				startClusterNode(seed.remote.Address()),
+				startClusterNode(seed.remote.Address()),
+			}
+			Eventually(memberStatuses(nodes[0])).Should(Equal(allUp(nodes...)))
+			managers, proxies = nil, nil
+			for _, node := range nodes {
+				Eventually(memberStatuses(node)).Should(Equal(allUp(nodes...)))
+				nodeConfig := config(node.remote.Address(), node.cluster)
+				managers = append(managers, StartSingletonManager(nodeConfig))
+				proxies = append(proxies, NewSingletonProxy(nodeConfig))
+			}
+		})
+
+		AfterEach(func() {
+			for i, node := range nodes {
+				proxies[i].Stop()
+				managers[i].Stop()
+				node.stop()
+			}
+		})
+
+		oldest := func() string {
+			members := nodes[0].members()
+			oldest := members[0]
+			for _, member := range members {
+				if member.UpNumber < oldest.UpNumber {
+					oldest = member
+				}
+			}
+			return oldest.Address
+		}
+
+		It("Runs on the oldest member", func() {
+			Eventually(events).Should(Receive(Equal(singletonLifecycle{oldest(), true})))
+			for _, proxy := range proxies {
+				Expect(proxy.Ask(singletonWhere{})).To(Equal(singletonWhere{oldest()}))
+			}
+			Consistently(events, 100*time.Millisecond).ShouldNot(Receive())
+		})
+
+		It("Hands over when the oldest member leaves", func() {
+			leaving := oldest()
+			Eventually(events).Should(Receive(Equal(singletonLifecycle{leaving, true})))
+			var survivor int
+			for i, node := range nodes {
+				if node.remote.Address() == leaving {
+					node.cluster.Leave()
+				} else {
+					survivor = i
+				}
+			}
+
+			Eventually(events, 3*time.Second).Should(Receive(Equal(singletonLifecycle{leaving, false})))
+			var next singletonLifecycle
+			Eventually(events, 3*time.Second).Should(Receive(&next))
+			Expect(next.started).To(BeTrue())
+			Expect(next.address).NotTo(Equal(leaving))
+			Eventually(func() interface{} {
+				return proxies[survivor].Ask(singletonWhere{})
+			}, 3*time.Second).Should(Equal(singletonWhere{next.address}))
+		})
+	})
+})
@@ -0,0 +1,121 @@
+package actors
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/golang/protobuf/proto"
+	"github.com/kphelps/streams/streams"
+)
+
+// Tagged wraps an event passed to Persist so that it is also indexed under
+// each of its tags. Only the wrapped event is stored and replayed.
+type Tagged struct {
+	Event proto.Message
+	Tags  []string
+}
+
+func (t *Tagged) Reset() {
+	t.Event.Reset()
+}
+
+func (t *Tagged) String() string {
+	return t.Event.String()
+}
+
+func (t *Tagged) ProtoMessage() {
+}
+
+// A PersistentActor implementing EventTagger has every persisted event
+// tagged with the result of Tags.
+type EventTagger interface {
+	Tags(event proto.Message) []string
+}
+
+func untagEvent(event proto.Message) (proto.Message, []string) {
+	tagged, ok := event.(*Tagged)
+	if !ok {
+		return event, nil
+	}
+	return tagged.Event, tagged.Tags
+}
+
+func compareOffsets(a gocql.UUID, b gocql.UUID) int {
+	if a.Timestamp() < b.Timestamp() {
+		return -1
+	} else if a.Timestamp() > b.Timestamp() {
+		return 1
+	}
+	return bytes.Compare(a.Bytes(), b.Bytes())
+}
+
+// Hands out strictly increasing time-UUID offsets for providers that order
+// writes in-process.
+type offsetClock struct {
+	last gocql.UUID
+}
+
+func (oc *offsetClock) next() gocql.UUID {
+	offset := gocql.TimeUUID()
+	if compareOffsets(offset, oc.last) <= 0 {
+		offset = gocql.UUIDFromTime(oc.last.Time().Add(100 * time.Nanosecond))
+	}
+	oc.last = offset
+	return offset
+}
+
+func (oc *offsetClock) observe(offset gocql.UUID) {
+	if compareOffsets(offset, oc.last) > 0 {
+		oc.last = offset
+	}
+}
+
+func NewTagEventSource(
+	tag string,
+	offset gocql.UUID,
+	consistencyDelay time.Duration,
+) streams.Source {
+	pp := GetPersistenceProvider()
+	notifier, _ := pp.(EventNotifier)
+	waiter := newPollWaiter()
+	buffer := make([]PersistentEvent, 0)
+	return streams.NewSource(func() PersistentEvent {
+		for {
+			var written <-chan struct{}
+			if len(buffer) == 0 {
+				if notifier != nil && consistencyDelay == 0 {
+					written = notifier.EventsWritten()
+				}
+				events, err := pp.EventsByTag(tag, offset, 100)
+				if err == nil {
+					buffer = visibleTagEvents(events, consistencyDelay)
+				}
+			}
+			if len(buffer) > 0 {
+				event := buffer[0]
+				buffer = buffer[1:]
+				offset = event.Offset
+				waiter.reset()
+				return event
+			}
+			waiter.wait(written)
+		}
+	})
+}
+
+// Writes to a tag can become visible out of offset order, so events newer
+// than the consistency delay are held back rather than risk skipping one
+// that has not shown up yet.
+func visibleTagEvents(
+	events []PersistentEvent,
+	consistencyDelay time.Duration,
+) []PersistentEvent {
+	cutoff := time.Now().Add(-consistencyDelay)
+	for i, event := range events {
+		if event.Offset.Time().After(cutoff) {
+			return events[:i]
+		}
+	}
+	return events
+}
@@ -0,0 +1,132 @@
+package actors
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const defaultMaxFrameSize = 8 * 1024 * 1024
+
+// Every frame on the wire is a 4 byte big-endian length followed by that
+// many bytes of remoteEnvelope.
+type remoteEnvelope struct {
+	recipient string
+	sender    string
+	manifest  string
+	payload   []byte
+	// Unix nanoseconds, or zero when the message has no deadline.
+	deadline int64
+}
+
+func writeFrame(w io.Writer, frame []byte) error {
+	header := make([]byte, 4)
+	binary.BigEndian.PutUint32(header, uint32(len(frame)))
+	_, err := w.Write(append(header, frame...))
+	return err
+}
+
+func readFrame(r io.Reader, maxSize int) ([]byte, error) {
+	header := make([]byte, 4)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(header)
+	if int(size) > maxSize {
+		return nil, fmt.Errorf("frame of %d bytes exceeds limit", size)
+	}
+	frame := make([]byte, size)
+	_, err = io.ReadFull(r, frame)
+	return frame, err
+}
+
+func (re *remoteEnvelope) encode() []byte {
+	fields := [][]byte{
+		[]byte(re.recipient),
+		[]byte(re.sender),
+		[]byte(re.manifest),
+		re.payload,
+	}
+	frame := make([]byte, 0)
+	for _, field := range fields {
+		frame = appendUvarint(frame, uint64(len(field)))
+		frame = append(frame, field...)
+	}
+	return appendUvarint(frame, uint64(re.deadline))
+}
+
+func decodeRemoteEnvelope(frame []byte) (remoteEnvelope, error) {
+	fields := make([][]byte, 4)
+	for i := range fields {
+		length, n := binary.Uvarint(frame)
+		if n <= 0 || uint64(len(frame)-n) < length {
+			return remoteEnvelope{}, errors.New("malformed frame")
+		}
+		fields[i] = frame[n : n+int(length)]
+		frame = frame[n+int(length):]
+	}
+	deadline, n := binary.Uvarint(frame)
+	if n <= 0 {
+		return remoteEnvelope{}, errors.New("malformed frame")
+	}
+	return remoteEnvelope{
+		recipient: string(fields[0]),
+		sender:    string(fields[1]),
+		manifest:  string(fields[2]),
+		payload:   fields[3],
+		deadline:  int64(deadline),
+	}, nil
+}
+
+func appendUvarint(buffer []byte, value uint64) []byte {
+	scratch := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(scratch, value)
+	return append(buffer, scratch[:n]...)
+}
+
+// Outbound connections only carry frames one way; the peer answers over its
+// own connection to our listener.
+type remoteConnection struct {
+	sync.Mutex
+	address string
+	conn    net.Conn
+	writer  *bufio.Writer
+}
+
+func dialRemote(
+	ctx context.Context,
+	address string,
+	timeout time.Duration,
+) (*remoteConnection, error) {
+	dialer := net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	return &remoteConnection{
+		address: address,
+		conn:    conn,
+		writer:  bufio.NewWriter(conn),
+	}, nil
+}
+
+func (rc *remoteConnection) send(envelope remoteEnvelope) error {
+	rc.Lock()
+	defer rc.Unlock()
+	err := writeFrame(rc.writer, envelope.encode())
+	if err != nil {
+		return err
+	}
+	return rc.writer.Flush()
+}
+
+func (rc *remoteConnection) close() error {
+	return rc.conn.Close()
+}
@@ -0,0 +1,166 @@
+package actors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// TypedRef is an ActorRef that only accepts messages of type M.
+type TypedRef[M any] struct {
+	ref ActorRef
+}
+
+// NewTypedRef wraps an untyped ref. Nothing checks that the actor behind it
+// actually handles M.
+func NewTypedRef[M any](ref ActorRef) TypedRef[M] {
+	return TypedRef[M]{ref: ref}
+}
+
+func (tr TypedRef[M]) Send(message M) {
+	tr.ref.Send(message)
+}
+
+func (tr TypedRef[M]) SendFrom(message M, sender ActorRef) {
+	tr.ref.SendFrom(message, sender)
+}
+
+func (tr TypedRef[M]) SendContext(ctx context.Context, message M) {
+	tr.ref.SendContext(ctx, message)
+}
+
+func (tr TypedRef[M]) Stop() {
+	tr.ref.Stop()
+}
+
+func (tr TypedRef[M]) StopAndWait() {
+	tr.ref.StopAndWait()
+}
+
+func (tr TypedRef[M]) Untyped() ActorRef {
+	return tr.ref
+}
+
+type TypedContext[M any] interface {
+	Context() context.Context
+	Message() M
+	Self() TypedRef[M]
+	Sender() ActorRef
+	Reply(message interface{})
+	Forward(message interface{}, target ActorRef)
+	Untyped() ActorContext
+}
+
+type Behavior[M any] interface {
+	Receive(context TypedContext[M])
+}
+
+type BehaviorFunc[M any] func(context TypedContext[M])
+
+func (f BehaviorFunc[M]) Receive(context TypedContext[M]) {
+	f(context)
+}
+
+// Behaviors implementing TypedLifecycle are told when their actor starts
+// and stops.
+type TypedLifecycle[M any] interface {
+	OnStart(context TypedContext[M])
+	OnStop(context TypedContext[M])
+}
+
+type typedContext[M any] struct {
+	ActorContext
+}
+
+func (tc typedContext[M]) Message() M {
+	return tc.ActorContext.Message().(M)
+}
+
+func (tc typedContext[M]) Self() TypedRef[M] {
+	return NewTypedRef[M](tc.ActorContext.Self())
+}
+
+func (tc typedContext[M]) Untyped() ActorContext {
+	return tc.ActorContext
+}
+
+type typedActor[M any] struct {
+	behavior Behavior[M]
+}
+
+// NewTypedActor adapts a behavior to the untyped Actor interface. Messages
+// that are not an M, which can only arrive through an untyped ref, are
+// dropped.
+func NewTypedActor[M any](behavior Behavior[M]) Actor {
+	return &typedActor[M]{behavior: behavior}
+}
+
+func SpawnTyped[M any](behavior Behavior[M]) TypedRef[M] {
+	return NewTypedRef[M](SpawnActor(NewTypedActor(behavior)))
+}
+
+func (ta *typedActor[M]) OnStart(context ActorContext) {
+	lifecycle, ok := ta.behavior.(TypedLifecycle[M])
+	if ok {
+		lifecycle.OnStart(typedContext[M]{context})
+	}
+}
+
+func (ta *typedActor[M]) OnStop(context ActorContext) {
+	lifecycle, ok := ta.behavior.(TypedLifecycle[M])
+	if ok {
+		lifecycle.OnStop(typedContext[M]{context})
+	}
+}
+
+func (ta *typedActor[M]) Receive(context ActorContext) {
+	_, ok := context.Message().(M)
+	if !ok {
+		return
+	}
+	ta.behavior.Receive(typedContext[M]{context})
+}
+
+// AdaptRef returns a ref accepting From that converts each message with
+// adapt and sends it on to target. The ref is backed by an actor of its own,
+// which runs until the returned stop func is called.
+func AdaptRef[From any, To any](
+	target TypedRef[To],
+	adapt func(From) To,
+) (TypedRef[From], func()) {
+	ref := SpawnTyped[From](BehaviorFunc[From](func(context TypedContext[From]) {
+		target.SendFrom(adapt(context.Message()), context.Sender())
+	}))
+	return ref, ref.Stop
+}
+
+// TypedAsk sends the request built by makeRequest and waits for a response
+// on the replyTo ref it was given, so both sides of the exchange are
+// checked by the compiler.
+func TypedAsk[Req any, Resp any](
+	ref TypedRef[Req],
+	makeRequest func(replyTo TypedRef[Resp]) Req,
+	timeout time.Duration,
+) (Resp, error) {
+	responses := make(chan interface{}, 1)
+	askRef := SpawnActor(&ChannelActor{responses})
+	defer askRef.Stop()
+
+	ref.Send(makeRequest(NewTypedRef[Resp](askRef)))
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	var response Resp
+	select {
+	case message := <-responses:
+		response, ok := message.(Resp)
+		if !ok {
+			return response, fmt.Errorf("unexpected reply of type %T", message)
+		}
+		return response, nil
+	case <-timer.C:
+		return response, errors.New("Ask timed out")
+	}
+}
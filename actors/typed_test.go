@@ -0,0 +1,137 @@
+package actors_test
+
+import (
+	"time"
+
+	. "github.com/kphelps/actors/actors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type counterCommand interface{}
+
+type incrementCounter struct {
+	amount int
+}
+
+type getCounter struct {
+	replyTo TypedRef[int]
+}
+
+type counterBehavior struct {
+	count   int
+	started chan struct{}
+}
+
+func (cb *counterBehavior) Receive(context TypedContext[counterCommand]) {
+	switch message := context.Message().(type) {
+	case incrementCounter:
+		cb.count += message.amount
+	case getCounter:
+		message.replyTo.Send(cb.count)
+	}
+}
+
+func (cb *counterBehavior) OnStart(context TypedContext[counterCommand]) {
+	close(cb.started)
+}
+
+func (cb *counterBehavior) OnStop(context TypedContext[counterCommand]) {
+}
+
+var _ = Describe("Typed actors", func() {
+	var behavior *counterBehavior
+	var counter TypedRef[counterCommand]
+
+	getCount := func() int {
+		count, err := TypedAsk(
+			counter,
+			func(replyTo TypedRef[int]) counterCommand {
+				return getCounter{replyTo}
+			},
+			time.Second,
+		)
+		Expect(err).NotTo(HaveOccurred())
+		return count
+	}
+
+	BeforeEach(func() {
+		behavior = &counterBehavior{started: make(chan struct{})}
+		counter = SpawnTyped[counterCommand](behavior)
+	})
+
+	AfterEach(func() {
+		counter.Stop()
+	})
+
+	It("Runs lifecycle hooks", func() {
+		Eventually(behavior.started).Should(BeClosed())
+	})
+
+	It("Asks with a typed reply", func() {
+		counter.Send(incrementCounter{2})
+		counter.Send(incrementCounter{3})
+		Expect(getCount()).To(Equal(5))
+	})
+
+	It("Times out an unanswered ask", func() {
+		silent := SpawnTyped[string](BehaviorFunc[string](
+			func(context TypedContext[string]) {},
+		))
+		defer silent.Stop()
+		_, err := TypedAsk(
+			silent,
+			func(replyTo TypedRef[int]) string { return "ping" },
+			10*time.Millisecond,
+		)
+		Expect(err).To(MatchError("Ask timed out"))
+	})
+
+	It("Drops messages of the wrong type sent untyped", func() {
+		counter.Untyped().Send("not a command")
+		counter.Send(incrementCounter{1})
+		Expect(getCount()).To(Equal(1))
+	})
+
+	It("Adapts messages for another ref", func() {
+		increments, stop := AdaptRef(counter, func(amount int) counterCommand {
+			return incrementCounter{amount}
+		})
+		defer stop()
+		increments.Send(4)
+		Eventually(getCount).Should(Equal(4))
+	})
+
+	It("Stops the adapter", func() {
+		increments, stop := AdaptRef(counter, func(amount int) counterCommand {
+			return incrementCounter{amount}
+		})
+		stop()
+		increments.Send(4)
+		Consistently(getCount).Should(Equal(0))
+	})
+
+	It("Fails an ask answered with the wrong type", func() {
+		confused := SpawnTyped[TypedRef[int]](BehaviorFunc[TypedRef[int]](
+			func(context TypedContext[TypedRef[int]]) {
+				context.Message().Untyped().Send("pong")
+			},
+		))
+		defer confused.Stop()
+		_, err := TypedAsk(
+			confused,
+			func(replyTo TypedRef[int]) TypedRef[int] { return replyTo },
+			time.Second,
+		)
+		Expect(err).To(MatchError("unexpected reply of type string"))
+	})
+
+	It("Wraps an untyped ref", func() {
+		output := make(chan interface{}, 1)
+		ref := NewTypedRef[string](SpawnActor(&ChannelActor{output}))
+		defer ref.Stop()
+		ref.Send("hello")
+		Eventually(output).Should(Receive(Equal("hello")))
+	})
+})
@@ -0,0 +1,581 @@
+package persistencetck
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/kphelps/actors/actors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type ProviderFactory func() actors.PersistenceProvider
+
+var actorCounter uint64
+
+// DescribeProvider registers the conformance specs for a persistence
+// provider. newProvider is called before every spec and must return an
+// initialized provider; if it also implements io.Closer it is closed after
+// the spec.
+func DescribeProvider(name string, newProvider ProviderFactory) bool {
+	return Describe(name+" conformance", func() {
+		var provider actors.PersistenceProvider
+
+		BeforeEach(func() {
+			provider = newProvider()
+		})
+
+		AfterEach(func() {
+			closer, ok := provider.(io.Closer)
+			if ok {
+				Expect(closer.Close()).To(Succeed())
+			}
+		})
+
+		describeReads(func() actors.PersistenceProvider { return provider })
+		describeWrites(func() actors.PersistenceProvider { return provider })
+		describeDeletion(
+			"Logical deletion",
+			actors.LogicalDeletion,
+			func() actors.PersistenceProvider { return provider },
+		)
+		describeDeletion(
+			"Physical deletion",
+			actors.PhysicalDeletion,
+			func() actors.PersistenceProvider { return provider },
+		)
+		describeTags(func() actors.PersistenceProvider { return provider })
+		describePersistenceIDs(func() actors.PersistenceProvider { return provider })
+		describeNotifications(func() actors.PersistenceProvider { return provider })
+		describeContext(func() actors.PersistenceProvider { return provider })
+	})
+}
+
+func NewActorID() string {
+	return fmt.Sprintf(
+		"tck-%d-%d",
+		time.Now().UnixNano(),
+		atomic.AddUint64(&actorCounter, 1),
+	)
+}
+
+func Event(actorID string, sequenceID uint64) proto.Message {
+	return &wrappers.StringValue{
+		Value: fmt.Sprintf("%s/%d", actorID, sequenceID),
+	}
+}
+
+func PersistN(
+	provider actors.PersistenceProvider,
+	actorID string,
+	from uint64,
+	count int,
+) {
+	for i := uint64(0); i < uint64(count); i++ {
+		err := provider.PersistEvent(actorID, from+i, Event(actorID, from+i))
+		ExpectWithOffset(1, err).NotTo(HaveOccurred())
+	}
+}
+
+func PersistTagged(
+	provider actors.PersistenceProvider,
+	actorID string,
+	sequenceID uint64,
+	tags ...string,
+) {
+	err := provider.PersistEvent(actorID, sequenceID, &actors.Tagged{
+		Event: Event(actorID, sequenceID),
+		Tags:  tags,
+	})
+	ExpectWithOffset(1, err).NotTo(HaveOccurred())
+}
+
+func ExpectEvents(
+	provider actors.PersistenceProvider,
+	actorID string,
+	from uint64,
+	to uint64,
+) {
+	events, err := provider.GetEvents(actorID, from)
+	ExpectWithOffset(1, err).NotTo(HaveOccurred())
+	ExpectWithOffset(1, events).To(HaveLen(int(to - from)))
+	for i, event := range events {
+		sequenceID := from + uint64(i)
+		ExpectWithOffset(1, event.SequenceID).To(Equal(sequenceID))
+		ExpectWithOffset(1, proto.Equal(
+			event.Event,
+			Event(actorID, sequenceID),
+		)).To(BeTrue(), "event %d does not match", sequenceID)
+	}
+}
+
+func describeReads(provider func() actors.PersistenceProvider) {
+	Describe("Reads", func() {
+		var actorID string
+
+		BeforeEach(func() {
+			actorID = NewActorID()
+		})
+
+		It("Reports an unknown actor as not found", func() {
+			_, err := provider().GetEvent(actorID, 0)
+			Expect(err).To(MatchError("not found"))
+			_, err = provider().MaxSequenceID(actorID)
+			Expect(err).To(MatchError("not found"))
+			events, err := provider().GetEvents(actorID, 0)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(events).To(BeEmpty())
+		})
+
+		It("Reads a single event", func() {
+			PersistN(provider(), actorID, 0, 3)
+			event, err := provider().GetEvent(actorID, 1)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(event.SequenceID).To(Equal(uint64(1)))
+			Expect(proto.Equal(event.Event, Event(actorID, 1))).To(BeTrue())
+		})
+
+		It("Reports a sequence ID past the end as not found", func() {
+			PersistN(provider(), actorID, 0, 3)
+			_, err := provider().GetEvent(actorID, 3)
+			Expect(err).To(MatchError("not found"))
+		})
+
+		It("Returns events in sequence order", func() {
+			PersistN(provider(), actorID, 0, 15)
+			ExpectEvents(provider(), actorID, 0, 15)
+		})
+
+		It("Returns events from a starting sequence ID", func() {
+			PersistN(provider(), actorID, 0, 15)
+			ExpectEvents(provider(), actorID, 7, 15)
+		})
+
+		It("Returns nothing when starting past the end", func() {
+			PersistN(provider(), actorID, 0, 3)
+			events, err := provider().GetEvents(actorID, 10)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(events).To(BeEmpty())
+		})
+
+		It("Returns the highest sequence ID", func() {
+			PersistN(provider(), actorID, 0, 12)
+			max, err := provider().MaxSequenceID(actorID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(max).To(Equal(uint64(11)))
+		})
+
+		It("Keeps actors isolated", func() {
+			otherID := NewActorID()
+			PersistN(provider(), actorID, 0, 3)
+			PersistN(provider(), otherID, 0, 5)
+			ExpectEvents(provider(), actorID, 0, 3)
+			ExpectEvents(provider(), otherID, 0, 5)
+		})
+
+		It("Handles large histories", func() {
+			PersistN(provider(), actorID, 0, 500)
+			ExpectEvents(provider(), actorID, 0, 500)
+			ExpectEvents(provider(), actorID, 450, 500)
+			max, err := provider().MaxSequenceID(actorID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(max).To(Equal(uint64(499)))
+		})
+	})
+}
+
+func describeWrites(provider func() actors.PersistenceProvider) {
+	Describe("Writes", func() {
+		var actorID string
+
+		BeforeEach(func() {
+			actorID = NewActorID()
+		})
+
+		It("Starts new actors at sequence ID 0", func() {
+			err := provider().PersistEvent(actorID, 1, Event(actorID, 1))
+			Expect(err).To(HaveOccurred())
+			_, err = provider().MaxSequenceID(actorID)
+			Expect(err).To(MatchError("not found"))
+		})
+
+		It("Rejects gaps", func() {
+			PersistN(provider(), actorID, 0, 3)
+			err := provider().PersistEvent(actorID, 5, Event(actorID, 5))
+			Expect(err).To(HaveOccurred())
+			_, err = provider().GetEvent(actorID, 5)
+			Expect(err).To(HaveOccurred())
+			PersistN(provider(), actorID, 3, 1)
+		})
+
+		It("Rejects sequence conflicts", func() {
+			PersistN(provider(), actorID, 0, 3)
+			err := provider().PersistEvent(actorID, 1, Event(actorID, 100))
+			Expect(err).To(HaveOccurred())
+			ExpectEvents(provider(), actorID, 0, 3)
+		})
+
+		It("Accepts exactly one of several concurrent writers", func() {
+			PersistN(provider(), actorID, 0, 2)
+
+			var wg sync.WaitGroup
+			var successes uint64
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					err := provider().PersistEvent(actorID, 2, Event(actorID, 2))
+					if err == nil {
+						atomic.AddUint64(&successes, 1)
+					}
+				}()
+			}
+			wg.Wait()
+
+			Expect(successes).To(Equal(uint64(1)))
+			ExpectEvents(provider(), actorID, 0, 3)
+		})
+
+		It("Accepts concurrent writers to different actors", func() {
+			actorIDs := make([]string, 10)
+			for i := range actorIDs {
+				actorIDs[i] = NewActorID()
+			}
+
+			var wg sync.WaitGroup
+			for _, id := range actorIDs {
+				wg.Add(1)
+				go func(id string) {
+					defer GinkgoRecover()
+					defer wg.Done()
+					PersistN(provider(), id, 0, 20)
+				}(id)
+			}
+			wg.Wait()
+
+			for _, id := range actorIDs {
+				ExpectEvents(provider(), id, 0, 20)
+			}
+		})
+	})
+}
+
+func describeDeletion(
+	name string,
+	mode actors.DeletionMode,
+	provider func() actors.PersistenceProvider,
+) {
+	Describe(name, func() {
+		var actorID string
+
+		BeforeEach(func() {
+			actorID = NewActorID()
+			PersistN(provider(), actorID, 0, 10)
+		})
+
+		It("Hides deleted events", func() {
+			Expect(provider().DeleteEvents(actorID, 4, mode)).To(Succeed())
+			_, err := provider().GetEvent(actorID, 4)
+			Expect(err).To(MatchError("not found"))
+			ExpectEvents(provider(), actorID, 5, 10)
+			events, err := provider().GetEvents(actorID, 0)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(events).To(HaveLen(5))
+			Expect(events[0].SequenceID).To(Equal(uint64(5)))
+		})
+
+		It("Keeps the maximum sequence ID after deleting everything", func() {
+			Expect(provider().DeleteEvents(actorID, 9, mode)).To(Succeed())
+			events, err := provider().GetEvents(actorID, 0)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(events).To(BeEmpty())
+			max, err := provider().MaxSequenceID(actorID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(max).To(Equal(uint64(9)))
+		})
+
+		It("Clamps deletion to the maximum sequence ID", func() {
+			Expect(provider().DeleteEvents(actorID, 100, mode)).To(Succeed())
+			max, err := provider().MaxSequenceID(actorID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(max).To(Equal(uint64(9)))
+			PersistN(provider(), actorID, 10, 1)
+			ExpectEvents(provider(), actorID, 10, 11)
+		})
+
+		It("Continues the sequence after deletion", func() {
+			Expect(provider().DeleteEvents(actorID, 9, mode)).To(Succeed())
+			err := provider().PersistEvent(actorID, 0, Event(actorID, 0))
+			Expect(err).To(HaveOccurred())
+			err = provider().PersistEvent(actorID, 9, Event(actorID, 9))
+			Expect(err).To(HaveOccurred())
+			PersistN(provider(), actorID, 10, 5)
+			ExpectEvents(provider(), actorID, 10, 15)
+		})
+
+		It("Ignores deleting less than was already deleted", func() {
+			Expect(provider().DeleteEvents(actorID, 6, mode)).To(Succeed())
+			Expect(provider().DeleteEvents(actorID, 2, mode)).To(Succeed())
+			ExpectEvents(provider(), actorID, 7, 10)
+		})
+
+		It("Ignores unknown actors", func() {
+			otherID := NewActorID()
+			Expect(provider().DeleteEvents(otherID, 5, mode)).To(Succeed())
+			_, err := provider().MaxSequenceID(otherID)
+			Expect(err).To(MatchError("not found"))
+			PersistN(provider(), otherID, 0, 1)
+		})
+	})
+}
+
+func describeTags(provider func() actors.PersistenceProvider) {
+	Describe("Tags", func() {
+		var tag string
+		var first string
+		var second string
+
+		BeforeEach(func() {
+			tag = NewActorID()
+			first = NewActorID()
+			second = NewActorID()
+		})
+
+		eventsByTag := func(
+			tag string,
+			offset gocql.UUID,
+			limit int,
+		) []actors.PersistentEvent {
+			events, err := provider().EventsByTag(tag, offset, limit)
+			ExpectWithOffset(1, err).NotTo(HaveOccurred())
+			return events
+		}
+
+		expectTagged := func(
+			events []actors.PersistentEvent,
+			actorID string,
+			sequenceID uint64,
+		) {
+			ExpectWithOffset(1, events).NotTo(BeEmpty())
+			ExpectWithOffset(1, events[0].ActorID).To(Equal(actorID))
+			ExpectWithOffset(1, events[0].SequenceID).To(Equal(sequenceID))
+			ExpectWithOffset(1, proto.Equal(
+				events[0].Event,
+				Event(actorID, sequenceID),
+			)).To(BeTrue())
+		}
+
+		It("Returns nothing for an unknown tag", func() {
+			Expect(eventsByTag(tag, gocql.UUID{}, 10)).To(BeEmpty())
+		})
+
+		It("Returns tagged events across actors in offset order", func() {
+			PersistTagged(provider(), first, 0, tag)
+			PersistTagged(provider(), second, 0, tag)
+			PersistN(provider(), first, 1, 1)
+			PersistTagged(provider(), first, 2, tag)
+
+			events := eventsByTag(tag, gocql.UUID{}, 10)
+			Expect(events).To(HaveLen(3))
+			expectTagged(events[0:], first, 0)
+			expectTagged(events[1:], second, 0)
+			expectTagged(events[2:], first, 2)
+		})
+
+		It("Resumes after an offset", func() {
+			PersistTagged(provider(), first, 0, tag)
+			PersistTagged(provider(), second, 0, tag)
+			PersistTagged(provider(), first, 1, tag)
+
+			events := eventsByTag(tag, gocql.UUID{}, 10)
+			Expect(events).To(HaveLen(3))
+			rest := eventsByTag(tag, events[0].Offset, 10)
+			Expect(rest).To(HaveLen(2))
+			expectTagged(rest[0:], second, 0)
+			expectTagged(rest[1:], first, 1)
+			Expect(eventsByTag(tag, events[2].Offset, 10)).To(BeEmpty())
+		})
+
+		It("Respects the limit", func() {
+			for i := uint64(0); i < 5; i++ {
+				PersistTagged(provider(), first, i, tag)
+			}
+			events := eventsByTag(tag, gocql.UUID{}, 2)
+			Expect(events).To(HaveLen(2))
+			expectTagged(events[1:], first, 1)
+		})
+
+		It("Indexes an event under each of its tags", func() {
+			otherTag := NewActorID()
+			PersistTagged(provider(), first, 0, tag, otherTag)
+			expectTagged(eventsByTag(tag, gocql.UUID{}, 10), first, 0)
+			expectTagged(eventsByTag(otherTag, gocql.UUID{}, 10), first, 0)
+		})
+
+		It("Stores tagged events in the actor's journal", func() {
+			PersistTagged(provider(), first, 0, tag)
+			PersistN(provider(), first, 1, 1)
+			ExpectEvents(provider(), first, 0, 2)
+		})
+
+		It("Hides deleted events", func() {
+			PersistTagged(provider(), first, 0, tag)
+			PersistTagged(provider(), second, 0, tag)
+			PersistTagged(provider(), first, 1, tag)
+			err := provider().DeleteEvents(first, 0, actors.LogicalDeletion)
+			Expect(err).NotTo(HaveOccurred())
+
+			events := eventsByTag(tag, gocql.UUID{}, 10)
+			Expect(events).To(HaveLen(2))
+			expectTagged(events[0:], second, 0)
+			expectTagged(events[1:], first, 1)
+		})
+
+		It("Fills the limit past deleted events", func() {
+			for i := uint64(0); i < 3; i++ {
+				PersistTagged(provider(), first, i, tag)
+			}
+			PersistTagged(provider(), second, 0, tag)
+			PersistTagged(provider(), second, 1, tag)
+			err := provider().DeleteEvents(first, 2, actors.LogicalDeletion)
+			Expect(err).NotTo(HaveOccurred())
+
+			events := eventsByTag(tag, gocql.UUID{}, 2)
+			Expect(events).To(HaveLen(2))
+			expectTagged(events[0:], second, 0)
+			expectTagged(events[1:], second, 1)
+		})
+	})
+}
+
+func describePersistenceIDs(provider func() actors.PersistenceProvider) {
+	Describe("PersistenceIDs", func() {
+		persistenceIDs := func() []string {
+			ids, err := provider().PersistenceIDs()
+			ExpectWithOffset(1, err).NotTo(HaveOccurred())
+			return ids
+		}
+
+		It("Lists every actor with events", func() {
+			first := NewActorID()
+			second := NewActorID()
+			PersistN(provider(), first, 0, 2)
+			PersistN(provider(), second, 0, 1)
+			Expect(persistenceIDs()).To(ContainElement(first))
+			Expect(persistenceIDs()).To(ContainElement(second))
+		})
+
+		It("Does not list actors without events", func() {
+			Expect(persistenceIDs()).NotTo(ContainElement(NewActorID()))
+		})
+
+		It("Lists each actor once", func() {
+			actorID := NewActorID()
+			PersistN(provider(), actorID, 0, 25)
+			count := 0
+			for _, id := range persistenceIDs() {
+				if id == actorID {
+					count++
+				}
+			}
+			Expect(count).To(Equal(1))
+		})
+
+		It("Keeps listing actors whose events were deleted", func() {
+			actorID := NewActorID()
+			PersistN(provider(), actorID, 0, 3)
+			err := provider().DeleteEvents(actorID, 2, actors.PhysicalDeletion)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(persistenceIDs()).To(ContainElement(actorID))
+		})
+	})
+}
+
+// Notifications are optional, so these specs only run against providers
+// implementing actors.EventNotifier.
+func describeNotifications(provider func() actors.PersistenceProvider) {
+	Describe("Notifications", func() {
+		var notifier actors.EventNotifier
+		var actorID string
+
+		BeforeEach(func() {
+			var ok bool
+			notifier, ok = provider().(actors.EventNotifier)
+			if !ok {
+				Skip("provider does not implement EventNotifier")
+			}
+			actorID = NewActorID()
+		})
+
+		It("Wakes subscribers on any write", func() {
+			written := notifier.EventsWritten()
+			Expect(written).NotTo(BeClosed())
+			PersistN(provider(), actorID, 0, 1)
+			Expect(written).To(BeClosed())
+			Expect(notifier.EventsWritten()).NotTo(BeClosed())
+		})
+
+		It("Wakes subscribers to the written actor only", func() {
+			other := NewActorID()
+			written := notifier.ActorEventsWritten(actorID)
+			otherWritten := notifier.ActorEventsWritten(other)
+			PersistN(provider(), actorID, 0, 1)
+			Expect(written).To(BeClosed())
+			Expect(otherWritten).NotTo(BeClosed())
+		})
+
+		It("Does not wake subscribers on a rejected write", func() {
+			PersistN(provider(), actorID, 0, 1)
+			written := notifier.ActorEventsWritten(actorID)
+			err := provider().PersistEvent(actorID, 0, Event(actorID, 0))
+			Expect(err).To(HaveOccurred())
+			Expect(written).NotTo(BeClosed())
+		})
+	})
+}
+
+func describeContext(provider func() actors.PersistenceProvider) {
+	Describe("Context", func() {
+		var contextProvider actors.ContextPersistenceProvider
+		var actorID string
+
+		BeforeEach(func() {
+			contextProvider = actors.NewContextPersistenceProvider(provider())
+			actorID = NewActorID()
+		})
+
+		It("Behaves like the provider with a live context", func() {
+			ctx := context.Background()
+			err := contextProvider.PersistEventContext(ctx, actorID, 0, Event(actorID, 0))
+			Expect(err).NotTo(HaveOccurred())
+			event, err := contextProvider.GetEventContext(ctx, actorID, 0)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(proto.Equal(event.Event, Event(actorID, 0))).To(BeTrue())
+			max, err := contextProvider.MaxSequenceIDContext(ctx, actorID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(max).To(BeZero())
+		})
+
+		It("Fails calls with a cancelled context", func() {
+			PersistN(provider(), actorID, 0, 1)
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			err := contextProvider.PersistEventContext(ctx, actorID, 1, Event(actorID, 1))
+			Expect(err).To(HaveOccurred())
+			_, err = contextProvider.GetEventsContext(ctx, actorID, 0)
+			Expect(err).To(HaveOccurred())
+			_, err = contextProvider.PersistenceIDsContext(ctx)
+			Expect(err).To(HaveOccurred())
+			ExpectEvents(provider(), actorID, 0, 1)
+		})
+	})
+}
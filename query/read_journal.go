@@ -0,0 +1,124 @@
+package query
+
+import (
+	"time"
+
+	"github.com/kphelps/actors/actors"
+)
+
+type Config struct {
+	// How long a live query waits before polling again once it has caught
+	// up. Providers implementing actors.EventNotifier wake it sooner.
+	PollInterval time.Duration
+}
+
+type ReadJournal struct {
+	provider actors.PersistenceProvider
+	config   Config
+}
+
+func NewReadJournal(
+	provider actors.PersistenceProvider,
+	config Config,
+) *ReadJournal {
+	if config.PollInterval <= 0 {
+		config.PollInterval = time.Second
+	}
+	return &ReadJournal{
+		provider: provider,
+		config:   config,
+	}
+}
+
+// DefaultReadJournal reads from the configured persistence provider.
+func DefaultReadJournal() *ReadJournal {
+	return NewReadJournal(actors.GetPersistenceProvider(), Config{})
+}
+
+func (rj *ReadJournal) CurrentEventsByPersistenceID(
+	actorID string,
+	fromSequenceID uint64,
+) *EventStream {
+	return rj.eventsByPersistenceID(actorID, fromSequenceID, false)
+}
+
+func (rj *ReadJournal) EventsByPersistenceID(
+	actorID string,
+	fromSequenceID uint64,
+) *EventStream {
+	return rj.eventsByPersistenceID(actorID, fromSequenceID, true)
+}
+
+func (rj *ReadJournal) CurrentPersistenceIDs() *PersistenceIDStream {
+	return rj.persistenceIDs(false)
+}
+
+func (rj *ReadJournal) PersistenceIDs() *PersistenceIDStream {
+	return rj.persistenceIDs(true)
+}
+
+func (rj *ReadJournal) eventsByPersistenceID(
+	actorID string,
+	sequenceID uint64,
+	live bool,
+) *EventStream {
+	poll := func() ([]interface{}, error) {
+		events, err := rj.provider.GetEvents(actorID, sequenceID)
+		if err != nil {
+			return nil, err
+		}
+		values := make([]interface{}, len(events))
+		for i, event := range events {
+			values[i] = event
+		}
+		if len(events) > 0 {
+			sequenceID = events[len(events)-1].SequenceID + 1
+		}
+		return values, nil
+	}
+	p := rj.newPoller(poll, live)
+	notifier, ok := rj.provider.(actors.EventNotifier)
+	if ok {
+		p.written = func() <-chan struct{} {
+			return notifier.ActorEventsWritten(actorID)
+		}
+	}
+	return newEventStream(p)
+}
+
+func (rj *ReadJournal) persistenceIDs(live bool) *PersistenceIDStream {
+	seen := make(map[string]bool)
+	poll := func() ([]interface{}, error) {
+		ids, err := rj.provider.PersistenceIDs()
+		if err != nil {
+			return nil, err
+		}
+		values := make([]interface{}, 0)
+		for _, id := range ids {
+			if !seen[id] {
+				seen[id] = true
+				values = append(values, id)
+			}
+		}
+		return values, nil
+	}
+	return newPersistenceIDStream(rj.newPoller(poll, live))
+}
+
+func (rj *ReadJournal) newPoller(
+	poll func() ([]interface{}, error),
+	live bool,
+) *poller {
+	written := func() <-chan struct{} { return nil }
+	notifier, ok := rj.provider.(actors.EventNotifier)
+	if ok {
+		written = notifier.EventsWritten
+	}
+	return &poller{
+		poll:     poll,
+		live:     live,
+		interval: rj.config.PollInterval,
+		written:  written,
+		stop:     make(chan struct{}),
+	}
+}
@@ -0,0 +1,161 @@
+package query_test
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/kphelps/actors/actors"
+	. "github.com/kphelps/actors/query"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// Hides the in-memory provider's EventNotifier so queries have to poll.
+type pollingProvider struct {
+	actors.PersistenceProvider
+}
+
+type failingProvider struct {
+	actors.PersistenceProvider
+}
+
+func (failingProvider) GetEvents(string, uint64) ([]actors.PersistentEvent, error) {
+	return nil, errors.New("unavailable")
+}
+
+func (failingProvider) PersistenceIDs() ([]string, error) {
+	return nil, errors.New("unavailable")
+}
+
+var _ = Describe("ReadJournal", func() {
+	var provider actors.PersistenceProvider
+	var journal *ReadJournal
+
+	persist := func(actorID string, sequenceID uint64) {
+		value := fmt.Sprintf("%s-%d", actorID, sequenceID)
+		event := &wrappers.StringValue{Value: value}
+		Expect(provider.PersistEvent(actorID, sequenceID, event)).To(Succeed())
+	}
+
+	receiveEvent := func(stream *EventStream) actors.PersistentEvent {
+		var event actors.PersistentEvent
+		EventuallyWithOffset(1, stream.Output).Should(Receive(&event))
+		return event
+	}
+
+	receiveID := func(stream *PersistenceIDStream) string {
+		var id string
+		EventuallyWithOffset(1, stream.Output).Should(Receive(&id))
+		return id
+	}
+
+	BeforeEach(func() {
+		provider = actors.NewPersistenceProvider()
+		journal = NewReadJournal(provider, Config{PollInterval: time.Minute})
+	})
+
+	Describe("CurrentEventsByPersistenceID", func() {
+		It("Emits the stored events and completes", func() {
+			for i := uint64(0); i < 3; i++ {
+				persist("a", i)
+			}
+			persist("b", 0)
+
+			stream := journal.CurrentEventsByPersistenceID("a", 1)
+			stream.Open()
+			defer stream.Close()
+			Expect(receiveEvent(stream).SequenceID).To(Equal(uint64(1)))
+			Expect(receiveEvent(stream).SequenceID).To(Equal(uint64(2)))
+			Eventually(stream.Output).Should(BeClosed())
+		})
+
+		It("Completes immediately for an unknown actor", func() {
+			stream := journal.CurrentEventsByPersistenceID("a", 0)
+			stream.Open()
+			defer stream.Close()
+			Eventually(stream.Output).Should(BeClosed())
+			Expect(stream.Err()).NotTo(HaveOccurred())
+		})
+
+		It("Fails when the journal can't be read", func() {
+			journal = NewReadJournal(failingProvider{provider}, Config{})
+			stream := journal.CurrentEventsByPersistenceID("a", 0)
+			stream.Open()
+			defer stream.Close()
+			Eventually(stream.Output).Should(BeClosed())
+			Expect(stream.Err()).To(MatchError("unavailable"))
+		})
+	})
+
+	Describe("EventsByPersistenceID", func() {
+		It("Emits stored events and then new ones as they are written", func() {
+			persist("a", 0)
+			stream := journal.EventsByPersistenceID("a", 0)
+			stream.Open()
+			defer stream.Close()
+			Expect(receiveEvent(stream).SequenceID).To(Equal(uint64(0)))
+
+			persist("a", 1)
+			persist("b", 0)
+			event := receiveEvent(stream)
+			Expect(event.ActorID).To(Equal("a"))
+			Expect(event.SequenceID).To(Equal(uint64(1)))
+			Consistently(stream.Output).ShouldNot(Receive())
+		})
+
+		It("Polls providers without notifications", func() {
+			journal = NewReadJournal(
+				pollingProvider{provider},
+				Config{PollInterval: 10 * time.Millisecond},
+			)
+			stream := journal.EventsByPersistenceID("a", 0)
+			stream.Open()
+			defer stream.Close()
+
+			persist("a", 0)
+			Expect(receiveEvent(stream).SequenceID).To(Equal(uint64(0)))
+		})
+	})
+
+	Describe("CurrentPersistenceIDs", func() {
+		It("Lists each actor and completes", func() {
+			persist("a", 0)
+			persist("a", 1)
+			persist("b", 0)
+
+			stream := journal.CurrentPersistenceIDs()
+			stream.Open()
+			defer stream.Close()
+			Expect(receiveID(stream)).To(Equal("a"))
+			Expect(receiveID(stream)).To(Equal("b"))
+			Eventually(stream.Output).Should(BeClosed())
+		})
+
+		It("Fails when the journal can't be read", func() {
+			journal = NewReadJournal(failingProvider{provider}, Config{})
+			stream := journal.CurrentPersistenceIDs()
+			stream.Open()
+			defer stream.Close()
+			Eventually(stream.Output).Should(BeClosed())
+			Expect(stream.Err()).To(MatchError("unavailable"))
+		})
+	})
+
+	Describe("PersistenceIDs", func() {
+		It("Emits new actors as they appear", func() {
+			persist("a", 0)
+			stream := journal.PersistenceIDs()
+			stream.Open()
+			defer stream.Close()
+			Expect(receiveID(stream)).To(Equal("a"))
+
+			persist("a", 1)
+			persist("b", 0)
+			Expect(receiveID(stream)).To(Equal("b"))
+			Consistently(stream.Output).ShouldNot(Receive())
+		})
+	})
+})
@@ -0,0 +1,169 @@
+package query
+
+import (
+	"sync"
+	"time"
+
+	"github.com/kphelps/actors/actors"
+	"github.com/kphelps/streams/streams"
+)
+
+type queryItem struct {
+	value interface{}
+	done  bool
+	err   error
+}
+
+// poller feeds a query's stream. Live queries poll forever, retrying failed
+// polls; current queries stop after the first poll has been drained, or as
+// soon as it fails.
+type poller struct {
+	poll     func() ([]interface{}, error)
+	live     bool
+	interval time.Duration
+	// Returns a channel closed by the next relevant write, or nil.
+	written func() <-chan struct{}
+	stop    chan struct{}
+	buffer  []interface{}
+	polled  bool
+}
+
+func (p *poller) next() queryItem {
+	for {
+		if len(p.buffer) > 0 {
+			value := p.buffer[0]
+			p.buffer = p.buffer[1:]
+			return queryItem{value: value}
+		}
+		if p.polled && !p.live {
+			return queryItem{done: true}
+		}
+
+		// Subscribe before polling so a write landing in between still
+		// wakes us.
+		written := p.written()
+		values, err := p.poll()
+		if err != nil && !p.live {
+			return queryItem{done: true, err: err}
+		}
+		if err == nil {
+			p.polled = true
+			p.buffer = values
+			if len(values) > 0 || !p.live {
+				continue
+			}
+		}
+
+		select {
+		case <-written:
+		case <-time.After(p.interval):
+		case <-p.stop:
+			return queryItem{done: true}
+		}
+	}
+}
+
+type queryStream struct {
+	sync.Mutex
+	stream streams.RunnableStream
+	stop   chan struct{}
+	once   sync.Once
+	err    error
+}
+
+func (qs *queryStream) Open() {
+	qs.stream.Open()
+}
+
+func (qs *queryStream) Close() {
+	qs.once.Do(func() {
+		close(qs.stop)
+		qs.stream.Close()
+	})
+}
+
+// Err is the error that ended a current query early, if any. It is set
+// before Output is closed.
+func (qs *queryStream) Err() error {
+	qs.Lock()
+	defer qs.Unlock()
+	return qs.err
+}
+
+func (qs *queryStream) attach(p *poller, emit func(interface{})) {
+	done := false
+	source := streams.NewSource(func() queryItem {
+		if done {
+			<-qs.stop
+			return queryItem{done: true}
+		}
+		return p.next()
+	})
+	sink := streams.NewSink(func(item queryItem) {
+		if done {
+			return
+		}
+		if item.done {
+			done = true
+			qs.Lock()
+			qs.err = item.err
+			qs.Unlock()
+			emit(nil)
+			return
+		}
+		emit(item.value)
+	})
+	qs.stream = source.AttachSink(sink)
+}
+
+// EventStream delivers the events of a query on Output. Output is closed
+// once a current query has reached the end of the journal or failed, in
+// which case Err reports why.
+type EventStream struct {
+	queryStream
+	Output chan actors.PersistentEvent
+}
+
+func newEventStream(p *poller) *EventStream {
+	es := &EventStream{
+		queryStream: queryStream{stop: p.stop},
+		Output:      make(chan actors.PersistentEvent),
+	}
+	es.attach(p, func(value interface{}) {
+		if value == nil {
+			close(es.Output)
+			return
+		}
+		select {
+		case es.Output <- value.(actors.PersistentEvent):
+		case <-es.stop:
+		}
+	})
+	return es
+}
+
+// PersistenceIDStream delivers each persistence ID once on Output. Output is
+// closed once a current query has listed every ID or failed, in which case
+// Err reports why.
+type PersistenceIDStream struct {
+	queryStream
+	Output chan string
+}
+
+func newPersistenceIDStream(p *poller) *PersistenceIDStream {
+	ps := &PersistenceIDStream{
+		queryStream: queryStream{stop: p.stop},
+		Output:      make(chan string),
+	}
+	ps.attach(p, func(value interface{}) {
+		if value == nil {
+			close(ps.Output)
+			return
+		}
+		select {
+		case ps.Output <- value.(string):
+		case <-ps.stop:
+		}
+	})
+	return ps
+}